@@ -3,23 +3,123 @@ package authenticationmaster
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
+	"slices"
 
 	"github.com/go-chi/jwtauth/v5"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+var (
+	errTokenRevoked        error = errors.New("token is revoked")
+	errTokenIssuedToClient error = errors.New("token is issued to a client")
 )
 
 type authorizedUserData struct {
 	UserID   string
 	Username string
+	Roles    []string
+	Groups   []string
+
+	// Named as the OpenID Connect standard claims, as for tokens and the UserInfo endpoint.
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// Find the access token in the request header (or cookie) and verify it, as verifyClientRequest,
+// then check the token was issued directly to the user rather than to an OpenID Connect client.
+//
+// Endpoints acting on the account of the user only accept these first party tokens, so a client cannot act on the account
+// with the tokens it was issued. Returns errTokenIssuedToClient (alongside the token) for tokens issued to a client.
+func (authMaster *AuthenticationMaster) verifyRequest(r *http.Request) (jwt.Token, error) {
+	token, err := authMaster.verifyClientRequest(r)
+	if err != nil {
+		return token, err
+	}
+	if !authMaster.isFirstPartyToken(token) {
+		return token, errTokenIssuedToClient
+	}
+	return token, nil
+}
+
+// Find the access token in the request header (or cookie) and verify it, accepting tokens issued to OpenID Connect clients.
+//
+// Mirrors jwtauth.VerifyRequest, but selects the verification key using the `kid` header of the token,
+// and checks the token against the revoked tokens. See verifyAccessToken.
+func (authMaster *AuthenticationMaster) verifyClientRequest(r *http.Request) (jwt.Token, error) {
+	var tokenString string
+	for _, findTokenFn := range []func(r *http.Request) string{jwtauth.TokenFromHeader, jwtauth.TokenFromCookie} {
+		tokenString = findTokenFn(r)
+		if tokenString != "" {
+			break
+		}
+	}
+	if tokenString == "" {
+		return nil, jwtauth.ErrNoTokenFound
+	}
+
+	return authMaster.verifyAccessToken(tokenString)
 }
 
-// Authenticate a request by checking the JWT in the request header (or cookie).
+// Verify an access token, using the configured session backend.
+// A JWT is verified by verifyToken, and an opaque session ID by verifySession.
+func (authMaster *AuthenticationMaster) verifyAccessToken(tokenString string) (jwt.Token, error) {
+	if authMaster.sessionBackend == OpaqueSessions {
+		return authMaster.verifySession(tokenString)
+	}
+	return authMaster.verifyToken(tokenString)
+}
+
+// Verify an access token string against the keyring, check it was issued by this server, then check it has not been revoked.
+//
+// Returns errTokenRevoked (alongside the token) if the token is valid but has been revoked.
+func (authMaster *AuthenticationMaster) verifyToken(tokenString string) (jwt.Token, error) {
+	token, err := authMaster.keyring.verifyToken(tokenString)
+	if err != nil {
+		return token, err
+	}
+
+	err = jwt.Validate(token, jwt.WithIssuer(authMaster.issuer))
+	if err != nil {
+		return token, jwtauth.ErrorReason(err)
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	revoked, err := authMaster.databaseConnection.IsTokenRevoked(databaseQueryContext, token.JwtID())
+	if err != nil {
+		return token, err
+	}
+	if revoked {
+		return token, errTokenRevoked
+	}
+
+	return token, nil
+}
+
+// Whether a token was issued directly to the user (by logging in), rather than to an OpenID Connect client.
+// Tokens issued to a client name that client (the `client_id` claim, also set for sessions of a client),
+// and are for the audience of that client.
+func (authMaster *AuthenticationMaster) isFirstPartyToken(token jwt.Token) bool {
+	if privateClaimString(token, "client_id") != "" {
+		return false
+	}
+	audience := token.Audience()
+	return len(audience) == 0 || (len(audience) == 1 && audience[0] == authMaster.issuer)
+}
+
+// Authenticate a request by checking the access token in the request header (or cookie).
+//
+// If the `audience` query parameter is given, the token must also be for that audience (the `aud` claim),
+// so a service only accepts tokens issued to its own clients. Otherwise, only first party tokens are accepted.
 //
 // Note this effectively reimplements the logic of the go-chi jwtauth Verifier middleware,
 // but exposes the logic on a route rather than as middleware. https://pkg.go.dev/github.com/go-chi/jwtauth/v5@v5.3.0#Verifier
 func (authMaster *AuthenticationMaster) AuthenticateRequest(w http.ResponseWriter, r *http.Request) {
-	token, err := jwtauth.VerifyRequest(authMaster.tokenAuth, r, jwtauth.TokenFromHeader, jwtauth.TokenFromCookie)
+	token, err := authMaster.verifyClientRequest(r)
 
 	if token == nil {
 		slog.Debug("No token received.")
@@ -32,7 +132,10 @@ func (authMaster *AuthenticationMaster) AuthenticateRequest(w http.ResponseWrite
 	//
 	// https://pkg.go.dev/github.com/go-chi/jwtauth/v5@v5.3.0#VerifyToken
 	// https://pkg.go.dev/github.com/go-chi/jwtauth/v5@v5.3.0#ErrorReason
+	//
+	// along with errTokenRevoked, from checking the revoked tokens.
 	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
 		switch err {
 		case jwtauth.ErrExpired:
 			w.Write([]byte("Token is expired."))
@@ -40,17 +143,32 @@ func (authMaster *AuthenticationMaster) AuthenticateRequest(w http.ResponseWrite
 			w.Write([]byte("Token issued time invalid."))
 		case jwtauth.ErrNBFInvalid:
 			w.Write([]byte("Token not yet valid."))
+		case errTokenRevoked:
+			w.Write([]byte("Token is revoked."))
 		default:
 			w.Write([]byte("Token unauthorized."))
 		}
+		return
+	}
+
+	requiredAudience := r.URL.Query().Get("audience")
+	if requiredAudience != "" && !slices.Contains(token.Audience(), requiredAudience) {
+		slog.Debug("Token audience does not match required audience", "Audience", token.Audience(), "RequiredAudience", requiredAudience)
 		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token audience invalid."))
+		return
+	}
+	if requiredAudience == "" && !authMaster.isFirstPartyToken(token) {
+		slog.Debug("Token issued to a client without required audience", "Audience", token.Audience())
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token audience invalid."))
 		return
 	}
 
 	// Extract the UserID from the token
 	userID := token.Subject()
 
-	// Query the database and get the username from it
+	// Query the database and get the username, roles, groups, and email from it
 	ctx := context.Background()
 	username, err := authMaster.databaseConnection.GetUsernameByUserID(ctx, userID)
 	if err != nil {
@@ -58,11 +176,34 @@ func (authMaster *AuthenticationMaster) AuthenticateRequest(w http.ResponseWrite
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
+	roles, err := authMaster.databaseConnection.GetUserRoles(ctx, userID)
+	if err != nil {
+		slog.Error("Error during retrieval of roles", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	groups, err := authMaster.databaseConnection.GetUserGroups(ctx, userID)
+	if err != nil {
+		slog.Error("Error during retrieval of groups", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	email, emailVerified, err := authMaster.databaseConnection.GetUserEmail(ctx, userID)
+	if err != nil {
+		slog.Error("Error during retrieval of email", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
 	// Send the result as the response
 	userData := authorizedUserData{
 		UserID:   userID,
 		Username: username,
+		Roles:    roles,
+		Groups:   groups,
+
+		Email:         email,
+		EmailVerified: emailVerified,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(userData)
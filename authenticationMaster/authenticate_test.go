@@ -0,0 +1,40 @@
+package authenticationmaster
+
+import (
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+func TestIsFirstPartyToken(t *testing.T) {
+	authMaster := &AuthenticationMaster{issuer: "https://sso.example.com"}
+
+	firstPartyTokens := map[string]jwt.Token{
+		"no audience":     buildTestToken(t, jwt.NewBuilder()),
+		"issuer audience": buildTestToken(t, jwt.NewBuilder().Audience([]string{"https://sso.example.com"})),
+	}
+	for name, token := range firstPartyTokens {
+		if !authMaster.isFirstPartyToken(token) {
+			t.Errorf("Token with %v is not first party", name)
+		}
+	}
+
+	clientTokens := map[string]jwt.Token{
+		"client_id":       buildTestToken(t, jwt.NewBuilder().Claim("client_id", "myApp")),
+		"client audience": buildTestToken(t, jwt.NewBuilder().Audience([]string{"https://myapp.example"})),
+		"both audiences":  buildTestToken(t, jwt.NewBuilder().Audience([]string{"https://sso.example.com", "https://myapp.example"})),
+	}
+	for name, token := range clientTokens {
+		if authMaster.isFirstPartyToken(token) {
+			t.Errorf("Token with %v is first party", name)
+		}
+	}
+}
+
+func buildTestToken(t *testing.T, tokenBuilder *jwt.Builder) jwt.Token {
+	token, err := tokenBuilder.Subject("testUser").Build()
+	if err != nil {
+		t.Fatalf("Error while building token: %v", err)
+	}
+	return token
+}
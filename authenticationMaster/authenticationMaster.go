@@ -1,11 +1,13 @@
 package authenticationmaster
 
 import (
+	"net/netip"
 	"time"
 
-	"github.com/go-chi/jwtauth/v5"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/hmcalister/AuthSSO/database"
-	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/hmcalister/AuthSSO/mailer"
+	passwordpolicy "github.com/hmcalister/AuthSSO/passwordPolicy"
 	"github.com/microcosm-cc/bluemonday"
 )
 
@@ -13,7 +15,12 @@ const (
 	passwordMaxLen = 1024
 
 	// Tokens expire after this time. Effectively logs users out, prevents cookie stealing attacks.
-	tokenExpirationDuration time.Duration = 6 * time.Hour
+	// Kept short, as users can get a new token using their refresh token.
+	tokenExpirationDuration time.Duration = 15 * time.Minute
+
+	// Refresh tokens expire after this time. Each refresh token may only be used once, but exchanging it
+	// gives a new refresh token, so a user is only logged out after this long without any activity.
+	refreshTokenExpirationDuration time.Duration = 30 * 24 * time.Hour
 
 	// Maximum time a database query should take before failing.
 	maximumDatabaseQueryDuration = 5 * time.Second
@@ -22,31 +29,47 @@ const (
 // Struct for holding state of authentication. Includes connection to database where credentials are held, and router to accept login / registration attempts.
 type AuthenticationMaster struct {
 	databaseConnection *database.DatabaseManager
-	tokenSecretKey     []byte
-	tokenAuth          *jwtauth.JWTAuth
+	keyring            *Keyring
+	issuer             string
+	cookieConfig       *CookieConfig
+	sessionBackend     SessionBackend
+	tokenClaims        []TokenClaim
+	mailer             mailer.Mailer
 	htmlSanitizer      *bluemonday.Policy
+
+	// The WebAuthn relying party for passkeys. Nil if the issuer is not a URL, in which case passkeys are disabled.
+	webAuthn *webauthn.WebAuthn
+
+	// Proxies trusted to give the client IP in X-Forwarded-For, see SetTrustedProxies.
+	trustedProxies []netip.Prefix
+
+	// The policy new passwords must meet, see SetPasswordPolicy.
+	passwordPolicy passwordpolicy.Policy
 }
 
 // Create a new authentication master.
 //
 // mainRouter is taken to mount the apiRouter to the endpoint "/api".
 // db is a connection to the database holding user credentials.
-// tokenSecretKey is a byte array holding the secret key for signing the JWT.
-func NewAuthenticationMaster(db *database.DatabaseManager, tokenSecretKey []byte) *AuthenticationMaster {
-	// Tokens must be signed using the secret key, be signed with the correct signing method.
-	// Tokens must also be issued by this server, and have a subject claim (the subject is the UserID).
-	tokenAuth := jwtauth.New(tokenSigningMethod.Alg(),
-		tokenSecretKey,
-		tokenSecretKey,
-		jwt.WithIssuer(issuerString),
-		jwt.WithRequiredClaim(jwt.SubjectKey),
-	)
-
+// keyring holds the keys for signing and verifying the JWT.
+// issuer is the `iss` claim of every token issued, and the issuer required of every token verified.
+// cookieConfig enables cookie session mode for browser logins if not nil, see CookieConfig.
+// sessionBackend selects the kind of access token given to users, see SessionBackend.
+// tokenClaims are the claims describing the user added to each JWT access token, see ParseTokenClaims.
+// mailer sends password reset tokens to users. If nil, password reset is disabled.
+//
+// Passkeys (WebAuthn) are enabled only if the issuer is the URL of this server, which gives the relying party ID and origin.
+func NewAuthenticationMaster(db *database.DatabaseManager, keyring *Keyring, issuer string, cookieConfig *CookieConfig, sessionBackend SessionBackend, tokenClaims []TokenClaim, mailer mailer.Mailer) *AuthenticationMaster {
 	authMaster := &AuthenticationMaster{
 		databaseConnection: db,
-		tokenSecretKey:     tokenSecretKey,
-		tokenAuth:          tokenAuth,
+		keyring:            keyring,
+		issuer:             issuer,
+		cookieConfig:       cookieConfig,
+		sessionBackend:     sessionBackend,
+		tokenClaims:        tokenClaims,
+		mailer:             mailer,
 		htmlSanitizer:      bluemonday.UGCPolicy(),
+		webAuthn:           newWebAuthn(issuer),
 	}
 
 	return authMaster
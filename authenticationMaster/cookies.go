@@ -0,0 +1,90 @@
+package authenticationmaster
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+const (
+	// The name of the cookie holding the access token. This is the cookie read by jwtauth.TokenFromCookie.
+	accessTokenCookieName = "jwt"
+
+	// The name of the cookie holding the refresh token.
+	refreshTokenCookieName = "refresh_token"
+)
+
+// Settings for cookie session mode, where browser logins are given their tokens in cookies rather than the response body.
+//
+// The cookies are always Secure and HttpOnly, so they are never sent over plain HTTP (except to localhost)
+// and cannot be read by JavaScript, including any injected by an XSS attack.
+type CookieConfig struct {
+	// The domain the cookies are sent to. If empty, the cookies are only sent to the exact host of this server.
+	Domain string
+
+	// The path the cookies are sent to.
+	Path string
+
+	// Lax or Strict prevents other sites from making requests with the cookies (CSRF).
+	// None should only be used if the cookies must be sent on requests from other sites.
+	SameSite http.SameSite
+}
+
+// Set the access token and refresh token cookies, each expiring with the token it holds.
+func (authMaster *AuthenticationMaster) setTokenCookies(w http.ResponseWriter, accessToken string, accessTokenExpiresIn time.Duration, refreshToken string) {
+	http.SetCookie(w, authMaster.newTokenCookie(accessTokenCookieName, accessToken, int(accessTokenExpiresIn.Seconds())))
+	http.SetCookie(w, authMaster.newTokenCookie(refreshTokenCookieName, refreshToken, int(refreshTokenExpirationDuration.Seconds())))
+}
+
+// Clear the access token and refresh token cookies.
+func (authMaster *AuthenticationMaster) clearTokenCookies(w http.ResponseWriter) {
+	http.SetCookie(w, authMaster.newTokenCookie(accessTokenCookieName, "", -1))
+	http.SetCookie(w, authMaster.newTokenCookie(refreshTokenCookieName, "", -1))
+}
+
+func (authMaster *AuthenticationMaster) newTokenCookie(name string, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Domain:   authMaster.cookieConfig.Domain,
+		Path:     authMaster.cookieConfig.Path,
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: authMaster.cookieConfig.SameSite,
+	}
+}
+
+// Get the refresh token from the refresh token cookie, or an empty string if there is no such cookie.
+func refreshTokenFromCookie(r *http.Request) string {
+	cookie, err := r.Cookie(refreshTokenCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// Issue a new access token for the grant and set it, along with the refresh token, as cookies.
+// The response body only describes the access token, so the tokens are never exposed to JavaScript.
+func (authMaster *AuthenticationMaster) respondWithTokenCookies(w http.ResponseWriter, grant database.Grant, refreshToken string) {
+	token, expiresIn, err := authMaster.issueAccessToken(grant)
+	if err != nil {
+		slog.Error("Error during creation of access token!", "Error", err, "UserID", grant.UserID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during authentication attempt, please try again"))
+		return
+	}
+
+	authMaster.setTokenCookies(w, token, expiresIn, refreshToken)
+
+	response := tokenResponse{
+		TokenType: "Bearer",
+		ExpiresIn: int64(expiresIn.Seconds()),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,103 @@
+package authenticationmaster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+type httpRequestDeleteAccount struct {
+	Password string `json:"password"`
+}
+
+// Delete the account of the user of the access token in the request header (or cookie).
+// The password must be given again, so a stolen access token alone cannot be used to delete the account.
+//
+// The user and everything belonging to them (auth data, refresh tokens, sessions, and so on) are deleted together,
+// and the access token in the request is revoked. In cookie session mode both token cookies are also cleared.
+func (authMaster *AuthenticationMaster) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	token, err := authMaster.verifyRequest(r)
+	if token == nil || err != nil {
+		slog.Debug("Account deletion request without valid token", "Error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	}
+	userID := token.Subject()
+
+	var deleteAccountRequest httpRequestDeleteAccount
+	err = json.NewDecoder(r.Body).Decode(&deleteAccountRequest)
+	if err != nil {
+		slog.Error("Found error parsing request during account deletion", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not parse form!"))
+		return
+	}
+
+	if deleteAccountRequest.Password == "" {
+		slog.Info("Request did not include 'password' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'password' field!"))
+		return
+	}
+	if len(deleteAccountRequest.Password) > passwordMaxLen {
+		slog.Info("Password is too long!", "PasswordLength", len(deleteAccountRequest.Password))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(fmt.Sprintf("Password must be less than %v characters long!", passwordMaxLen)))
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	clientIP := authMaster.clientIP(r)
+	username, ok := authMaster.throttlePasswordCheck(w, databaseQueryContext, userID, clientIP)
+	if !ok {
+		return
+	}
+
+	err = authMaster.databaseConnection.DeleteUserWithPassword(databaseQueryContext, userID, deleteAccountRequest.Password)
+	if databaseQueryContext.Err() == context.DeadlineExceeded {
+		slog.Info("Database query duration exceeded!", "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Database Error!"))
+		return
+	}
+	switch err {
+	case nil:
+	case database.ErrIncorrectPassword:
+		slog.Info("Account deletion with incorrect password", "UserID", userID)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Password is incorrect."))
+		return
+	case database.ErrOnFetchUserDoesNotExist:
+		slog.Error("UserID does not exist in database", "UserID", userID)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	default:
+		slog.Error("Found error during account deletion!", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during account deletion, please try again."))
+		return
+	}
+
+	authMaster.forgetLoginAttempt(context.Background(), username, clientIP)
+
+	// The account is already deleted, so failing to revoke the access token is logged rather than reported
+	err = authMaster.revokeAccessToken(databaseQueryContext, token)
+	if err != nil {
+		slog.Error("Error during revocation of token", "Error", err, "UserID", userID)
+	}
+	if authMaster.cookieConfig != nil {
+		authMaster.clearTokenCookies(w)
+	}
+
+	slog.Info("Account deleted", "UserID", userID)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Account deleted."))
+}
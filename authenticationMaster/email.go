@@ -0,0 +1,253 @@
+package authenticationmaster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+	"github.com/hmcalister/AuthSSO/mailer"
+)
+
+const (
+	// Email verification tokens expire after this time.
+	emailVerificationTokenExpirationDuration time.Duration = 24 * time.Hour
+
+	// The page users verify their email address on, which is linked to from the verification email.
+	emailVerificationPagePath = "/verify-email.html"
+
+	// Maximum time sending a message should take before failing.
+	maximumMailerSendDuration = 30 * time.Second
+)
+
+type httpRequestSetEmail struct {
+	CurrentPassword string `json:"current_password"`
+	Email           string `json:"email"`
+}
+
+type httpRequestVerifyEmail struct {
+	Token string `json:"token"`
+}
+
+// Set the email address of the user of the access token in the request header (or cookie), and send a verification link to it.
+// The current password must be given again, as password resets are sent to the verified address,
+// so a stolen access token alone cannot be used to take over the account.
+//
+// The address is unverified until the link is followed. Setting the address the user already has sends another verification link,
+// unless that address is already verified. If a verified address is replaced, that address is told of the change.
+// The address may be given by other users, as only verified addresses are unique (see VerifyEmail), so the response
+// does not reveal whether the address is registered.
+func (authMaster *AuthenticationMaster) SetEmail(w http.ResponseWriter, r *http.Request) {
+	if authMaster.mailer == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Email verification is not enabled."))
+		return
+	}
+
+	token, err := authMaster.verifyRequest(r)
+	if token == nil || err != nil {
+		slog.Debug("Set email request without valid token", "Error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	}
+	userID := token.Subject()
+
+	var setEmailRequest httpRequestSetEmail
+	err = json.NewDecoder(r.Body).Decode(&setEmailRequest)
+	if err != nil {
+		slog.Error("Found error parsing request during set email", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not parse form!"))
+		return
+	}
+
+	if setEmailRequest.CurrentPassword == "" {
+		slog.Info("Request did not include 'current_password' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'current_password' field!"))
+		return
+	}
+	if setEmailRequest.Email == "" {
+		slog.Info("Request did not include 'email' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'email' field!"))
+		return
+	}
+	if len(setEmailRequest.CurrentPassword) > passwordMaxLen {
+		slog.Info("Password is too long!", "PasswordLength", len(setEmailRequest.CurrentPassword))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(fmt.Sprintf("Password must be less than %v characters long!", passwordMaxLen)))
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	clientIP := authMaster.clientIP(r)
+	username, ok := authMaster.throttlePasswordCheck(w, databaseQueryContext, userID, clientIP)
+	if !ok {
+		return
+	}
+
+	previousEmail, previousEmailVerified, err := authMaster.databaseConnection.SetUserEmail(databaseQueryContext, userID, setEmailRequest.CurrentPassword, setEmailRequest.Email)
+	if databaseQueryContext.Err() == context.DeadlineExceeded {
+		slog.Info("Database query duration exceeded!", "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Database Error!"))
+		return
+	}
+	switch err {
+	case nil:
+	case database.ErrIncorrectPassword:
+		slog.Info("Set email with incorrect current password", "UserID", userID)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Current password is incorrect."))
+		return
+	case database.ErrInvalidEmail:
+		slog.Info("Invalid email", "UserID", userID)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Email must be a valid email address!"))
+		return
+	case database.ErrOnFetchUserDoesNotExist:
+		slog.Error("UserID does not exist in database", "UserID", userID)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	default:
+		slog.Error("Found error during set email!", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred while setting email, please try again."))
+		return
+	}
+
+	authMaster.forgetLoginAttempt(context.Background(), username, clientIP)
+
+	email, emailVerified, err := authMaster.databaseConnection.GetUserEmail(databaseQueryContext, userID)
+	if err == nil && emailVerified {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Email already verified."))
+		return
+	}
+	if err == nil && previousEmailVerified && previousEmail != email {
+		authMaster.sendEmailChangedNotification(userID, previousEmail, email)
+	}
+	if err == nil {
+		err = authMaster.sendEmailVerification(databaseQueryContext, userID)
+	}
+	if err != nil {
+		slog.Error("Error during sending of email verification", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred while sending email verification, please try again."))
+		return
+	}
+
+	slog.Info("Email set", "UserID", userID)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Email set! Please follow the link sent to your email to verify it."))
+}
+
+// Verify an email address using an email verification token from the link sent by SetEmail or Register.
+func (authMaster *AuthenticationMaster) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var verifyEmailRequest httpRequestVerifyEmail
+	err := json.NewDecoder(r.Body).Decode(&verifyEmailRequest)
+	if err != nil {
+		slog.Error("Found error parsing request during email verification", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not parse form!"))
+		return
+	}
+
+	if verifyEmailRequest.Token == "" {
+		slog.Info("Request did not include 'token' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'token' field!"))
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	userID, err := authMaster.databaseConnection.VerifyEmail(databaseQueryContext, verifyEmailRequest.Token)
+	switch err {
+	case nil:
+	case database.ErrEmailVerificationInvalid, database.ErrEmailVerificationExpired:
+		slog.Info("Invalid email verification token presented", "Error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Email verification link is invalid or expired."))
+		return
+	case database.ErrOnCreateEmailExists:
+		slog.Info("Email already verified by another user")
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Email already in use by another account!"))
+		return
+	default:
+		slog.Error("Found error during email verification!", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during email verification, please try again."))
+		return
+	}
+
+	slog.Info("Email verified", "UserID", userID)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Email verified!"))
+}
+
+// Send a verification link to the (unverified) email address of a user.
+func (authMaster *AuthenticationMaster) sendEmailVerification(ctx context.Context, userID string) error {
+	email, _, err := authMaster.databaseConnection.GetUserEmail(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	verificationToken, err := authMaster.databaseConnection.CreateEmailVerificationToken(ctx, userID, email, time.Now().Add(emailVerificationTokenExpirationDuration))
+	if err != nil {
+		return err
+	}
+
+	instructions := fmt.Sprintf("enter this verification token on the %v page:\n\n%v", emailVerificationPagePath, verificationToken)
+	if authMaster.issuerIsURL() {
+		verificationURL := authMaster.issuerURLWithPath(emailVerificationPagePath) + "?" + url.Values{"token": {verificationToken}}.Encode()
+		instructions = fmt.Sprintf("follow this link:\n\n%v", verificationURL)
+	}
+
+	authMaster.sendMessage(userID, mailer.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("To verify this email address for your account, %v\n\n"+
+			"This expires in %v hours. If you did not use this email address for an account, you can ignore this message.",
+			instructions, emailVerificationTokenExpirationDuration.Hours()),
+	})
+	return nil
+}
+
+// Tell the previous (verified) email address of a user that the address of their account was changed,
+// so the owner of the account learns of a change they did not make.
+func (authMaster *AuthenticationMaster) sendEmailChangedNotification(userID string, previousEmail string, email string) {
+	authMaster.sendMessage(userID, mailer.Message{
+		To:      previousEmail,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf("The email address of your account was changed from this address to %v.\n\n"+
+			"If you did not make this change, someone else may have access to your account.", email),
+	})
+}
+
+// Send a message to a user in the background, so the response does not wait on (or reveal anything about) the mail server.
+// Failures are logged, as there is no longer a response to report them in.
+func (authMaster *AuthenticationMaster) sendMessage(userID string, message mailer.Message) {
+	go func() {
+		mailerContext, mailerContextCancel := context.WithTimeout(context.Background(), maximumMailerSendDuration)
+		defer mailerContextCancel()
+
+		err := authMaster.mailer.Send(mailerContext, message)
+		if err != nil {
+			slog.Error("Error during sending of message", "Error", err, "UserID", userID, "Subject", message.Subject)
+			return
+		}
+		slog.Info("Message sent", "UserID", userID, "Subject", message.Subject)
+	}()
+}
@@ -0,0 +1,138 @@
+package authenticationmaster
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// The query parameter of the login page holding the URL to return to after logging in.
+	returnURLParameter = "return_to"
+
+	// The path that validates a return URL and sends the user there, used by the login page after logging in.
+	forwardAuthReturnPath = "/api/forward-auth/return"
+)
+
+// Authenticate a request on behalf of a reverse proxy (nginx auth_request, Traefik ForwardAuth, and similar),
+// so the proxy can put a whole site behind the login of this server.
+//
+// The proxy passes along the headers of the original request, so the access token is read from the
+// Authorization header or, for browsers, the access token cookie (see CookieConfig).
+// On success the response is 200 with the user in the X-Auth-User and X-Auth-User-Id headers, for the proxy to pass on.
+//
+// On failure the response is 401. If the `redirect` query parameter is given (for proxies that pass the response
+// to the browser, such as Traefik), the response is instead a redirect to the login page, which returns the user to the
+// original URL (from the X-Forwarded-Proto, X-Forwarded-Host, and X-Forwarded-Uri headers) after logging in.
+func (authMaster *AuthenticationMaster) ForwardAuth(w http.ResponseWriter, r *http.Request) {
+	token, err := authMaster.verifyRequest(r)
+	if token == nil || err != nil {
+		slog.Debug("Forward auth request without valid token", "Error", err)
+		authMaster.forwardAuthUnauthorized(w, r)
+		return
+	}
+
+	userID := token.Subject()
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	username, err := authMaster.databaseConnection.GetUsernameByUserID(databaseQueryContext, userID)
+	if err != nil {
+		slog.Error("UserID does not exist in database", "Error", err)
+		authMaster.forwardAuthUnauthorized(w, r)
+		return
+	}
+
+	w.Header().Set("X-Auth-User", username)
+	w.Header().Set("X-Auth-User-Id", userID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// Respond to an unauthenticated forward auth request, with 401 or (if requested) a redirect to the login page.
+func (authMaster *AuthenticationMaster) forwardAuthUnauthorized(w http.ResponseWriter, r *http.Request) {
+	if !r.URL.Query().Has("redirect") {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// The redirect is followed by the browser on the original host, so must name this server absolutely
+	if !authMaster.issuerIsURL() {
+		slog.Error("Forward auth redirect requires the issuer to be the URL of this server", "Issuer", authMaster.issuer)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	scheme := r.Header.Get("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "https"
+	}
+	returnURL, err := url.Parse(scheme + "://" + r.Header.Get("X-Forwarded-Host") + r.Header.Get("X-Forwarded-Uri"))
+	if err != nil || !authMaster.allowedReturnURL(returnURL) {
+		slog.Info("Forward auth request with invalid return URL", "Host", r.Header.Get("X-Forwarded-Host"))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	loginURL := authMaster.issuerURLWithPath(loginPagePath) + "?" + url.Values{
+		returnURLParameter: {returnURL.String()},
+	}.Encode()
+	http.Redirect(w, r, loginURL, http.StatusFound)
+}
+
+// Send a user who has just logged in back to the URL given by the return_to parameter.
+//
+// The login page cannot tell which URLs are safe to return to, so it sends the user here,
+// where the URL is checked against allowedReturnURL rather than allowing an open redirect.
+func (authMaster *AuthenticationMaster) ForwardAuthReturn(w http.ResponseWriter, r *http.Request) {
+	returnURL, err := url.Parse(r.URL.Query().Get(returnURLParameter))
+	if err != nil || !authMaster.allowedReturnURL(returnURL) {
+		slog.Info("Forward auth return to invalid URL", "ReturnURL", r.URL.Query().Get(returnURLParameter))
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid return URL."))
+		return
+	}
+
+	http.Redirect(w, r, returnURL.String(), http.StatusFound)
+}
+
+// Check a URL is safe to send a user to after logging in.
+//
+// Allowed URLs are http(s) URLs on the host of the issuer or, in cookie session mode, within the cookie domain.
+// These are the only hosts the token cookies are sent to, so the only hosts forward auth can protect.
+func (authMaster *AuthenticationMaster) allowedReturnURL(returnURL *url.URL) bool {
+	if (returnURL.Scheme != "http" && returnURL.Scheme != "https") || returnURL.User != nil {
+		return false
+	}
+
+	host := returnURL.Hostname()
+	if host == "" {
+		return false
+	}
+
+	issuerURL, err := url.Parse(authMaster.issuer)
+	if err == nil && issuerURL.Hostname() != "" && strings.EqualFold(host, issuerURL.Hostname()) {
+		return true
+	}
+
+	if authMaster.cookieConfig != nil && authMaster.cookieConfig.Domain != "" {
+		cookieDomain := strings.ToLower(strings.TrimPrefix(authMaster.cookieConfig.Domain, "."))
+		host = strings.ToLower(host)
+		return host == cookieDomain || strings.HasSuffix(host, "."+cookieDomain)
+	}
+
+	return false
+}
+
+// Check the issuer is the http(s) URL of this server, so links to this server can be built from it.
+func (authMaster *AuthenticationMaster) issuerIsURL() bool {
+	issuerURL, err := url.Parse(authMaster.issuer)
+	return err == nil && (issuerURL.Scheme == "http" || issuerURL.Scheme == "https") && issuerURL.Host != ""
+}
+
+// Get the absolute URL of a path on this server, assuming issuerIsURL.
+func (authMaster *AuthenticationMaster) issuerURLWithPath(path string) string {
+	return strings.TrimSuffix(authMaster.issuer, "/") + path
+}
@@ -0,0 +1,40 @@
+package authenticationmaster
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestAllowedReturnURL(t *testing.T) {
+	authMaster := &AuthenticationMaster{
+		issuer:       "https://sso.example.com",
+		cookieConfig: &CookieConfig{Domain: "apps.example.com"},
+	}
+
+	allowed := []string{
+		"https://sso.example.com/authenticated.html",
+		"https://apps.example.com/",
+		"http://wiki.apps.example.com:8080/page?a=b",
+	}
+	for _, returnURL := range allowed {
+		parsedReturnURL, _ := url.Parse(returnURL)
+		if !authMaster.allowedReturnURL(parsedReturnURL) {
+			t.Errorf("Return URL %v rejected", returnURL)
+		}
+	}
+
+	rejected := []string{
+		"https://evil.example/",
+		"https://evilapps.example.com/",
+		"https://apps.example.com.evil/",
+		"javascript://apps.example.com/%0Aalert(1)",
+		"https://user@apps.example.com/",
+		"/relative",
+	}
+	for _, returnURL := range rejected {
+		parsedReturnURL, _ := url.Parse(returnURL)
+		if authMaster.allowedReturnURL(parsedReturnURL) {
+			t.Errorf("Return URL %v accepted", returnURL)
+		}
+	}
+}
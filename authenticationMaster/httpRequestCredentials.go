@@ -3,4 +3,7 @@ package authenticationmaster
 type httpRequestCredentials struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+
+	// Optional, and only read on registration.
+	Email string `json:"email"`
 }
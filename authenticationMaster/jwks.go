@@ -0,0 +1,26 @@
+package authenticationmaster
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Publish the public keys used to sign tokens as a JSON Web Key Set (RFC 7517).
+//
+// Relying services can use these keys to verify tokens offline, rather than calling AuthenticateRequest.
+// Only asymmetric keys are published, so a keyring of only secret keys gives an empty set.
+func (authMaster *AuthenticationMaster) JWKS(w http.ResponseWriter, r *http.Request) {
+	publicKeySet, err := authMaster.keyring.publicKeySet()
+	if err != nil {
+		slog.Error("Error during creation of JWKS", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Relying services may cache the keys for a short time, a new key should be added to
+	// the keyring (but not made active) at least this long before it is used to sign tokens.
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(publicKeySet)
+}
@@ -1,31 +1,107 @@
 package authenticationmaster
 
 import (
+	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/hmcalister/AuthSSO/database"
 )
 
 var (
-	issuerString       string            = "hmcalisterAuthSSO"
-	tokenSigningMethod jwt.SigningMethod = jwt.SigningMethodHS256
+	errNoIDTokenKey error = errors.New("keyring has no asymmetric key to sign ID tokens")
 )
 
-// Given a UserID, generate a new token with that userID as the subject
-func (authMaster *AuthenticationMaster) generateJWT(userID string) (string, error) {
+const (
+	// The issuer used when none is configured.
+	// OpenID Connect clients require the issuer to be the https URL of this server, so this should be overridden when using OpenID Connect.
+	DefaultIssuer string = "hmcalisterAuthSSO"
+
+	// The `typ` header of access tokens (RFC 9068), which distinguishes them from ID tokens signed by the same keys.
+	accessTokenType string = "at+jwt"
+
+	// The `typ` header of ID tokens.
+	idTokenType string = "JWT"
+)
+
+// The claims of an access token. Access tokens issued to an OpenID Connect client also name
+// the client and the scope granted to it (RFC 9068).
+type accessTokenClaims struct {
+	jwt.RegisteredClaims
+	userClaims
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+}
+
+// The claims of an OpenID Connect ID token.
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	userClaims
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// Given a grant, generate a new token with the userID of the grant as the subject, expiring after lifetime.
+// Each token has a unique ID (the `jti` claim) so that it can be revoked before it expires.
+// If audience is not empty, the token is only for that audience (the `aud` claim).
+// The token also carries the given claims describing the user.
+// The token is signed by the active key of the keyring, and records the ID of that key in the `kid` header.
+func (authMaster *AuthenticationMaster) generateJWT(grant database.Grant, claims userClaims, audience string, lifetime time.Duration) (string, error) {
+	currentTime := time.Now()
+	expirationTime := currentTime.Add(lifetime)
+
+	var audienceClaim jwt.ClaimStrings
+	if audience != "" {
+		audienceClaim = jwt.ClaimStrings{audience}
+	}
+
+	return authMaster.signToken(authMaster.keyring.activeKey(), accessTokenType, accessTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    authMaster.issuer,
+			IssuedAt:  jwt.NewNumericDate(currentTime),
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			Subject:   grant.UserID,
+			Audience:  audienceClaim,
+			ID:        uuid.New().String(),
+		},
+		userClaims: claims,
+		ClientID:   grant.ClientID,
+		Scope:      grant.Scope,
+	})
+}
+
+// Given a UserID, generate a new OpenID Connect ID token for the client, with that userID as the subject.
+// The nonce from the authorization request is echoed back so the client can detect replayed ID tokens.
+// The token also carries the given claims describing the user.
+// The token is signed by the ID token key of the keyring (see idTokenKey), so fails if the keyring has none.
+func (authMaster *AuthenticationMaster) generateIDToken(userID string, clientID string, nonce string, claims userClaims) (string, error) {
+	signingKey := authMaster.keyring.idTokenKey()
+	if signingKey == nil {
+		return "", errNoIDTokenKey
+	}
+
 	currentTime := time.Now()
 	expirationTime := currentTime.Add(tokenExpirationDuration)
 
-	token := jwt.NewWithClaims(
-		jwt.SigningMethodHS256,
-		jwt.RegisteredClaims{
-			Issuer:    issuerString,
+	return authMaster.signToken(signingKey, idTokenType, idTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    authMaster.issuer,
 			IssuedAt:  jwt.NewNumericDate(currentTime),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			Subject:   userID,
+			Audience:  jwt.ClaimStrings{clientID},
 		},
-	)
-	signedString, err := token.SignedString(authMaster.tokenSecretKey)
+		userClaims: claims,
+		Nonce:      nonce,
+	})
+}
+
+// Sign a token with the given claims and `typ` header using the given key of the keyring.
+func (authMaster *AuthenticationMaster) signToken(signingKey *signingKey, tokenType string, claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(signingKey.signingMethod, claims)
+	token.Header["typ"] = tokenType
+	token.Header["kid"] = signingKey.keyID
+	signedString, err := token.SignedString(signingKey.signKey)
 	if err != nil {
 		return "", err
 	}
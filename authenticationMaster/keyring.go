@@ -0,0 +1,337 @@
+package authenticationmaster
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	golangjwt "github.com/golang-jwt/jwt/v5"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+const (
+	// The key ID given to the key when a keyring is created from a single secret key.
+	// A keyring file can keep accepting tokens signed by that key by listing it with this key ID.
+	defaultKeyID = "default"
+)
+
+// The signing methods a key in the keyring may use, by the name of the algorithm in the `alg` header.
+//
+// HS256 keys are shared secrets, so tokens signed by them can only be verified by this server.
+// All other algorithms use asymmetric keys loaded from PEM files, and publish their public keys as a JWKS.
+var supportedSigningMethods = map[string]golangjwt.SigningMethod{
+	golangjwt.SigningMethodHS256.Alg(): golangjwt.SigningMethodHS256,
+	golangjwt.SigningMethodRS256.Alg(): golangjwt.SigningMethodRS256,
+	golangjwt.SigningMethodES256.Alg(): golangjwt.SigningMethodES256,
+	golangjwt.SigningMethodEdDSA.Alg(): golangjwt.SigningMethodEdDSA,
+}
+
+// A single key in the keyring, identified by the `kid` header of the tokens it signs.
+type signingKey struct {
+	keyID         string
+	signingMethod golangjwt.SigningMethod
+
+	// The key used to sign tokens, either a secret []byte or a private key.
+	signKey interface{}
+
+	// The key used to verify tokens, either the same secret []byte or the public key.
+	verifyKey interface{}
+
+	// The time after which tokens signed by this key are no longer accepted. The zero time means the key never retires.
+	retiresAt time.Time
+
+	// Verifier for tokens signed by this key.
+	tokenAuth *jwtauth.JWTAuth
+}
+
+// A set of keys used to sign and verify tokens.
+//
+// Exactly one key is active and used to sign new tokens. All other keys are kept only to verify tokens
+// signed before the active key was changed, until they retire. This allows the signing key to be rotated
+// without logging out every user at once. The one exception is ID tokens, see idTokenKey.
+type Keyring struct {
+	activeKeyID string
+	keys        map[string]*signingKey
+
+	// The key IDs in the order they were added (listed in the keyring file), newest first.
+	keyIDs []string
+}
+
+// The format of a keyring file on disk.
+//
+// Algorithm defaults to HS256 if not given. HS256 keys are read from a SecretKeyFile,
+// while all other algorithms are read from a PEM encoded PrivateKeyFile.
+// Paths to key files are relative to the directory holding the keyring file.
+type keyringFile struct {
+	ActiveKeyID string `json:"activeKeyID"`
+	Keys        []struct {
+		KeyID          string    `json:"keyID"`
+		Algorithm      string    `json:"algorithm"`
+		SecretKeyFile  string    `json:"secretKeyFile"`
+		PrivateKeyFile string    `json:"privateKeyFile"`
+		RetiresAt      time.Time `json:"retiresAt"`
+	} `json:"keys"`
+}
+
+// Create a keyring holding a single secret key, which is used to sign and verify all tokens.
+func NewKeyringFromSecret(secretKey []byte) *Keyring {
+	keyring := &Keyring{
+		activeKeyID: defaultKeyID,
+		keys:        make(map[string]*signingKey),
+	}
+	keyring.addKey(defaultKeyID, golangjwt.SigningMethodHS256, secretKey, secretKey, time.Time{})
+
+	return keyring
+}
+
+// Load a keyring from a JSON file on disk, with keys listed newest first, for example:
+//
+//	{
+//	    "activeKeyID": "2024-11",
+//	    "keys": [
+//	        {"keyID": "2024-11", "algorithm": "ES256", "privateKeyFile": "2024-11.pem"},
+//	        {"keyID": "2024-05", "secretKeyFile": "2024-05.secret", "retiresAt": "2024-12-01T00:00:00Z"}
+//	    ]
+//	}
+//
+// Fails (and returns a non-nil error) if:
+// - The keyring file or any key file cannot be read
+// - Any key ID is empty or duplicated
+// - Any algorithm is not supported, or does not match the type of its key
+// - The active key is not in the keyring, or is retired
+func LoadKeyring(keyringFilePath string) (*Keyring, error) {
+	keyringFileContents, err := os.ReadFile(keyringFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsedKeyringFile keyringFile
+	err = json.Unmarshal(keyringFileContents, &parsedKeyringFile)
+	if err != nil {
+		return nil, err
+	}
+
+	keyring := &Keyring{
+		activeKeyID: parsedKeyringFile.ActiveKeyID,
+		keys:        make(map[string]*signingKey),
+	}
+	keyringDirectory := filepath.Dir(keyringFilePath)
+	for _, key := range parsedKeyringFile.Keys {
+		if key.KeyID == "" {
+			return nil, errors.New("keyring contains a key with no keyID")
+		}
+		if _, exists := keyring.keys[key.KeyID]; exists {
+			return nil, fmt.Errorf("keyring contains duplicate keyID %q", key.KeyID)
+		}
+
+		if key.Algorithm == "" {
+			key.Algorithm = golangjwt.SigningMethodHS256.Alg()
+		}
+		signingMethod, supported := supportedSigningMethods[key.Algorithm]
+		if !supported {
+			return nil, fmt.Errorf("keyID %q has unsupported algorithm %q", key.KeyID, key.Algorithm)
+		}
+
+		keyFilePath := key.PrivateKeyFile
+		if signingMethod == golangjwt.SigningMethodHS256 {
+			keyFilePath = key.SecretKeyFile
+		}
+		if !filepath.IsAbs(keyFilePath) {
+			keyFilePath = filepath.Join(keyringDirectory, keyFilePath)
+		}
+		keyFileContents, err := os.ReadFile(keyFilePath)
+		if err != nil {
+			return nil, err
+		}
+
+		signKey, verifyKey, err := parseKeyFile(signingMethod, keyFileContents)
+		if err != nil {
+			return nil, fmt.Errorf("keyID %q could not be parsed: %w", key.KeyID, err)
+		}
+
+		keyring.addKey(key.KeyID, signingMethod, signKey, verifyKey, key.RetiresAt)
+	}
+
+	activeKey, exists := keyring.keys[keyring.activeKeyID]
+	if !exists {
+		return nil, fmt.Errorf("active keyID %q is not in keyring", keyring.activeKeyID)
+	}
+	if activeKey.isRetired(time.Now()) {
+		return nil, fmt.Errorf("active keyID %q is retired", keyring.activeKeyID)
+	}
+
+	return keyring, nil
+}
+
+// Parse the contents of a key file into the keys used to sign and verify tokens.
+//
+// For HS256 the file contents are the secret key itself. For all other algorithms the file
+// holds a PEM encoded private key, and the public key is derived from it.
+func parseKeyFile(signingMethod golangjwt.SigningMethod, keyFileContents []byte) (interface{}, interface{}, error) {
+	switch signingMethod {
+	case golangjwt.SigningMethodHS256:
+		return keyFileContents, keyFileContents, nil
+	case golangjwt.SigningMethodRS256:
+		privateKey, err := golangjwt.ParseRSAPrivateKeyFromPEM(keyFileContents)
+		if err != nil {
+			return nil, nil, err
+		}
+		return privateKey, &privateKey.PublicKey, nil
+	case golangjwt.SigningMethodES256:
+		privateKey, err := golangjwt.ParseECPrivateKeyFromPEM(keyFileContents)
+		if err != nil {
+			return nil, nil, err
+		}
+		if privateKey.Curve != elliptic.P256() {
+			return nil, nil, errors.New("ES256 requires a P-256 key")
+		}
+		return privateKey, &privateKey.PublicKey, nil
+	case golangjwt.SigningMethodEdDSA:
+		privateKey, err := golangjwt.ParseEdPrivateKeyFromPEM(keyFileContents)
+		if err != nil {
+			return nil, nil, err
+		}
+		return privateKey, privateKey.(crypto.Signer).Public(), nil
+	}
+
+	return nil, nil, fmt.Errorf("unsupported algorithm %q", signingMethod.Alg())
+}
+
+func (keyring *Keyring) addKey(keyID string, signingMethod golangjwt.SigningMethod, signKey interface{}, verifyKey interface{}, retiresAt time.Time) {
+	// Tokens must be signed using the key, be signed with the correct signing method.
+	// Tokens must also have a subject claim (the subject is the UserID).
+	// Tokens must have an ID claim, so they can be checked against the revoked tokens.
+	// The issuer is checked by the AuthenticationMaster, as it is not known by the keyring.
+	tokenAuth := jwtauth.New(signingMethod.Alg(),
+		signKey,
+		verifyKey,
+		jwt.WithRequiredClaim(jwt.SubjectKey),
+		jwt.WithRequiredClaim(jwt.JwtIDKey),
+	)
+
+	keyring.keyIDs = append(keyring.keyIDs, keyID)
+	keyring.keys[keyID] = &signingKey{
+		keyID:         keyID,
+		signingMethod: signingMethod,
+		signKey:       signKey,
+		verifyKey:     verifyKey,
+		retiresAt:     retiresAt,
+		tokenAuth:     tokenAuth,
+	}
+}
+
+// Whether the key is asymmetric, so the verification key may be published.
+func (key *signingKey) isAsymmetric() bool {
+	return key.signingMethod != golangjwt.SigningMethodHS256
+}
+
+func (key *signingKey) isRetired(currentTime time.Time) bool {
+	return !key.retiresAt.IsZero() && currentTime.After(key.retiresAt)
+}
+
+// Get the key used to sign new tokens.
+func (keyring *Keyring) activeKey() *signingKey {
+	return keyring.keys[keyring.activeKeyID]
+}
+
+// Get the key used to sign ID tokens, or nil if the keyring has no asymmetric key that has not retired.
+//
+// ID tokens are verified by clients, so must not be signed by a secret (HS256) key. This is the active key if it is asymmetric,
+// otherwise the newest asymmetric key, so a keyring may keep signing access tokens with a secret key.
+func (keyring *Keyring) idTokenKey() *signingKey {
+	if keyring.activeKey().isAsymmetric() {
+		return keyring.activeKey()
+	}
+
+	currentTime := time.Now()
+	for _, keyID := range keyring.keyIDs {
+		key := keyring.keys[keyID]
+		if key.isAsymmetric() && !key.isRetired(currentTime) {
+			return key
+		}
+	}
+	return nil
+}
+
+// Verify an access token string, selecting the verification key by the `kid` header of the token.
+//
+// Tokens with no `kid` header, an unknown `kid`, or a `kid` of a retired key are unauthorized.
+// Tokens that are not access tokens (by the `typ` header), such as ID tokens, are also unauthorized.
+// Otherwise the errors returned are those of jwtauth.VerifyToken.
+func (keyring *Keyring) verifyToken(tokenString string) (jwt.Token, error) {
+	message, err := jws.ParseString(tokenString)
+	if err != nil {
+		return nil, jwtauth.ErrUnauthorized
+	}
+
+	signatures := message.Signatures()
+	if len(signatures) != 1 {
+		return nil, jwtauth.ErrUnauthorized
+	}
+
+	if signatures[0].ProtectedHeaders().Type() != accessTokenType {
+		return nil, jwtauth.ErrUnauthorized
+	}
+
+	key, exists := keyring.keys[signatures[0].ProtectedHeaders().KeyID()]
+	if !exists || key.isRetired(time.Now()) {
+		return nil, jwtauth.ErrUnauthorized
+	}
+
+	return jwtauth.VerifyToken(key.tokenAuth, tokenString)
+}
+
+// Get the public keys of every asymmetric key in the keyring that has not retired, as a JSON Web Key Set.
+//
+// Secret (HS256) keys are never included, as publishing them would allow anyone to sign tokens.
+func (keyring *Keyring) publicKeySet() (jwk.Set, error) {
+	keyIDs := make([]string, 0, len(keyring.keys))
+	for keyID := range keyring.keys {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+
+	currentTime := time.Now()
+	publicKeySet := jwk.NewSet()
+	for _, keyID := range keyIDs {
+		key := keyring.keys[keyID]
+		if !key.isAsymmetric() || key.isRetired(currentTime) {
+			continue
+		}
+
+		publicKey, err := jwk.FromRaw(key.verifyKey)
+		if err != nil {
+			return nil, err
+		}
+		publicKey.Set(jwk.KeyIDKey, key.keyID)
+		publicKey.Set(jwk.AlgorithmKey, jwa.SignatureAlgorithm(key.signingMethod.Alg()))
+		publicKey.Set(jwk.KeyUsageKey, jwk.ForSignature)
+
+		err = publicKeySet.AddKey(publicKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return publicKeySet, nil
+}
+
+// Get the algorithms used to sign ID tokens, that is the algorithm of idTokenKey.
+// Returns nil if there is no key to sign ID tokens.
+func (keyring *Keyring) idTokenSigningAlgorithms() []string {
+	idTokenKey := keyring.idTokenKey()
+	if idTokenKey == nil {
+		return nil
+	}
+	return []string{idTokenKey.signingMethod.Alg()}
+}
@@ -0,0 +1,206 @@
+package authenticationmaster
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+func writeTestKeyring(t *testing.T, keyringContents string, secretKeys map[string]string) string {
+	keyringDirectory := t.TempDir()
+	for fileName, secretKey := range secretKeys {
+		err := os.WriteFile(filepath.Join(keyringDirectory, fileName), []byte(secretKey), 0600)
+		if err != nil {
+			t.Fatalf("Error writing secret key file: %v", err)
+		}
+	}
+
+	keyringFilePath := filepath.Join(keyringDirectory, "keyring.json")
+	err := os.WriteFile(keyringFilePath, []byte(keyringContents), 0600)
+	if err != nil {
+		t.Fatalf("Error writing keyring file: %v", err)
+	}
+	return keyringFilePath
+}
+
+func TestKeyringRotation(t *testing.T) {
+	secretKeys := map[string]string{"old.secret": "oldSecretKey", "new.secret": "newSecretKey"}
+
+	oldKeyringPath := writeTestKeyring(t, `{"activeKeyID": "old", "keys": [{"keyID": "old", "secretKeyFile": "old.secret"}]}`, secretKeys)
+	oldKeyring, err := LoadKeyring(oldKeyringPath)
+	if err != nil {
+		t.Fatalf("Error loading keyring: %v", err)
+	}
+	oldToken, err := (&AuthenticationMaster{keyring: oldKeyring}).generateJWT(database.Grant{UserID: "userID"}, userClaims{}, "", tokenExpirationDuration)
+	if err != nil {
+		t.Fatalf("Error generating token: %v", err)
+	}
+
+	rotatedKeyringPath := writeTestKeyring(t, `{"activeKeyID": "new", "keys": [
+		{"keyID": "new", "secretKeyFile": "new.secret"},
+		{"keyID": "old", "secretKeyFile": "old.secret", "retiresAt": "`+time.Now().Add(time.Hour).Format(time.RFC3339)+`"}
+	]}`, secretKeys)
+	rotatedKeyring, err := LoadKeyring(rotatedKeyringPath)
+	if err != nil {
+		t.Fatalf("Error loading rotated keyring: %v", err)
+	}
+	newToken, err := (&AuthenticationMaster{keyring: rotatedKeyring}).generateJWT(database.Grant{UserID: "userID"}, userClaims{}, "", tokenExpirationDuration)
+	if err != nil {
+		t.Fatalf("Error generating token: %v", err)
+	}
+
+	if _, err := rotatedKeyring.verifyToken(oldToken); err != nil {
+		t.Errorf("Token signed by old key rejected before key retired: %v", err)
+	}
+	if _, err := rotatedKeyring.verifyToken(newToken); err != nil {
+		t.Errorf("Token signed by active key rejected: %v", err)
+	}
+	if _, err := oldKeyring.verifyToken(newToken); err == nil {
+		t.Errorf("Token signed by unknown key accepted")
+	}
+
+	rotatedKeyring.keys["old"].retiresAt = time.Now().Add(-time.Minute)
+	if _, err := rotatedKeyring.verifyToken(oldToken); err == nil {
+		t.Errorf("Token signed by retired key accepted")
+	}
+}
+
+func TestKeyringInvalidActiveKey(t *testing.T) {
+	secretKeys := map[string]string{"old.secret": "oldSecretKey"}
+
+	missingKeyringPath := writeTestKeyring(t, `{"activeKeyID": "new", "keys": [{"keyID": "old", "secretKeyFile": "old.secret"}]}`, secretKeys)
+	if _, err := LoadKeyring(missingKeyringPath); err == nil {
+		t.Errorf("Keyring with missing active key loaded without error")
+	}
+
+	retiredKeyringPath := writeTestKeyring(t, `{"activeKeyID": "old", "keys": [{"keyID": "old", "secretKeyFile": "old.secret", "retiresAt": "2000-01-01T00:00:00Z"}]}`, secretKeys)
+	if _, err := LoadKeyring(retiredKeyringPath); err == nil {
+		t.Errorf("Keyring with retired active key loaded without error")
+	}
+}
+
+func encodePrivateKeyPEM(t *testing.T, privateKey interface{}) string {
+	derBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("Error encoding private key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: derBytes}))
+}
+
+func TestAsymmetricKeyringPublishesPublicKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, ed25519Key, _ := ed25519.GenerateKey(rand.Reader)
+	secretKeys := map[string]string{
+		"rsa.pem":     encodePrivateKeyPEM(t, rsaKey),
+		"ecdsa.pem":   encodePrivateKeyPEM(t, ecdsaKey),
+		"ed25519.pem": encodePrivateKeyPEM(t, ed25519Key),
+		"hmac.secret": "secretKey",
+	}
+
+	for _, activeKeyID := range []string{"rsa", "ecdsa", "ed25519"} {
+		keyringPath := writeTestKeyring(t, `{"activeKeyID": "`+activeKeyID+`", "keys": [
+			{"keyID": "rsa", "algorithm": "RS256", "privateKeyFile": "rsa.pem"},
+			{"keyID": "ecdsa", "algorithm": "ES256", "privateKeyFile": "ecdsa.pem"},
+			{"keyID": "ed25519", "algorithm": "EdDSA", "privateKeyFile": "ed25519.pem"},
+			{"keyID": "hmac", "secretKeyFile": "hmac.secret"}
+		]}`, secretKeys)
+		keyring, err := LoadKeyring(keyringPath)
+		if err != nil {
+			t.Fatalf("Error loading keyring: %v", err)
+		}
+
+		token, err := (&AuthenticationMaster{keyring: keyring}).generateJWT(database.Grant{UserID: "userID"}, userClaims{}, "", tokenExpirationDuration)
+		if err != nil {
+			t.Fatalf("Error generating token signed by %v: %v", activeKeyID, err)
+		}
+		if _, err := keyring.verifyToken(token); err != nil {
+			t.Errorf("Token signed by %v rejected: %v", activeKeyID, err)
+		}
+
+		publicKeySet, err := keyring.publicKeySet()
+		if err != nil {
+			t.Fatalf("Error creating public key set: %v", err)
+		}
+		if publicKeySet.Len() != 3 {
+			t.Errorf("Public key set has %v keys, expected 3 (secret key must not be published)", publicKeySet.Len())
+		}
+		if _, err := jwt.ParseString(token, jwt.WithKeySet(publicKeySet)); err != nil {
+			t.Errorf("Token signed by %v could not be verified with public key set: %v", activeKeyID, err)
+		}
+
+		idTokenKey := keyring.idTokenKey()
+		if idTokenKey == nil || idTokenKey.keyID != activeKeyID {
+			t.Errorf("Keyring with active key %v has unexpected ID token key: %v", activeKeyID, idTokenKey)
+		}
+		signingAlgorithms := keyring.idTokenSigningAlgorithms()
+		if !slices.Equal(signingAlgorithms, []string{keyring.activeKey().signingMethod.Alg()}) {
+			t.Errorf("Keyring advertises %v, but signs ID tokens with %v", signingAlgorithms, keyring.activeKey().signingMethod.Alg())
+		}
+	}
+}
+
+func TestIDTokenKey(t *testing.T) {
+	if keyring := NewKeyringFromSecret([]byte("secretKey")); keyring.idTokenKey() != nil || keyring.idTokenSigningAlgorithms() != nil {
+		t.Errorf("Keyring of only a secret key can sign ID tokens")
+	}
+
+	// A secret active key cannot sign ID tokens, so the newest asymmetric key that has not retired is used instead
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, ed25519Key, _ := ed25519.GenerateKey(rand.Reader)
+	secretKeys := map[string]string{
+		"ecdsa.pem":   encodePrivateKeyPEM(t, ecdsaKey),
+		"ed25519.pem": encodePrivateKeyPEM(t, ed25519Key),
+		"hmac.secret": "secretKey",
+	}
+	keyringPath := writeTestKeyring(t, `{"activeKeyID": "hmac", "keys": [
+		{"keyID": "hmac", "secretKeyFile": "hmac.secret"},
+		{"keyID": "ed25519", "algorithm": "EdDSA", "privateKeyFile": "ed25519.pem", "retiresAt": "2000-01-01T00:00:00Z"},
+		{"keyID": "ecdsa", "algorithm": "ES256", "privateKeyFile": "ecdsa.pem"}
+	]}`, secretKeys)
+	keyring, err := LoadKeyring(keyringPath)
+	if err != nil {
+		t.Fatalf("Error loading keyring: %v", err)
+	}
+
+	signingAlgorithms := keyring.idTokenSigningAlgorithms()
+	if !slices.Equal(signingAlgorithms, []string{"ES256"}) {
+		t.Errorf("Keyring advertises unexpected ID token signing algorithms: %v", signingAlgorithms)
+	}
+
+	idToken, err := (&AuthenticationMaster{keyring: keyring}).generateIDToken("userID", "clientID", "", userClaims{})
+	if err != nil {
+		t.Fatalf("Error generating ID token: %v", err)
+	}
+	publicKeySet, err := keyring.publicKeySet()
+	if err != nil {
+		t.Fatalf("Error creating public key set: %v", err)
+	}
+	if _, err := jwt.ParseString(idToken, jwt.WithKeySet(publicKeySet)); err != nil {
+		t.Errorf("ID token could not be verified with public key set: %v", err)
+	}
+}
+
+func TestKeyringMismatchedAlgorithm(t *testing.T) {
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	secretKeys := map[string]string{"ecdsa.pem": encodePrivateKeyPEM(t, ecdsaKey)}
+
+	for _, algorithm := range []string{"RS256", "ES256", "EdDSA", "none"} {
+		keyringPath := writeTestKeyring(t, `{"activeKeyID": "ecdsa", "keys": [{"keyID": "ecdsa", "algorithm": "`+algorithm+`", "privateKeyFile": "ecdsa.pem"}]}`, secretKeys)
+		if _, err := LoadKeyring(keyringPath); err == nil {
+			t.Errorf("Keyring with P-384 key loaded as %v without error", algorithm)
+		}
+	}
+}
@@ -6,10 +6,16 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/hmcalister/AuthSSO/database"
 )
 
+// Log in with a username and password, giving the user their tokens.
+//
+// If the user has a second factor, the response is instead an MFA challenge, which LoginMFA (or FinishWebAuthnLogin) exchanges for the tokens.
+//
+// Failed logins are throttled per username and per client IP (see checkLoginThrottle), with a 429 and Retry-After once throttled.
 func (authMaster *AuthenticationMaster) Login(w http.ResponseWriter, r *http.Request) {
 	var requestCredentials httpRequestCredentials
 	err := json.NewDecoder(r.Body).Decode(&requestCredentials)
@@ -51,6 +57,16 @@ func (authMaster *AuthenticationMaster) Login(w http.ResponseWriter, r *http.Req
 	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
 	defer databaseQueryContextCancel()
 
+	// Password guessing is slowed, then locked out, after too many failures with the username or from the client.
+	// The attempt is counted as a failure before the password is checked, and forgotten once the user has logged in
+	clientIP := authMaster.clientIP(r)
+	if !authMaster.checkLoginThrottle(w, databaseQueryContext, requestCredentials.Username, clientIP) {
+		return
+	}
+	if !authMaster.recordLoginAttempt(w, databaseQueryContext, requestCredentials.Username, clientIP) {
+		return
+	}
+
 	ok, err := authMaster.databaseConnection.ValidateLoginAttempt(databaseQueryContext, requestCredentials.Username, requestCredentials.Password)
 	if databaseQueryContext.Err() == context.DeadlineExceeded {
 		slog.Info("Database query duration exceeded!", "Username", requestCredentials.Username)
@@ -58,9 +74,10 @@ func (authMaster *AuthenticationMaster) Login(w http.ResponseWriter, r *http.Req
 		w.Write([]byte("Database Error!"))
 		return
 	}
+	// An unknown username gets the same response as an incorrect password, so the response does not reveal which usernames exist
 	if err == database.ErrOnFetchUserDoesNotExist {
-		slog.Info("Login Request for Invalid Username", "Username", requestCredentials.Username)
-		w.WriteHeader(http.StatusNotFound)
+		slog.Info("Login Request for Invalid Username", "Username", requestCredentials.Username, "ClientIP", clientIP)
+		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte("Invalid Username or Password."))
 		return
 	}
@@ -73,23 +90,47 @@ func (authMaster *AuthenticationMaster) Login(w http.ResponseWriter, r *http.Req
 
 	// Actually check if the user is who they say they are
 	if !ok {
-		slog.Info("Invalid login attempt!", "Username", requestCredentials.Username)
+		slog.Info("Invalid login attempt!", "Username", requestCredentials.Username, "ClientIP", clientIP)
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte("Invalid Username or Password."))
 		return
 	}
 
-	// Now we can go about giving the JWT to authenticate in the future
-
 	userID, _ := authMaster.databaseConnection.GetUserIDByUsername(context.Background(), requestCredentials.Username)
-	token, err := authMaster.generateJWT(userID)
+
+	// Users with a second factor (TOTP or a passkey) must also give it before they are given any tokens.
+	// Until then the attempt stays counted, so the second factor is throttled along with the password (see recordMFAAttempt)
+	mfaMethods, err := authMaster.mfaMethods(context.Background(), userID)
+	if err != nil {
+		slog.Error("Error during check of MFA methods!", "Error", err, "Username", requestCredentials.Username)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during authentication attempt, please try again"))
+		return
+	}
+	if len(mfaMethods) > 0 {
+		slog.Info("Password accepted, MFA required", "UserID", userID, "MFAMethods", mfaMethods)
+		authMaster.respondWithMFAChallenge(w, userID, mfaMethods)
+		return
+	}
+
+	authMaster.forgetLoginAttempt(context.Background(), requestCredentials.Username, clientIP)
+	authMaster.completeLogin(w, userID)
+}
+
+// Now we can go about giving the JWT (and refresh token) to authenticate in the future.
+// In cookie session mode the tokens are given as cookies, otherwise they are the response body.
+func (authMaster *AuthenticationMaster) completeLogin(w http.ResponseWriter, userID string) {
+	grant, refreshToken, err := authMaster.databaseConnection.CreateRefreshToken(context.Background(), database.Grant{UserID: userID}, time.Now().Add(refreshTokenExpirationDuration))
 	if err != nil {
-		slog.Error("Error during creation of JWT!", "Error", err, "Username", requestCredentials.Username)
+		slog.Error("Error during creation of refresh token!", "Error", err, "UserID", userID)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("An error occurred during authentication attempt, please try again"))
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(token))
+	if authMaster.cookieConfig != nil {
+		authMaster.respondWithTokenCookies(w, grant, refreshToken)
+		return
+	}
+	authMaster.respondWithTokens(w, grant, refreshToken, "")
 }
@@ -0,0 +1,243 @@
+package authenticationmaster
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+var (
+	// Failed logins with one username. Kept strict, as only someone guessing the password should fail this often.
+	usernameThrottlePolicy = database.ThrottlePolicy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 10,
+		LockoutDuration:  15 * time.Minute,
+		FailureWindow:    time.Hour,
+	}
+
+	// Failed logins from one client IP, across all usernames. Kept looser than per username, as many users may share an IP (such as behind NAT).
+	ipThrottlePolicy = database.ThrottlePolicy{
+		FreeAttempts:     20,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 100,
+		LockoutDuration:  15 * time.Minute,
+		FailureWindow:    time.Hour,
+	}
+)
+
+// Parse a space separated list of trusted proxies, each an IP address or CIDR prefix, such as "127.0.0.1 10.0.0.0/8".
+//
+// Fails (and returns a non-nil error) if any proxy is not an IP address or CIDR prefix.
+func ParseTrustedProxies(trustedProxiesList string) ([]netip.Prefix, error) {
+	var trustedProxies []netip.Prefix
+	for _, proxy := range strings.Fields(trustedProxiesList) {
+		if !strings.Contains(proxy, "/") {
+			addr, err := netip.ParseAddr(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q is not an IP address or CIDR prefix", proxy)
+			}
+			trustedProxies = append(trustedProxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not an IP address or CIDR prefix", proxy)
+		}
+		trustedProxies = append(trustedProxies, prefix.Masked())
+	}
+
+	return trustedProxies, nil
+}
+
+// Set the proxies trusted to give the client IP in the X-Forwarded-For header, see ParseTrustedProxies.
+// Without trusted proxies, the client IP is always the address the request came from.
+func (authMaster *AuthenticationMaster) SetTrustedProxies(trustedProxies []netip.Prefix) {
+	authMaster.trustedProxies = trustedProxies
+}
+
+// The IP address of the client making a request, used to throttle failed logins from the same client.
+//
+// If the request came from a trusted proxy, X-Forwarded-For is read from the right (the entry added by the nearest proxy),
+// skipping trusted proxies, and the first untrusted address is the client. Entries left of that could be set by the client, so are ignored.
+func (authMaster *AuthenticationMaster) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	clientAddr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	clientAddr = clientAddr.Unmap()
+
+	forwardedFor := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwardedFor) - 1; i >= 0 && authMaster.isTrustedProxy(clientAddr); i-- {
+		forwardedAddr, err := netip.ParseAddr(strings.TrimSpace(forwardedFor[i]))
+		if err != nil {
+			break
+		}
+		clientAddr = forwardedAddr.Unmap()
+	}
+
+	return clientAddr.String()
+}
+
+func (authMaster *AuthenticationMaster) isTrustedProxy(addr netip.Addr) bool {
+	for _, trustedProxy := range authMaster.trustedProxies {
+		if trustedProxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check that logins with a username, from a client IP, are not delayed or locked out after too many failures.
+// If they are, a 429 with Retry-After is written as the response and false is returned.
+func (authMaster *AuthenticationMaster) checkLoginThrottle(w http.ResponseWriter, ctx context.Context, username string, ip string) bool {
+	var retryAfter time.Duration
+	for _, throttleKey := range []string{database.UsernameThrottleKey(username), database.IPThrottleKey(ip)} {
+		keyRetryAfter, err := authMaster.databaseConnection.CheckLoginThrottle(ctx, throttleKey)
+		if err != nil {
+			slog.Error("Error during check of login throttle!", "Error", err, "ThrottleKey", throttleKey)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("An error occurred during authentication attempt, please try again."))
+			return false
+		}
+		retryAfter = max(retryAfter, keyRetryAfter)
+	}
+	if retryAfter == 0 {
+		return true
+	}
+
+	writeLoginThrottled(w, username, ip, retryAfter)
+	return false
+}
+
+// A throttle on failed logins, and the policy it is counted with.
+type loginThrottle struct {
+	throttleKey string
+	policy      database.ThrottlePolicy
+}
+
+// Record a login attempt with a username, from a client IP, as a failure before the password is checked,
+// so further attempts are delayed and eventually locked out. The attempt is forgotten once the user has logged in, see forgetLoginAttempt.
+//
+// Counting first means concurrent attempts cannot all have their password checked before any are counted.
+// If an attempt is throttled (such as by a concurrent attempt since checkLoginThrottle),
+// a 429 with Retry-After is written as the response and false is returned.
+func (authMaster *AuthenticationMaster) recordLoginAttempt(w http.ResponseWriter, ctx context.Context, username string, ip string) bool {
+	return authMaster.recordLoginFailure(w, ctx, username, ip, []loginThrottle{
+		{database.UsernameThrottleKey(username), usernameThrottlePolicy},
+		{database.IPThrottleKey(ip), ipThrottlePolicy},
+	})
+}
+
+// Record an attempt at the second factor of a user, from a client IP, as a failure before the second factor is checked, as recordLoginAttempt does for passwords.
+// Otherwise the second factor could be guessed without limit, as the password gives a new MFA challenge (and so new attempts) every time.
+//
+// Only the username is counted, as the client IP was already counted for the password, which is not forgotten until the user has logged in.
+func (authMaster *AuthenticationMaster) recordMFAAttempt(w http.ResponseWriter, ctx context.Context, username string, ip string) bool {
+	return authMaster.recordLoginFailure(w, ctx, username, ip, []loginThrottle{
+		{database.UsernameThrottleKey(username), usernameThrottlePolicy},
+	})
+}
+
+// Check and record an attempt at the second factor of a user (see recordMFAAttempt), returning the username of the user
+// so the attempt can be forgotten once the user has logged in.
+// If the attempt is throttled, or the user cannot be found, the response is written and false is returned.
+func (authMaster *AuthenticationMaster) throttleMFAAttempt(w http.ResponseWriter, ctx context.Context, userID string, ip string) (string, bool) {
+	username, err := authMaster.databaseConnection.GetUsernameByUserID(ctx, userID)
+	if err != nil {
+		slog.Error("Error during retrieval of username", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during authentication attempt, please try again."))
+		return "", false
+	}
+
+	if !authMaster.checkLoginThrottle(w, ctx, username, ip) {
+		return "", false
+	}
+	if !authMaster.recordMFAAttempt(w, ctx, username, ip) {
+		return "", false
+	}
+	return username, true
+}
+
+// Check and record an attempt at the password of a user who is already logged in (such as to change their password),
+// returning the username of the user so the attempt can be forgotten if the password is correct.
+// These attempts are throttled with logins, so a stolen access token cannot be used to guess the password without limit.
+// If the attempt is throttled, or the user cannot be found, the response is written and false is returned.
+func (authMaster *AuthenticationMaster) throttlePasswordCheck(w http.ResponseWriter, ctx context.Context, userID string, ip string) (string, bool) {
+	username, err := authMaster.databaseConnection.GetUsernameByUserID(ctx, userID)
+	if err != nil {
+		slog.Error("Error during retrieval of username", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return "", false
+	}
+
+	if !authMaster.checkLoginThrottle(w, ctx, username, ip) {
+		return "", false
+	}
+	if !authMaster.recordLoginAttempt(w, ctx, username, ip) {
+		return "", false
+	}
+	return username, true
+}
+
+func (authMaster *AuthenticationMaster) recordLoginFailure(w http.ResponseWriter, ctx context.Context, username string, ip string, throttles []loginThrottle) bool {
+	for _, throttle := range throttles {
+		delay, err := authMaster.databaseConnection.RecordLoginFailure(ctx, throttle.throttleKey, throttle.policy)
+		switch err {
+		case nil:
+		case database.ErrLoginThrottled:
+			writeLoginThrottled(w, username, ip, delay)
+			return false
+		default:
+			slog.Error("Error during recording of login attempt!", "Error", err, "ThrottleKey", throttle.throttleKey)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("An error occurred during authentication attempt, please try again."))
+			return false
+		}
+		if delay >= throttle.policy.LockoutDuration {
+			slog.Warn("Logins locked out after too many failures", "ThrottleKey", throttle.throttleKey, "LockoutDuration", delay)
+		}
+	}
+	return true
+}
+
+// Forget a login attempt with a username, from a client IP, once the user has logged in (with their second factor, if they have one),
+// or the password of a user who is already logged in was found to be correct (see throttlePasswordCheck).
+// Earlier failures with the username (including of the second factor) are forgotten too. Earlier failures from the client IP are not,
+// so an attacker cannot reset their count by logging in to an account of their own.
+func (authMaster *AuthenticationMaster) forgetLoginAttempt(ctx context.Context, username string, ip string) {
+	_, err := authMaster.databaseConnection.ResetLoginThrottle(ctx, database.UsernameThrottleKey(username))
+	if err != nil {
+		slog.Error("Error during reset of login throttle!", "Error", err, "Username", username)
+	}
+	err = authMaster.databaseConnection.ForgetLoginFailure(ctx, database.IPThrottleKey(ip))
+	if err != nil {
+		slog.Error("Error during reset of login throttle!", "Error", err, "ClientIP", ip)
+	}
+}
+
+// Write a 429 with Retry-After as the response to a throttled login attempt.
+func writeLoginThrottled(w http.ResponseWriter, username string, ip string, retryAfter time.Duration) {
+	slog.Info("Throttled login attempt", "Username", username, "ClientIP", ip, "RetryAfter", retryAfter)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte("Too many failed login attempts, please try again later."))
+}
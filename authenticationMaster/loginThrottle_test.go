@@ -0,0 +1,62 @@
+package authenticationmaster
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies("127.0.0.1 10.1.2.3/8 ::1")
+	if err != nil {
+		t.Fatalf("Error parsing trusted proxies: %v", err)
+	}
+	expectedProxies := []string{"127.0.0.1/32", "10.0.0.0/8", "::1/128"}
+	if len(trustedProxies) != len(expectedProxies) {
+		t.Fatalf("Parsed %v trusted proxies, expected %v", len(trustedProxies), len(expectedProxies))
+	}
+	for i, trustedProxy := range trustedProxies {
+		if trustedProxy.String() != expectedProxies[i] {
+			t.Errorf("Trusted proxy %v parsed as %v, expected %v", i, trustedProxy, expectedProxies[i])
+		}
+	}
+
+	_, err = ParseTrustedProxies("127.0.0.1 proxy.example.com")
+	if err == nil {
+		t.Errorf("Invalid trusted proxy parsed without error")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trustedProxies, _ := ParseTrustedProxies("127.0.0.1 10.0.0.0/8")
+	authMaster := &AuthenticationMaster{trustedProxies: trustedProxies}
+
+	testCases := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		expectedIP   string
+	}{
+		{"Direct client", "203.0.113.5:1234", nil, "203.0.113.5"},
+		{"Untrusted proxy", "203.0.113.5:1234", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"Trusted proxy", "127.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"Trusted proxy without header", "127.0.0.1:1234", nil, "127.0.0.1"},
+		{"Chain of trusted proxies", "127.0.0.1:1234", []string{"198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"Spoofed entry left of client", "127.0.0.1:1234", []string{"192.0.2.9, 198.51.100.1"}, "198.51.100.1"},
+		{"Multiple headers", "127.0.0.1:1234", []string{"192.0.2.9", "198.51.100.1"}, "198.51.100.1"},
+		{"Invalid entry", "127.0.0.1:1234", []string{"not-an-ip"}, "127.0.0.1"},
+		{"IPv4 mapped address", "[::ffff:203.0.113.5]:1234", nil, "203.0.113.5"},
+	}
+
+	for _, testCase := range testCases {
+		request := httptest.NewRequest("POST", "/api/login", nil)
+		request.RemoteAddr = testCase.remoteAddr
+		for _, forwardedFor := range testCase.forwardedFor {
+			request.Header.Add("X-Forwarded-For", forwardedFor)
+		}
+
+		clientIP := authMaster.clientIP(request)
+		if clientIP != testCase.expectedIP {
+			t.Errorf("%v: client IP is %v, expected %v", testCase.name, clientIP, testCase.expectedIP)
+		}
+	}
+}
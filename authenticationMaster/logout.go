@@ -0,0 +1,72 @@
+package authenticationmaster
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+// Log out by revoking the access token in the request header (or cookie), so it is rejected for the rest of its lifetime.
+//
+// If the request body includes a refresh token, that refresh token (and every other refresh token from the same login)
+// is also revoked, so it cannot be used to get a new JWT.
+//
+// In cookie session mode the refresh token is instead read from the refresh token cookie, and both token cookies are cleared.
+func (authMaster *AuthenticationMaster) Logout(w http.ResponseWriter, r *http.Request) {
+	// Cookies are cleared whatever the outcome, so a browser is never left holding a token it believes is logged out
+	var refreshTokenCookie string
+	if authMaster.cookieConfig != nil {
+		refreshTokenCookie = refreshTokenFromCookie(r)
+		authMaster.clearTokenCookies(w)
+	}
+
+	token, err := authMaster.verifyRequest(r)
+	if token == nil || err != nil {
+		slog.Debug("Logout request without valid token", "Error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	}
+
+	// The body is optional, so an empty body is not an error
+	var logoutRequest httpRequestRefresh
+	err = json.NewDecoder(r.Body).Decode(&logoutRequest)
+	if err != nil && err != io.EOF {
+		slog.Error("Found error parsing request during logout", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not parse form!"))
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	err = authMaster.revokeAccessToken(databaseQueryContext, token)
+	if err != nil {
+		slog.Error("Error during revocation of token", "Error", err, "UserID", token.Subject())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during logout, please try again."))
+		return
+	}
+
+	if logoutRequest.RefreshToken == "" {
+		logoutRequest.RefreshToken = refreshTokenCookie
+	}
+	if logoutRequest.RefreshToken != "" {
+		err = authMaster.databaseConnection.RevokeRefreshToken(databaseQueryContext, logoutRequest.RefreshToken)
+		if err != nil && err != database.ErrRefreshTokenInvalid {
+			slog.Error("Error during revocation of refresh token", "Error", err, "UserID", token.Subject())
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("An error occurred during logout, please try again."))
+			return
+		}
+	}
+
+	slog.Info("User logged out", "UserID", token.Subject())
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Logout successful!"))
+}
@@ -0,0 +1,98 @@
+package authenticationmaster
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// The response of the token introspection endpoint (RFC 7662 Section 2.2).
+// Inactive tokens are described only by Active, so nothing is revealed about why a token is inactive.
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	Subject   string `json:"sub,omitempty"`
+	Username  string `json:"username,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+}
+
+// The OAuth 2.0 token introspection endpoint (RFC 7662), for API gateways and other resource servers.
+//
+// The caller must authenticate as a confidential client, as for the Token endpoint. The access token in the
+// `token` parameter is checked exactly as verifyClientRequest would (expiry, issuer, and revocation), and described if active.
+// Refresh tokens are never active, as only access tokens are accepted by resource servers.
+func (authMaster *AuthenticationMaster) Introspect(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	err := r.ParseForm()
+	if err != nil {
+		slog.Error("Found error parsing request during introspection", "Error", err)
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", "Could not parse form!")
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	clientID, ok := authMaster.authenticateClient(databaseQueryContext, w, r)
+	if !ok {
+		return
+	}
+
+	// Public clients have no secret, so anyone could introspect tokens as a public client
+	client, err := authMaster.databaseConnection.GetClient(databaseQueryContext, clientID)
+	if err != nil {
+		slog.Error("Found error during retrieval of client", "Error", err, "ClientID", clientID)
+		writeTokenError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	if client.Public {
+		slog.Info("Introspection request from public client", "ClientID", clientID)
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client", "Public clients may not introspect tokens.")
+		return
+	}
+
+	tokenString := r.PostForm.Get("token")
+	if tokenString == "" {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", "Request must include 'token' parameter.")
+		return
+	}
+
+	response := introspectionResponse{Active: false}
+	token, err := authMaster.verifyAccessToken(tokenString)
+	if token != nil && err == nil {
+		username, err := authMaster.databaseConnection.GetUsernameByUserID(databaseQueryContext, token.Subject())
+		if err == nil {
+			response = introspectionResponse{
+				Active:    true,
+				Subject:   token.Subject(),
+				Username:  username,
+				ExpiresAt: token.Expiration().Unix(),
+				IssuedAt:  token.IssuedAt().Unix(),
+				Issuer:    token.Issuer(),
+				Scope:     privateClaimString(token, "scope"),
+				ClientID:  privateClaimString(token, "client_id"),
+			}
+		}
+	}
+
+	slog.Debug("Token introspected", "ClientID", clientID, "Active", response.Active)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Get a string claim that is not one of the registered claims, or an empty string if the token does not have that claim.
+func privateClaimString(token jwt.Token, claimName string) string {
+	claim, ok := token.Get(claimName)
+	if !ok {
+		return ""
+	}
+	claimString, _ := claim.(string)
+	return claimString
+}
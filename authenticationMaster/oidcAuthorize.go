@@ -0,0 +1,175 @@
+package authenticationmaster
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+const (
+	// Authorization codes must be exchanged for tokens within this time.
+	authorizationCodeExpirationDuration time.Duration = 1 * time.Minute
+
+	// The page users are sent to when they must log in before authorizing a client.
+	// The original authorization request parameters are passed along in the query string.
+	loginPagePath = "/login.html"
+)
+
+// The response to an authorization request made with POST, where the caller must perform the redirect itself.
+type authorizeResponse struct {
+	RedirectURI string `json:"redirect_uri"`
+}
+
+// The OpenID Connect authorization endpoint, implementing the authorization code flow with PKCE.
+//
+// The request must include client_id, redirect_uri (registered for the client), response_type=code,
+// and a code_challenge with code_challenge_method=S256. The state and nonce parameters are passed back to the client.
+//
+// If the request carries a valid token (in the header or cookie) an authorization code is issued immediately.
+// Otherwise, a GET request (a browser navigation) is redirected to the login page, which repeats the request
+// as a POST once the user has logged in. A POST request is answered with JSON holding the redirect URI,
+// rather than a redirect, so the login page can navigate to it.
+func (authMaster *AuthenticationMaster) Authorize(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		slog.Error("Found error parsing request during authorize", "Error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Could not parse form!"))
+		return
+	}
+
+	clientID := r.Form.Get("client_id")
+	redirectURI := r.Form.Get("redirect_uri")
+	state := r.Form.Get("state")
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	// Until the client and redirect URI are validated, errors must not redirect, or this would be an open redirect.
+	client, err := authMaster.databaseConnection.GetClient(databaseQueryContext, clientID)
+	if err == database.ErrOnFetchClientDoesNotExist {
+		slog.Info("Authorize request for unknown client", "ClientID", clientID)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Unknown client_id."))
+		return
+	}
+	if err != nil {
+		slog.Error("Found error during retrieval of client", "Error", err, "ClientID", clientID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Database Error!"))
+		return
+	}
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		slog.Info("Authorize request with unregistered redirect URI", "ClientID", clientID, "RedirectURI", redirectURI)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("redirect_uri is not registered for this client."))
+		return
+	}
+
+	if r.Form.Get("response_type") != "code" {
+		authMaster.authorizeRedirect(w, r, redirectURI, url.Values{
+			"error":             {"unsupported_response_type"},
+			"error_description": {"Only the 'code' response_type is supported."},
+			"state":             {state},
+		})
+		return
+	}
+	codeChallenge := r.Form.Get("code_challenge")
+	if r.Form.Get("code_challenge_method") != pkceMethodS256 || !pkceCodeChallengePattern.MatchString(codeChallenge) {
+		authMaster.authorizeRedirect(w, r, redirectURI, url.Values{
+			"error":             {"invalid_request"},
+			"error_description": {"PKCE is required, with code_challenge_method 'S256'."},
+			"state":             {state},
+		})
+		return
+	}
+	// ID tokens could not be verified by the client without an asymmetric key to sign them
+	if scopeContains(r.Form.Get("scope"), "openid") && authMaster.keyring.idTokenKey() == nil {
+		authMaster.authorizeRedirect(w, r, redirectURI, url.Values{
+			"error":             {"invalid_scope"},
+			"error_description": {"The 'openid' scope requires an asymmetric signing key."},
+			"state":             {state},
+		})
+		return
+	}
+
+	token, err := authMaster.verifyRequest(r)
+	// Otherwise a client could use the tokens it was issued to obtain a code (and so tokens) for another client
+	if err == errTokenIssuedToClient {
+		slog.Info("Authorize request with token issued to a client", "ClientID", clientID)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	}
+	if token == nil || err != nil {
+		if r.Method == http.MethodGet {
+			slog.Debug("Authorize request without valid token, redirecting to login", "ClientID", clientID)
+			http.Redirect(w, r, loginPagePath+"?"+r.Form.Encode(), http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	}
+
+	code, err := authMaster.databaseConnection.CreateAuthorizationCode(databaseQueryContext, database.AuthorizationCode{
+		ClientID:      clientID,
+		UserID:        token.Subject(),
+		RedirectURI:   redirectURI,
+		Scope:         r.Form.Get("scope"),
+		Nonce:         r.Form.Get("nonce"),
+		CodeChallenge: codeChallenge,
+	}, time.Now().Add(authorizationCodeExpirationDuration))
+	if err != nil {
+		slog.Error("Error during creation of authorization code", "Error", err, "ClientID", clientID)
+		authMaster.authorizeRedirect(w, r, redirectURI, url.Values{
+			"error": {"server_error"},
+			"state": {state},
+		})
+		return
+	}
+
+	slog.Info("Authorization code issued", "ClientID", clientID, "UserID", token.Subject())
+	authMaster.authorizeRedirect(w, r, redirectURI, url.Values{
+		"code":  {code},
+		"state": {state},
+	})
+}
+
+// Send the user back to the (already validated) redirect URI of the client with the given parameters added to the query.
+// Empty parameters (such as an absent state) are omitted.
+//
+// GET requests are redirected, POST requests are answered with JSON holding the redirect URI.
+func (authMaster *AuthenticationMaster) authorizeRedirect(w http.ResponseWriter, r *http.Request, redirectURI string, parameters url.Values) {
+	// The redirect URI was registered by an administrator, so is trusted to parse
+	parsedRedirectURI, _ := url.Parse(redirectURI)
+	query := parsedRedirectURI.Query()
+	for key, values := range parameters {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	parsedRedirectURI.RawQuery = query.Encode()
+
+	if r.Method == http.MethodGet {
+		http.Redirect(w, r, parsedRedirectURI.String(), http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(authorizeResponse{
+		RedirectURI: parsedRedirectURI.String(),
+	})
+}
+
+// Check if a space separated list of scopes contains the given scope.
+func scopeContains(scopes string, scope string) bool {
+	return slices.Contains(strings.Fields(scopes), scope)
+}
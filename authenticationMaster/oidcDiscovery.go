@@ -0,0 +1,61 @@
+package authenticationmaster
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// The OpenID Provider Metadata (OpenID Connect Discovery 1.0 Section 3), allowing clients to configure themselves.
+type openIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// Publish the OpenID Connect discovery document.
+//
+// All endpoints are given relative to the issuer, so the issuer must be the URL this server is reached at.
+// Without an asymmetric key to sign ID tokens (see idTokenKey) OpenID Connect is not supported, so there is no document.
+func (authMaster *AuthenticationMaster) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	idTokenSigningAlgorithms := authMaster.keyring.idTokenSigningAlgorithms()
+	if len(idTokenSigningAlgorithms) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("OpenID Connect requires an asymmetric signing key."))
+		return
+	}
+
+	baseURL := strings.TrimSuffix(authMaster.issuer, "/")
+
+	configuration := openIDConfiguration{
+		Issuer:                            authMaster.issuer,
+		AuthorizationEndpoint:             baseURL + "/authorize",
+		TokenEndpoint:                     baseURL + "/token",
+		UserInfoEndpoint:                  baseURL + "/userinfo",
+		JWKSURI:                           baseURL + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             baseURL + "/oauth/introspect",
+		ScopesSupported:                   []string{"openid", "profile", "email"},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  idTokenSigningAlgorithms,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{pkceMethodS256},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "nonce", "preferred_username", "email", "email_verified"},
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(configuration)
+}
@@ -0,0 +1,164 @@
+package authenticationmaster
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+// An error response from the token endpoint (RFC 6749 Section 5.2).
+type tokenErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// The OpenID Connect token endpoint.
+//
+// Supports the authorization_code grant (exchanging a code from Authorize, along with the PKCE code verifier)
+// and the refresh_token grant (exchanging a refresh token, as in Refresh).
+// Confidential clients must authenticate with their client secret, by HTTP Basic authentication or the client_secret parameter.
+func (authMaster *AuthenticationMaster) Token(w http.ResponseWriter, r *http.Request) {
+	// Responses hold tokens, so must never be cached (RFC 6749 Section 5.1)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	err := r.ParseForm()
+	if err != nil {
+		slog.Error("Found error parsing request during token", "Error", err)
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", "Could not parse form!")
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	clientID, ok := authMaster.authenticateClient(databaseQueryContext, w, r)
+	if !ok {
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		authMaster.tokenFromAuthorizationCode(databaseQueryContext, w, r, clientID)
+	case "refresh_token":
+		authMaster.tokenFromRefreshToken(databaseQueryContext, w, r, clientID)
+	default:
+		writeTokenError(w, http.StatusBadRequest, "unsupported_grant_type", "Only the 'authorization_code' and 'refresh_token' grant types are supported.")
+	}
+}
+
+// Exchange an authorization code for an access token, refresh token, and (if the openid scope was requested) ID token.
+func (authMaster *AuthenticationMaster) tokenFromAuthorizationCode(ctx context.Context, w http.ResponseWriter, r *http.Request, clientID string) {
+	authorizationCode, err := authMaster.databaseConnection.ConsumeAuthorizationCode(ctx, r.PostForm.Get("code"))
+	if err == database.ErrAuthorizationCodeInvalid || err == database.ErrAuthorizationCodeExpired {
+		slog.Info("Invalid authorization code presented", "Error", err, "ClientID", clientID)
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant", "Authorization code is invalid or expired.")
+		return
+	}
+	if err != nil {
+		slog.Error("Found error during retrieval of authorization code", "Error", err, "ClientID", clientID)
+		writeTokenError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	// The code is already consumed, so any mismatch means the code cannot be retried
+	if authorizationCode.ClientID != clientID || authorizationCode.RedirectURI != r.PostForm.Get("redirect_uri") {
+		slog.Info("Authorization code presented by wrong client or with wrong redirect URI", "ClientID", clientID)
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant", "Authorization code was not issued to this client and redirect_uri.")
+		return
+	}
+	if !verifyPKCE(authorizationCode.CodeChallenge, r.PostForm.Get("code_verifier")) {
+		slog.Info("Authorization code presented with invalid code verifier", "ClientID", clientID)
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match code_challenge.")
+		return
+	}
+
+	grant, refreshToken, err := authMaster.databaseConnection.CreateRefreshToken(ctx, database.Grant{
+		UserID:   authorizationCode.UserID,
+		ClientID: clientID,
+		Scope:    authorizationCode.Scope,
+	}, time.Now().Add(refreshTokenExpirationDuration))
+	if err != nil {
+		slog.Error("Error during creation of refresh token!", "Error", err, "UserID", authorizationCode.UserID)
+		writeTokenError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	var idToken string
+	if scopeContains(authorizationCode.Scope, "openid") {
+		var claims userClaims
+		if scopeContains(authorizationCode.Scope, "email") {
+			err = authMaster.setEmailClaims(ctx, authorizationCode.UserID, &claims)
+		}
+		if err == nil {
+			idToken, err = authMaster.generateIDToken(authorizationCode.UserID, clientID, authorizationCode.Nonce, claims)
+		}
+		if err != nil {
+			slog.Error("Error during creation of ID token!", "Error", err, "UserID", authorizationCode.UserID)
+			writeTokenError(w, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+	}
+
+	slog.Info("Authorization code exchanged", "ClientID", clientID, "UserID", authorizationCode.UserID)
+	authMaster.respondWithTokens(w, grant, refreshToken, idToken)
+}
+
+// Exchange a refresh token for a new access token and refresh token.
+// The refresh token must have been issued to the client presenting it.
+func (authMaster *AuthenticationMaster) tokenFromRefreshToken(ctx context.Context, w http.ResponseWriter, r *http.Request, clientID string) {
+	grant, refreshToken, err := authMaster.databaseConnection.RotateRefreshToken(ctx, r.PostForm.Get("refresh_token"), clientID, time.Now().Add(refreshTokenExpirationDuration))
+	switch err {
+	case nil:
+	case database.ErrRefreshTokenReused, database.ErrRefreshTokenExpired, database.ErrRefreshTokenInvalid:
+		slog.Info("Invalid refresh token presented", "Error", err, "ClientID", clientID)
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant", "Refresh token is invalid or expired.")
+		return
+	default:
+		slog.Error("Found error during refresh!", "Error", err, "ClientID", clientID)
+		writeTokenError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	authMaster.respondWithTokens(w, grant, refreshToken, "")
+}
+
+// Authenticate the client making a request, by HTTP Basic authentication or the client_id and client_secret parameters.
+// The request form must already be parsed.
+//
+// Returns the client ID and true if the client is authenticated. Otherwise, an error response has been written.
+func (authMaster *AuthenticationMaster) authenticateClient(ctx context.Context, w http.ResponseWriter, r *http.Request) (string, bool) {
+	clientID, clientSecret, usedBasicAuth := r.BasicAuth()
+	if !usedBasicAuth {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	ok, err := authMaster.databaseConnection.ValidateClientSecret(ctx, clientID, clientSecret)
+	if err != nil && err != database.ErrOnFetchClientDoesNotExist {
+		slog.Error("Found error during client authentication", "Error", err, "ClientID", clientID)
+		writeTokenError(w, http.StatusInternalServerError, "server_error", "")
+		return "", false
+	}
+	if !ok {
+		slog.Info("Invalid client authentication", "ClientID", clientID)
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed.")
+		return "", false
+	}
+
+	return clientID, true
+}
+
+func writeTokenError(w http.ResponseWriter, statusCode int, errorCode string, errorDescription string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(tokenErrorResponse{
+		Error:            errorCode,
+		ErrorDescription: errorDescription,
+	})
+}
@@ -0,0 +1,62 @@
+package authenticationmaster
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// The standard claims returned by the UserInfo endpoint (OpenID Connect Core 1.0 Section 5.1).
+type userInfoResponse struct {
+	Subject           string `json:"sub"`
+	PreferredUsername string `json:"preferred_username"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+}
+
+// The OpenID Connect UserInfo endpoint, returning the claims of the user the access token was issued to.
+//
+// This is the same information as AuthenticateRequest, but in the standard shape expected by OpenID Connect clients.
+// The email address of the user is only included if the token was issued with the email scope.
+func (authMaster *AuthenticationMaster) UserInfo(w http.ResponseWriter, r *http.Request) {
+	token, err := authMaster.verifyClientRequest(r)
+	if token == nil || err != nil {
+		slog.Debug("UserInfo request without valid token", "Error", err)
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	userID := token.Subject()
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	username, err := authMaster.databaseConnection.GetUsernameByUserID(databaseQueryContext, userID)
+	if err != nil {
+		slog.Error("UserID does not exist in database", "Error", err)
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var claims userClaims
+	if scopeContains(privateClaimString(token, "scope"), "email") {
+		err = authMaster.setEmailClaims(databaseQueryContext, userID, &claims)
+		if err != nil {
+			slog.Error("Error during retrieval of email", "Error", err, "UserID", userID)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(userInfoResponse{
+		Subject:           userID,
+		PreferredUsername: username,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+	})
+}
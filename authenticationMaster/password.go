@@ -0,0 +1,147 @@
+package authenticationmaster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+type httpRequestChangePassword struct {
+	CurrentPassword     string `json:"current_password"`
+	NewPassword         string `json:"new_password"`
+	RevokeOtherSessions bool   `json:"revoke_other_sessions"`
+}
+
+// Change the password of the user of the access token in the request header (or cookie).
+// The current password must be given again, so a stolen access token alone cannot be used to take over the account.
+// The new password must meet the password policy, see SetPasswordPolicy.
+//
+// If revoke_other_sessions is set, every refresh token and session of the user is revoked, logging the user out everywhere.
+// The user is then given new tokens (as for Login), so only the session that changed the password stays logged in.
+// Other access tokens issued as JWTs are accepted until they expire.
+func (authMaster *AuthenticationMaster) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	token, err := authMaster.verifyRequest(r)
+	if token == nil || err != nil {
+		slog.Debug("Password change request without valid token", "Error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	}
+	userID := token.Subject()
+
+	var changePasswordRequest httpRequestChangePassword
+	err = json.NewDecoder(r.Body).Decode(&changePasswordRequest)
+	if err != nil {
+		slog.Error("Found error parsing request during password change", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not parse form!"))
+		return
+	}
+
+	if changePasswordRequest.CurrentPassword == "" {
+		slog.Info("Request did not include 'current_password' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'current_password' field!"))
+		return
+	}
+	if changePasswordRequest.NewPassword == "" {
+		slog.Info("Request did not include 'new_password' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'new_password' field!"))
+		return
+	}
+	if len(changePasswordRequest.CurrentPassword) > passwordMaxLen || len(changePasswordRequest.NewPassword) > passwordMaxLen {
+		slog.Info("Password is too long!", "PasswordLength", len(changePasswordRequest.NewPassword))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(fmt.Sprintf("Password must be less than %v characters long!", passwordMaxLen)))
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	username, err := authMaster.databaseConnection.GetUsernameByUserID(databaseQueryContext, userID)
+	if err != nil {
+		slog.Error("Error during retrieval of username", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	}
+	if !authMaster.checkPasswordPolicy(w, changePasswordRequest.NewPassword, username) {
+		return
+	}
+
+	// The current password is throttled as at login (see throttlePasswordCheck), once the new password is known to be acceptable
+	clientIP := authMaster.clientIP(r)
+	if !authMaster.checkLoginThrottle(w, databaseQueryContext, username, clientIP) {
+		return
+	}
+	if !authMaster.recordLoginAttempt(w, databaseQueryContext, username, clientIP) {
+		return
+	}
+
+	err = authMaster.databaseConnection.ChangePassword(databaseQueryContext, userID, changePasswordRequest.CurrentPassword, changePasswordRequest.NewPassword)
+	if databaseQueryContext.Err() == context.DeadlineExceeded {
+		slog.Info("Database query duration exceeded!", "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Database Error!"))
+		return
+	}
+	switch err {
+	case nil:
+	case database.ErrIncorrectPassword:
+		slog.Info("Password change with incorrect current password", "UserID", userID)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Current password is incorrect."))
+		return
+	case database.ErrOnFetchUserDoesNotExist:
+		slog.Error("UserID does not exist in database", "UserID", userID)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	default:
+		slog.Error("Found error during password change!", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during password change, please try again."))
+		return
+	}
+
+	authMaster.forgetLoginAttempt(context.Background(), username, clientIP)
+
+	slog.Info("Password changed", "UserID", userID, "RevokeOtherSessions", changePasswordRequest.RevokeOtherSessions)
+	if !changePasswordRequest.RevokeOtherSessions {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Password changed!"))
+		return
+	}
+
+	err = authMaster.databaseConnection.RevokeUserSessions(databaseQueryContext, userID)
+	if err == nil {
+		err = authMaster.revokeAccessToken(databaseQueryContext, token)
+	}
+	if err != nil {
+		slog.Error("Error during revocation of sessions", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Password changed, but an error occurred while logging out other sessions, please try again."))
+		return
+	}
+
+	grant, refreshToken, err := authMaster.databaseConnection.CreateRefreshToken(context.Background(), database.Grant{UserID: userID}, time.Now().Add(refreshTokenExpirationDuration))
+	if err != nil {
+		slog.Error("Error during creation of refresh token!", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during authentication attempt, please try again"))
+		return
+	}
+
+	if authMaster.cookieConfig != nil {
+		authMaster.respondWithTokenCookies(w, grant, refreshToken)
+		return
+	}
+	authMaster.respondWithTokens(w, grant, refreshToken, "")
+}
@@ -0,0 +1,45 @@
+package authenticationmaster
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	passwordpolicy "github.com/hmcalister/AuthSSO/passwordPolicy"
+)
+
+// The response to a new password that does not meet the password policy, listing every rule it fails.
+type passwordPolicyResponse struct {
+	Message     string                   `json:"message"`
+	FailedRules []passwordpolicy.Failure `json:"failed_rules"`
+}
+
+// Set the policy new passwords must meet, on registration, password change, and password reset.
+// Without a policy, any password that is not empty is accepted.
+func (authMaster *AuthenticationMaster) SetPasswordPolicy(policy passwordpolicy.Policy) {
+	authMaster.passwordPolicy = policy
+}
+
+// Check a new password of a user against the password policy.
+// If it fails any rules, a 400 listing them is written as the response and false is returned.
+func (authMaster *AuthenticationMaster) checkPasswordPolicy(w http.ResponseWriter, password string, username string) bool {
+	failures := authMaster.passwordPolicy.Check(password, username)
+	if failures == nil {
+		return true
+	}
+
+	failedRules := make([]passwordpolicy.Rule, 0, len(failures))
+	for _, failure := range failures {
+		failedRules = append(failedRules, failure.Rule)
+	}
+	slog.Info("Password does not meet the password policy", "Username", username, "FailedRules", failedRules)
+
+	response := passwordPolicyResponse{
+		Message:     "Password does not meet the password policy.",
+		FailedRules: failures,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(response)
+	return false
+}
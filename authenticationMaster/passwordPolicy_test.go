@@ -0,0 +1,39 @@
+package authenticationmaster
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	passwordpolicy "github.com/hmcalister/AuthSSO/passwordPolicy"
+)
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	authMaster := &AuthenticationMaster{}
+	authMaster.SetPasswordPolicy(passwordpolicy.Policy{MinLength: 8, BlockUsername: true})
+
+	recorder := httptest.NewRecorder()
+	if !authMaster.checkPasswordPolicy(recorder, "a long password", "John Smith") {
+		t.Fatalf("Password meeting the policy was refused: %v", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	if authMaster.checkPasswordPolicy(recorder, "john", "john") {
+		t.Fatal("Password failing the policy was accepted")
+	}
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Password failing the policy was refused with status %v, expected %v", recorder.Code, http.StatusBadRequest)
+	}
+
+	var response passwordPolicyResponse
+	err := json.NewDecoder(recorder.Body).Decode(&response)
+	if err != nil {
+		t.Fatalf("Error decoding password policy response: %v", err)
+	}
+	if len(response.FailedRules) != 2 ||
+		response.FailedRules[0].Rule != passwordpolicy.MinLengthRule ||
+		response.FailedRules[1].Rule != passwordpolicy.ContainsUsernameRule {
+		t.Errorf("Password policy response does not list the failed rules: %v", response.FailedRules)
+	}
+}
@@ -0,0 +1,202 @@
+package authenticationmaster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+	"github.com/hmcalister/AuthSSO/mailer"
+)
+
+const (
+	// Password reset tokens expire after this time. Kept short, as the token is sent by email and gives full control of the account.
+	passwordResetTokenExpirationDuration time.Duration = 30 * time.Minute
+
+	// The page users reset their password on, which is linked to from the password reset email.
+	passwordResetPagePath = "/reset-password.html"
+
+	// The response to every password reset request, so the response does not reveal which usernames exist.
+	passwordResetRequestedMessage = "If the account exists and has a verified email address, a password reset link has been sent."
+)
+
+type httpRequestPasswordReset struct {
+	Username string `json:"username"`
+}
+
+type httpRequestResetPassword struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// Request a password reset for the user with the given username, sending the user a single use password reset token.
+//
+// The token is sent through the mailer to the verified email address of the user. Users without a verified email address
+// cannot reset their password, so no token is created for them.
+// The request is answered before the user is looked up (see sendPasswordReset), so the response is the same,
+// and takes the same time, whether or not the user exists and has a verified email address. It cannot be used to find usernames.
+func (authMaster *AuthenticationMaster) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	if authMaster.mailer == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Password reset is not enabled."))
+		return
+	}
+
+	var passwordResetRequest httpRequestPasswordReset
+	err := json.NewDecoder(r.Body).Decode(&passwordResetRequest)
+	if err != nil {
+		slog.Error("Found error parsing request during password reset request", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not parse form!"))
+		return
+	}
+
+	if passwordResetRequest.Username == "" {
+		slog.Info("Request did not include 'username' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'username' field!"))
+		return
+	}
+
+	go authMaster.sendPasswordReset(passwordResetRequest.Username)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(passwordResetRequestedMessage))
+}
+
+// Create a password reset token for the user with the given username, and send it to the verified email address of the user.
+// Run in the background by RequestPasswordReset, so failures are logged, as there is no longer a response to report them in.
+func (authMaster *AuthenticationMaster) sendPasswordReset(username string) {
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	userID, err := authMaster.databaseConnection.GetUserIDByUsername(databaseQueryContext, username)
+	if err == database.ErrOnFetchUserDoesNotExist {
+		slog.Info("Password reset request for invalid username", "Username", username)
+		return
+	}
+	if err != nil {
+		slog.Error("Found error during retrieval of user", "Error", err, "Username", username)
+		return
+	}
+
+	// The username is not known to be an email address, let alone one the user receives mail at
+	email, emailVerified, err := authMaster.databaseConnection.GetUserEmail(databaseQueryContext, userID)
+	if err != nil {
+		slog.Error("Error during retrieval of email", "Error", err, "UserID", userID)
+		return
+	}
+	if !emailVerified {
+		slog.Info("Password reset request for user without verified email", "UserID", userID)
+		return
+	}
+
+	resetToken, err := authMaster.databaseConnection.CreatePasswordResetToken(databaseQueryContext, userID, time.Now().Add(passwordResetTokenExpirationDuration))
+	if err != nil {
+		slog.Error("Error during creation of password reset token!", "Error", err, "UserID", userID)
+		return
+	}
+
+	slog.Info("Password reset requested", "UserID", userID)
+	authMaster.sendMessage(userID, authMaster.passwordResetMessage(email, resetToken))
+}
+
+// Create the message sending a password reset token to a user.
+// If the issuer is the URL of this server, the message links to the password reset page, otherwise the token is given alone.
+func (authMaster *AuthenticationMaster) passwordResetMessage(recipient string, resetToken string) mailer.Message {
+	instructions := fmt.Sprintf("enter this password reset token on the %v page:\n\n%v", passwordResetPagePath, resetToken)
+	if authMaster.issuerIsURL() {
+		resetURL := authMaster.issuerURLWithPath(passwordResetPagePath) + "?" + url.Values{"token": {resetToken}}.Encode()
+		instructions = fmt.Sprintf("follow this link:\n\n%v", resetURL)
+	}
+
+	return mailer.Message{
+		To:      recipient,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("A password reset was requested for your account. To choose a new password, %v\n\n"+
+			"This expires in %v minutes. If you did not request a password reset, you can ignore this message.",
+			instructions, passwordResetTokenExpirationDuration.Minutes()),
+	}
+}
+
+// Set a new password using a password reset token from RequestPasswordReset.
+// Every session of the user is logged out, so the user must log in again with the new password.
+// The new password must meet the password policy, see SetPasswordPolicy.
+func (authMaster *AuthenticationMaster) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if authMaster.mailer == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Password reset is not enabled."))
+		return
+	}
+
+	var resetPasswordRequest httpRequestResetPassword
+	err := json.NewDecoder(r.Body).Decode(&resetPasswordRequest)
+	if err != nil {
+		slog.Error("Found error parsing request during password reset", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not parse form!"))
+		return
+	}
+
+	if resetPasswordRequest.Token == "" {
+		slog.Info("Request did not include 'token' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'token' field!"))
+		return
+	}
+	if resetPasswordRequest.NewPassword == "" {
+		slog.Info("Request did not include 'new_password' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'new_password' field!"))
+		return
+	}
+	if len(resetPasswordRequest.NewPassword) > passwordMaxLen {
+		slog.Info("Password is too long!", "PasswordLength", len(resetPasswordRequest.NewPassword))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(fmt.Sprintf("Password must be less than %v characters long!", passwordMaxLen)))
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	// The token is only consumed once the new password meets the policy, so the user can try another password with the same token
+	userID, err := authMaster.databaseConnection.GetPasswordResetTokenUser(databaseQueryContext, resetPasswordRequest.Token)
+	if err == nil {
+		var username string
+		username, err = authMaster.databaseConnection.GetUsernameByUserID(databaseQueryContext, userID)
+		if err == nil && !authMaster.checkPasswordPolicy(w, resetPasswordRequest.NewPassword, username) {
+			return
+		}
+	}
+	if err == nil {
+		userID, err = authMaster.databaseConnection.ResetPassword(databaseQueryContext, resetPasswordRequest.Token, resetPasswordRequest.NewPassword)
+	}
+	if databaseQueryContext.Err() == context.DeadlineExceeded {
+		slog.Info("Database query duration exceeded!")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Database Error!"))
+		return
+	}
+	switch err {
+	case nil:
+	case database.ErrPasswordResetTokenInvalid, database.ErrPasswordResetTokenExpired:
+		slog.Info("Invalid password reset token presented", "Error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Password reset token is invalid or expired."))
+		return
+	default:
+		slog.Error("Found error during password reset!", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during password reset, please try again."))
+		return
+	}
+
+	slog.Info("Password reset", "UserID", userID)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Password reset successful!"))
+}
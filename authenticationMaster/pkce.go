@@ -0,0 +1,33 @@
+package authenticationmaster
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+const (
+	// The only PKCE code challenge method accepted. The "plain" method offers no protection if the authorization request is observed.
+	pkceMethodS256 = "S256"
+)
+
+var (
+	// A code verifier is 43 to 128 unreserved characters (RFC 7636 Section 4.1).
+	pkceCodeVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+	// A code challenge is the unpadded base64url encoding of a SHA256 hash (RFC 7636 Section 4.2).
+	pkceCodeChallengePattern = regexp.MustCompile(`^[A-Za-z0-9\-_]{43}$`)
+)
+
+// Check a code verifier against the code challenge from the authorization request, using the S256 method.
+// Returns true if the code verifier is well formed and its hash matches the code challenge.
+func verifyPKCE(codeChallenge string, codeVerifier string) bool {
+	if !pkceCodeVerifierPattern.MatchString(codeVerifier) {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(codeVerifier))
+	expectedChallenge := base64.RawURLEncoding.EncodeToString(hash[:])
+	return subtle.ConstantTimeCompare([]byte(expectedChallenge), []byte(codeChallenge)) == 1
+}
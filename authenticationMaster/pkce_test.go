@@ -0,0 +1,22 @@
+package authenticationmaster
+
+import "testing"
+
+func TestVerifyPKCE(t *testing.T) {
+	// Example from RFC 7636 Appendix B
+	codeVerifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	codeChallenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if !verifyPKCE(codeChallenge, codeVerifier) {
+		t.Errorf("Valid code verifier rejected")
+	}
+	if verifyPKCE(codeChallenge, codeVerifier[1:]+"A") {
+		t.Errorf("Incorrect code verifier accepted")
+	}
+	if verifyPKCE(codeVerifier, codeVerifier) {
+		t.Errorf("Code verifier accepted as its own challenge (plain method)")
+	}
+	if verifyPKCE(codeChallenge, "short") {
+		t.Errorf("Malformed code verifier accepted")
+	}
+}
@@ -0,0 +1,125 @@
+package authenticationmaster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+type httpRequestRegenerateRecoveryCodes struct {
+	Password string `json:"password"`
+}
+
+// The response to enrolling a second factor, or regenerating recovery codes.
+// RecoveryCodes is only given when a new set of codes is created, as the codes cannot be shown again.
+type recoveryCodesResponse struct {
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// Replace the recovery codes of the user of the access token in the request header (or cookie) with a new set.
+// The password must be given again, so a stolen access token alone cannot be used to get codes that bypass the second factor.
+func (authMaster *AuthenticationMaster) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	token, err := authMaster.verifyRequest(r)
+	if token == nil || err != nil {
+		slog.Debug("Recovery code regeneration request without valid token", "Error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	}
+	userID := token.Subject()
+
+	var regenerateRequest httpRequestRegenerateRecoveryCodes
+	err = json.NewDecoder(r.Body).Decode(&regenerateRequest)
+	if err != nil {
+		slog.Error("Found error parsing request during recovery code regeneration", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not parse form!"))
+		return
+	}
+
+	if regenerateRequest.Password == "" {
+		slog.Info("Request did not include 'password' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'password' field!"))
+		return
+	}
+	if len(regenerateRequest.Password) > passwordMaxLen {
+		slog.Info("Password is too long!", "PasswordLength", len(regenerateRequest.Password))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(fmt.Sprintf("Password must be less than %v characters long!", passwordMaxLen)))
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	clientIP := authMaster.clientIP(r)
+	username, ok := authMaster.throttlePasswordCheck(w, databaseQueryContext, userID, clientIP)
+	if !ok {
+		return
+	}
+
+	recoveryCodes, err := authMaster.databaseConnection.RegenerateRecoveryCodes(databaseQueryContext, userID, regenerateRequest.Password)
+	if databaseQueryContext.Err() == context.DeadlineExceeded {
+		slog.Info("Database query duration exceeded!", "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Database Error!"))
+		return
+	}
+	switch err {
+	case nil:
+	case database.ErrIncorrectPassword:
+		slog.Info("Recovery code regeneration with incorrect password", "UserID", userID)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Password is incorrect."))
+		return
+	case database.ErrOnFetchUserDoesNotExist:
+		slog.Error("UserID does not exist in database", "UserID", userID)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	default:
+		slog.Error("Found error during recovery code regeneration!", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred while generating recovery codes, please try again."))
+		return
+	}
+
+	authMaster.forgetLoginAttempt(context.Background(), username, clientIP)
+
+	slog.Info("Recovery codes regenerated", "UserID", userID)
+	response := recoveryCodesResponse{
+		Message:       "Store these recovery codes somewhere safe. Each can be used once in place of your second factor. Previous codes no longer work.",
+		RecoveryCodes: recoveryCodes,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Respond to a user enrolling a second factor (a TOTP secret or passkey), giving them recovery codes if they have none yet.
+// Failing to create the codes does not undo the enrollment, as the user can regenerate the codes later.
+func (authMaster *AuthenticationMaster) respondWithMFAEnrollment(w http.ResponseWriter, ctx context.Context, userID string, message string) {
+	recoveryCodes, err := authMaster.databaseConnection.CreateInitialRecoveryCodes(ctx, userID)
+	if err != nil {
+		slog.Error("Error during creation of recovery codes!", "Error", err, "UserID", userID)
+		message += " Recovery codes could not be created, please regenerate them."
+	}
+	if recoveryCodes != nil {
+		slog.Info("Recovery codes created", "UserID", userID)
+		message += " Store these recovery codes somewhere safe. Each can be used once in place of your second factor."
+	}
+
+	response := recoveryCodesResponse{
+		Message:       message,
+		RecoveryCodes: recoveryCodes,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
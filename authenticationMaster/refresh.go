@@ -0,0 +1,114 @@
+package authenticationmaster
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+type httpRequestRefresh struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// The response to a successful login, refresh, or token request. Field names follow the OAuth 2.0 token response (RFC 6749 Section 5.1).
+type tokenResponse struct {
+	AccessToken  string `json:"access_token,omitempty"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// Exchange a refresh token for a new access token and a new refresh token.
+// The refresh token is read from the request body, or in cookie session mode, from the refresh token cookie.
+//
+// Refresh tokens are single use. Presenting a refresh token that has already been exchanged
+// revokes every refresh token descended from the same login, logging out both the legitimate user and any attacker.
+func (authMaster *AuthenticationMaster) Refresh(w http.ResponseWriter, r *http.Request) {
+	// The body is optional in cookie session mode, so an empty body is not an error
+	var refreshRequest httpRequestRefresh
+	err := json.NewDecoder(r.Body).Decode(&refreshRequest)
+	if err != nil && err != io.EOF {
+		slog.Error("Found error parsing request during refresh", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not parse form!"))
+		return
+	}
+
+	if refreshRequest.RefreshToken == "" && authMaster.cookieConfig != nil {
+		refreshRequest.RefreshToken = refreshTokenFromCookie(r)
+	}
+	if refreshRequest.RefreshToken == "" {
+		slog.Info("Request did not include 'refresh_token' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'refresh_token' field!"))
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	grant, refreshToken, err := authMaster.databaseConnection.RotateRefreshToken(databaseQueryContext, refreshRequest.RefreshToken, "", time.Now().Add(refreshTokenExpirationDuration))
+	if databaseQueryContext.Err() == context.DeadlineExceeded {
+		slog.Info("Database query duration exceeded!")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Database Error!"))
+		return
+	}
+	switch err {
+	case nil:
+	case database.ErrRefreshTokenReused:
+		slog.Warn("Refresh token reused, token family revoked")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Refresh token unauthorized."))
+		return
+	case database.ErrRefreshTokenExpired:
+		slog.Info("Expired refresh token presented")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Refresh token is expired."))
+		return
+	case database.ErrRefreshTokenInvalid:
+		slog.Info("Invalid refresh token presented")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Refresh token unauthorized."))
+		return
+	default:
+		slog.Error("Found error during refresh!", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during refresh attempt, please try again."))
+		return
+	}
+
+	if authMaster.cookieConfig != nil {
+		authMaster.respondWithTokenCookies(w, grant, refreshToken)
+		return
+	}
+	authMaster.respondWithTokens(w, grant, refreshToken, "")
+}
+
+// Issue a new access token for the grant and write it, along with the refresh token (and ID token, if not empty), as the response.
+func (authMaster *AuthenticationMaster) respondWithTokens(w http.ResponseWriter, grant database.Grant, refreshToken string, idToken string) {
+	token, expiresIn, err := authMaster.issueAccessToken(grant)
+	if err != nil {
+		slog.Error("Error during creation of access token!", "Error", err, "UserID", grant.UserID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during authentication attempt, please try again"))
+		return
+	}
+
+	response := tokenResponse{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(expiresIn.Seconds()),
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
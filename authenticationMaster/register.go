@@ -47,11 +47,14 @@ func (authMaster *AuthenticationMaster) Register(w http.ResponseWriter, r *http.
 		w.Write([]byte(fmt.Sprintf("Password must be less than %v characters long!", passwordMaxLen)))
 		return
 	}
+	if !authMaster.checkPasswordPolicy(w, requestCredentials.Password, requestCredentials.Username) {
+		return
+	}
 
 	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
 	defer databaseQueryContextCancel()
 
-	err = authMaster.databaseConnection.RegisterNewUser(databaseQueryContext, requestCredentials.Username, requestCredentials.Password)
+	err = authMaster.databaseConnection.RegisterNewUser(databaseQueryContext, requestCredentials.Username, requestCredentials.Password, requestCredentials.Email)
 	if databaseQueryContext.Err() == context.DeadlineExceeded {
 		slog.Info("Database query duration exceeded!", "Username", requestCredentials.Username)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -64,6 +67,12 @@ func (authMaster *AuthenticationMaster) Register(w http.ResponseWriter, r *http.
 		w.Write([]byte("Username already exists!"))
 		return
 	}
+	if err == database.ErrInvalidEmail {
+		slog.Info("Invalid email", "Username", requestCredentials.Username)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Email must be a valid email address!"))
+		return
+	}
 	if err != nil {
 		slog.Error("Error during register of new user", "Error", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -72,6 +81,18 @@ func (authMaster *AuthenticationMaster) Register(w http.ResponseWriter, r *http.
 	}
 
 	slog.Info("User registered", "Username", requestCredentials.Username)
+
+	if requestCredentials.Email != "" && authMaster.mailer != nil {
+		userID, err := authMaster.databaseConnection.GetUserIDByUsername(databaseQueryContext, requestCredentials.Username)
+		if err == nil {
+			err = authMaster.sendEmailVerification(databaseQueryContext, userID)
+		}
+		if err != nil {
+			// The user can request another verification message, so registration has still succeeded
+			slog.Error("Error during sending of email verification", "Error", err, "Username", requestCredentials.Username)
+		}
+	}
+
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte("Registration successful!"))
 }
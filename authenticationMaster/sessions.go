@@ -0,0 +1,122 @@
+package authenticationmaster
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/hmcalister/AuthSSO/database"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// The kind of access token given to users when they log in.
+type SessionBackend int
+
+const (
+	// Access tokens are short lived JWTs, which relying services can verify offline (see JWKS).
+	// Revoked JWTs are only rejected by this server, and the claims of a JWT are readable by the client.
+	JWTSessions SessionBackend = iota
+
+	// Access tokens are random opaque session IDs, resolved against sessions stored in the database.
+	// Revoking a session takes effect everywhere immediately, and the session ID reveals nothing to the client,
+	// but every request must be authenticated by this server.
+	OpaqueSessions
+)
+
+const (
+	// Opaque sessions expire after this time, regardless of activity.
+	// Longer than tokenExpirationDuration, as sessions can be revoked instantly.
+	sessionExpirationDuration time.Duration = 12 * time.Hour
+
+	// Opaque sessions that are not used for this time expire early.
+	sessionIdleTimeout time.Duration = 30 * time.Minute
+)
+
+// Issue a new access token for the grant, using the configured session backend.
+// Returns the access token, and the time until it expires.
+//
+// Access tokens issued to a client are only for the audience of that client, and last for the token lifetime of that client (if set).
+func (authMaster *AuthenticationMaster) issueAccessToken(grant database.Grant) (string, time.Duration, error) {
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	lifetime := tokenExpirationDuration
+	if authMaster.sessionBackend == OpaqueSessions {
+		lifetime = sessionExpirationDuration
+	}
+
+	var audience string
+	if grant.ClientID != "" {
+		client, err := authMaster.databaseConnection.GetClient(databaseQueryContext, grant.ClientID)
+		if err != nil {
+			return "", 0, err
+		}
+		audience = client.Audience
+		if client.TokenLifetime != 0 {
+			lifetime = client.TokenLifetime
+		}
+	}
+
+	if authMaster.sessionBackend != OpaqueSessions {
+		claims, err := authMaster.getUserClaims(databaseQueryContext, grant.UserID)
+		if err != nil {
+			return "", 0, err
+		}
+		token, err := authMaster.generateJWT(grant, claims, audience, lifetime)
+		return token, lifetime, err
+	}
+
+	sessionID, err := authMaster.databaseConnection.CreateSession(databaseQueryContext, grant, time.Now().Add(lifetime))
+	return sessionID, lifetime, err
+}
+
+// Resolve an opaque session ID to the session it identifies.
+//
+// The session is returned as a token, so that callers can treat sessions and JWTs alike.
+// The session ID stands in as the token ID, and so is never exposed beyond this server.
+func (authMaster *AuthenticationMaster) verifySession(sessionID string) (jwt.Token, error) {
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	session, err := authMaster.databaseConnection.GetSession(databaseQueryContext, sessionID, sessionIdleTimeout)
+	switch err {
+	case nil:
+	case database.ErrSessionExpired:
+		return nil, jwtauth.ErrExpired
+	case database.ErrSessionInvalid:
+		return nil, jwtauth.ErrUnauthorized
+	default:
+		return nil, err
+	}
+
+	tokenBuilder := jwt.NewBuilder().
+		Issuer(authMaster.issuer).
+		Subject(session.UserID).
+		JwtID(sessionID).
+		IssuedAt(session.CreatedAt).
+		Expiration(session.ExpiresAt)
+	if session.ClientID != "" {
+		// The session is for the current audience of the client, so sessions of a deleted client are rejected
+		client, err := authMaster.databaseConnection.GetClient(databaseQueryContext, session.ClientID)
+		if err == database.ErrOnFetchClientDoesNotExist {
+			return nil, jwtauth.ErrUnauthorized
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		tokenBuilder = tokenBuilder.
+			Audience([]string{client.Audience}).
+			Claim("client_id", session.ClientID).
+			Claim("scope", session.Scope)
+	}
+	return tokenBuilder.Build()
+}
+
+// Revoke an access token (as returned by verifyRequest) so it is rejected for the rest of its lifetime.
+func (authMaster *AuthenticationMaster) revokeAccessToken(ctx context.Context, token jwt.Token) error {
+	if authMaster.sessionBackend == OpaqueSessions {
+		return authMaster.databaseConnection.RevokeSession(ctx, token.JwtID())
+	}
+	return authMaster.databaseConnection.RevokeToken(ctx, token.JwtID(), token.Expiration())
+}
@@ -0,0 +1,100 @@
+package authenticationmaster
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// A claim describing the user that may be added to access tokens, so relying services can learn about the user offline.
+type TokenClaim string
+
+const (
+	// The username of the user.
+	PreferredUsernameClaim TokenClaim = "preferred_username"
+
+	// The roles of the user, naming what the user may do.
+	RolesClaim TokenClaim = "roles"
+
+	// The groups the user is in.
+	GroupsClaim TokenClaim = "groups"
+
+	// The email address of the user, along with whether it has been verified (the `email_verified` claim).
+	EmailClaim TokenClaim = "email"
+)
+
+var supportedTokenClaims = []TokenClaim{PreferredUsernameClaim, RolesClaim, GroupsClaim, EmailClaim}
+
+// The claims describing the user in an access token. Only the claims configured for the authentication master are set.
+type userClaims struct {
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Roles             []string `json:"roles,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+	Email             string   `json:"email,omitempty"`
+
+	// Set only when Email is, so email_verified is only omitted for users without an email address.
+	EmailVerified *bool `json:"email_verified,omitempty"`
+}
+
+// Parse a space separated list of token claims, such as "preferred_username roles groups email".
+//
+// Fails (and returns a non-nil error) if any claim is not supported.
+func ParseTokenClaims(tokenClaimsList string) ([]TokenClaim, error) {
+	var tokenClaims []TokenClaim
+	for _, claimName := range strings.Fields(tokenClaimsList) {
+		tokenClaim := TokenClaim(claimName)
+		if !slices.Contains(supportedTokenClaims, tokenClaim) {
+			return nil, fmt.Errorf("unsupported token claim %q, must be one of %v", claimName, supportedTokenClaims)
+		}
+		tokenClaims = append(tokenClaims, tokenClaim)
+	}
+
+	return tokenClaims, nil
+}
+
+// Get the configured claims describing a user from the database.
+func (authMaster *AuthenticationMaster) getUserClaims(ctx context.Context, userID string) (userClaims, error) {
+	var claims userClaims
+	var err error
+
+	if slices.Contains(authMaster.tokenClaims, PreferredUsernameClaim) {
+		claims.PreferredUsername, err = authMaster.databaseConnection.GetUsernameByUserID(ctx, userID)
+		if err != nil {
+			return userClaims{}, err
+		}
+	}
+	if slices.Contains(authMaster.tokenClaims, RolesClaim) {
+		claims.Roles, err = authMaster.databaseConnection.GetUserRoles(ctx, userID)
+		if err != nil {
+			return userClaims{}, err
+		}
+	}
+	if slices.Contains(authMaster.tokenClaims, GroupsClaim) {
+		claims.Groups, err = authMaster.databaseConnection.GetUserGroups(ctx, userID)
+		if err != nil {
+			return userClaims{}, err
+		}
+	}
+	if slices.Contains(authMaster.tokenClaims, EmailClaim) {
+		err = authMaster.setEmailClaims(ctx, userID, &claims)
+		if err != nil {
+			return userClaims{}, err
+		}
+	}
+
+	return claims, nil
+}
+
+// Set the email claims of a user from the database, leaving them unset if the user has no email address.
+func (authMaster *AuthenticationMaster) setEmailClaims(ctx context.Context, userID string, claims *userClaims) error {
+	email, emailVerified, err := authMaster.databaseConnection.GetUserEmail(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if email != "" {
+		claims.Email = email
+		claims.EmailVerified = &emailVerified
+	}
+	return nil
+}
@@ -0,0 +1,456 @@
+package authenticationmaster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+	"github.com/hmcalister/AuthSSO/totp"
+)
+
+const (
+	// MFA challenges expire after this time, so a user who has given their password must give their second factor soon after.
+	mfaChallengeExpirationDuration time.Duration = 5 * time.Minute
+
+	// The second factors that may complete an MFA challenge.
+	mfaMethodTOTP         = "totp"
+	mfaMethodWebAuthn     = "webauthn"
+	mfaMethodRecoveryCode = "recovery_code"
+)
+
+type httpRequestTOTPCode struct {
+	Code string `json:"code"`
+}
+
+type httpRequestEnrollTOTP struct {
+	Password string `json:"password"`
+}
+
+type httpRequestDisableTOTP struct {
+	Password string `json:"password"`
+}
+
+type httpRequestLoginMFA struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type totpEnrollmentResponse struct {
+	Secret  string `json:"secret"`
+	KeyURI  string `json:"otpauth_uri"`
+	Message string `json:"message"`
+}
+
+// The response to a correct password from a user with a second factor, in place of the tokens.
+// MFAMethods lists the second factors the user may give ("totp" and/or "webauthn", and "recovery_code" if they have any left).
+type mfaChallengeResponse struct {
+	MFARequired bool     `json:"mfa_required"`
+	MFAToken    string   `json:"mfa_token"`
+	MFAMethods  []string `json:"mfa_methods"`
+	ExpiresIn   int64    `json:"expires_in"`
+}
+
+// Start TOTP enrollment for the user of the access token in the request header (or cookie), responding with a new TOTP secret.
+//
+// The secret is given both in base32 (to enter by hand) and as an otpauth:// URI (to show as a QR code) for the user's authenticator app.
+// TOTP is not enabled until ConfirmTOTP is called with a code from the app. Until then, enrolling again replaces the secret.
+// The password must be given again, so a stolen access token alone cannot be used to add a second factor (and collect the recovery codes)
+// that would lock the owner out of their account.
+func (authMaster *AuthenticationMaster) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	token, err := authMaster.verifyRequest(r)
+	if token == nil || err != nil {
+		slog.Debug("TOTP enrollment request without valid token", "Error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	}
+	userID := token.Subject()
+
+	var enrollTOTPRequest httpRequestEnrollTOTP
+	err = json.NewDecoder(r.Body).Decode(&enrollTOTPRequest)
+	if err != nil {
+		slog.Error("Found error parsing request during TOTP enrollment", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not parse form!"))
+		return
+	}
+
+	if enrollTOTPRequest.Password == "" {
+		slog.Info("Request did not include 'password' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'password' field!"))
+		return
+	}
+	if len(enrollTOTPRequest.Password) > passwordMaxLen {
+		slog.Info("Password is too long!", "PasswordLength", len(enrollTOTPRequest.Password))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(fmt.Sprintf("Password must be less than %v characters long!", passwordMaxLen)))
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	clientIP := authMaster.clientIP(r)
+	username, ok := authMaster.throttlePasswordCheck(w, databaseQueryContext, userID, clientIP)
+	if !ok {
+		return
+	}
+
+	secret, err := authMaster.databaseConnection.CreateTOTPSecret(databaseQueryContext, userID, enrollTOTPRequest.Password)
+	if databaseQueryContext.Err() == context.DeadlineExceeded {
+		slog.Info("Database query duration exceeded!", "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Database Error!"))
+		return
+	}
+	switch err {
+	case nil:
+	case database.ErrNoEncryptionKey:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Two-factor authentication is not enabled."))
+		return
+	case database.ErrIncorrectPassword:
+		slog.Info("TOTP enrollment with incorrect password", "UserID", userID)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Password is incorrect."))
+		return
+	case database.ErrOnFetchUserDoesNotExist:
+		slog.Error("UserID does not exist in database", "UserID", userID)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	case database.ErrTOTPAlreadyEnabled:
+		slog.Info("TOTP enrollment while already enabled", "UserID", userID)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Two-factor authentication is already enabled."))
+		return
+	default:
+		slog.Error("Found error during TOTP enrollment!", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during two-factor authentication enrollment, please try again."))
+		return
+	}
+
+	authMaster.forgetLoginAttempt(context.Background(), username, clientIP)
+
+	slog.Info("TOTP enrollment started", "UserID", userID)
+	response := totpEnrollmentResponse{
+		Secret:  totp.EncodeSecret(secret),
+		KeyURI:  totp.KeyURI(authMaster.totpIssuer(), username, secret),
+		Message: "Add this secret to your authenticator app, then confirm it with a code from the app.",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Confirm TOTP enrollment for the user of the access token in the request header (or cookie), given a code from their authenticator app.
+// From then on, the user must give a code (through LoginMFA) every time they log in.
+// If the user has no recovery codes yet, a set is created and given in the response.
+func (authMaster *AuthenticationMaster) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	token, err := authMaster.verifyRequest(r)
+	if token == nil || err != nil {
+		slog.Debug("TOTP confirmation request without valid token", "Error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	}
+	userID := token.Subject()
+
+	var confirmTOTPRequest httpRequestTOTPCode
+	err = json.NewDecoder(r.Body).Decode(&confirmTOTPRequest)
+	if err != nil {
+		slog.Error("Found error parsing request during TOTP confirmation", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not parse form!"))
+		return
+	}
+
+	if confirmTOTPRequest.Code == "" {
+		slog.Info("Request did not include 'code' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'code' field!"))
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	err = authMaster.databaseConnection.EnableTOTP(databaseQueryContext, userID, confirmTOTPRequest.Code)
+	switch err {
+	case nil:
+	case database.ErrNoEncryptionKey:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Two-factor authentication is not enabled."))
+		return
+	case database.ErrTOTPNotEnrolled:
+		slog.Info("TOTP confirmation without enrollment", "UserID", userID)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Two-factor authentication enrollment has not been started."))
+		return
+	case database.ErrTOTPAlreadyEnabled:
+		slog.Info("TOTP confirmation while already enabled", "UserID", userID)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Two-factor authentication is already enabled."))
+		return
+	case database.ErrIncorrectTOTPCode:
+		slog.Info("TOTP confirmation with incorrect code", "UserID", userID)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Code is incorrect."))
+		return
+	default:
+		slog.Error("Found error during TOTP confirmation!", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during two-factor authentication enrollment, please try again."))
+		return
+	}
+
+	slog.Info("TOTP enabled", "UserID", userID)
+	authMaster.respondWithMFAEnrollment(w, databaseQueryContext, userID, "Two-factor authentication enabled!")
+}
+
+// Disable TOTP for the user of the access token in the request header (or cookie), so codes are no longer required to log in.
+// The password must be given again, so a stolen access token alone cannot be used to remove the second factor.
+func (authMaster *AuthenticationMaster) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	token, err := authMaster.verifyRequest(r)
+	if token == nil || err != nil {
+		slog.Debug("TOTP disable request without valid token", "Error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	}
+	userID := token.Subject()
+
+	var disableTOTPRequest httpRequestDisableTOTP
+	err = json.NewDecoder(r.Body).Decode(&disableTOTPRequest)
+	if err != nil {
+		slog.Error("Found error parsing request during TOTP disable", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not parse form!"))
+		return
+	}
+
+	if disableTOTPRequest.Password == "" {
+		slog.Info("Request did not include 'password' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'password' field!"))
+		return
+	}
+	if len(disableTOTPRequest.Password) > passwordMaxLen {
+		slog.Info("Password is too long!", "PasswordLength", len(disableTOTPRequest.Password))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(fmt.Sprintf("Password must be less than %v characters long!", passwordMaxLen)))
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	clientIP := authMaster.clientIP(r)
+	username, ok := authMaster.throttlePasswordCheck(w, databaseQueryContext, userID, clientIP)
+	if !ok {
+		return
+	}
+
+	err = authMaster.databaseConnection.DisableTOTP(databaseQueryContext, userID, disableTOTPRequest.Password)
+	if databaseQueryContext.Err() == context.DeadlineExceeded {
+		slog.Info("Database query duration exceeded!", "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Database Error!"))
+		return
+	}
+	switch err {
+	case nil:
+	case database.ErrIncorrectPassword:
+		slog.Info("TOTP disable with incorrect password", "UserID", userID)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Password is incorrect."))
+		return
+	case database.ErrTOTPNotEnrolled:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Two-factor authentication is not enabled."))
+		return
+	case database.ErrOnFetchUserDoesNotExist:
+		slog.Error("UserID does not exist in database", "UserID", userID)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	default:
+		slog.Error("Found error during TOTP disable!", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred while disabling two-factor authentication, please try again."))
+		return
+	}
+
+	authMaster.forgetLoginAttempt(context.Background(), username, clientIP)
+
+	slog.Info("TOTP disabled", "UserID", userID)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Two-factor authentication disabled."))
+}
+
+// Complete a login using the MFA token from Login and a code from the user's authenticator app, giving the user their tokens (as for Login).
+// A recovery code may be given instead of the code, for users who have lost their second factor. Each recovery code can be used once.
+//
+// Each MFA token accepts a small number of incorrect codes, after which the user must log in again with their password.
+// Incorrect codes are also throttled with failed logins of the username (see recordMFAAttempt), so logging in again does not give unlimited guesses.
+func (authMaster *AuthenticationMaster) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var loginMFARequest httpRequestLoginMFA
+	err := json.NewDecoder(r.Body).Decode(&loginMFARequest)
+	if err != nil {
+		slog.Error("Found error parsing request during MFA login", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not parse form!"))
+		return
+	}
+
+	if loginMFARequest.MFAToken == "" {
+		slog.Info("Request did not include 'mfa_token' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'mfa_token' field!"))
+		return
+	}
+	if loginMFARequest.Code == "" && loginMFARequest.RecoveryCode == "" {
+		slog.Info("Request did not include 'code' or 'recovery_code' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'code' or 'recovery_code' field!"))
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	userID, err := authMaster.databaseConnection.GetMFAChallengeUserID(databaseQueryContext, loginMFARequest.MFAToken)
+	switch err {
+	case nil:
+	case database.ErrMFAChallengeInvalid, database.ErrMFAChallengeExpired:
+		slog.Info("Invalid MFA token presented", "Error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Login has expired, please log in again."))
+		return
+	default:
+		slog.Error("Found error during MFA login!", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during authentication attempt, please try again."))
+		return
+	}
+
+	// Codes are throttled with the password of the user, as each login with the password gives a new MFA token
+	clientIP := authMaster.clientIP(r)
+	username, ok := authMaster.throttleMFAAttempt(w, databaseQueryContext, userID, clientIP)
+	if !ok {
+		return
+	}
+
+	if loginMFARequest.Code != "" {
+		_, err = authMaster.databaseConnection.CompleteMFAChallenge(databaseQueryContext, loginMFARequest.MFAToken, loginMFARequest.Code)
+	} else {
+		_, err = authMaster.databaseConnection.CompleteMFAChallengeWithRecoveryCode(databaseQueryContext, loginMFARequest.MFAToken, loginMFARequest.RecoveryCode)
+	}
+	if databaseQueryContext.Err() == context.DeadlineExceeded {
+		slog.Info("Database query duration exceeded!")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Database Error!"))
+		return
+	}
+	switch err {
+	case nil:
+	case database.ErrIncorrectTOTPCode, database.ErrIncorrectRecoveryCode:
+		slog.Info("Invalid MFA login attempt!", "Error", err, "UserID", userID, "ClientIP", clientIP)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Code is incorrect."))
+		return
+	case database.ErrTOTPNotEnrolled:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Two-factor authentication is not enabled."))
+		return
+	case database.ErrMFAChallengeInvalid, database.ErrMFAChallengeExpired:
+		slog.Info("Invalid MFA token presented", "Error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Login has expired, please log in again."))
+		return
+	default:
+		slog.Error("Found error during MFA login!", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during authentication attempt, please try again."))
+		return
+	}
+
+	authMaster.forgetLoginAttempt(context.Background(), username, clientIP)
+	authMaster.completeLogin(w, userID)
+}
+
+// Respond to a correct password from a user with a second factor with an MFA challenge,
+// which LoginMFA (with a code) or FinishWebAuthnLogin (with a passkey) exchanges for the tokens.
+func (authMaster *AuthenticationMaster) respondWithMFAChallenge(w http.ResponseWriter, userID string, mfaMethods []string) {
+	mfaToken, err := authMaster.databaseConnection.CreateMFAChallenge(context.Background(), userID, time.Now().Add(mfaChallengeExpirationDuration))
+	if err != nil {
+		slog.Error("Error during creation of MFA challenge!", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during authentication attempt, please try again"))
+		return
+	}
+
+	response := mfaChallengeResponse{
+		MFARequired: true,
+		MFAToken:    mfaToken,
+		MFAMethods:  mfaMethods,
+		ExpiresIn:   int64(mfaChallengeExpirationDuration.Seconds()),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// The second factors a user has, any of which must be given after their password to log in. Empty if the user has none.
+// Passkeys are only counted while WebAuthn is enabled, so users are not locked out if the issuer changes.
+func (authMaster *AuthenticationMaster) mfaMethods(ctx context.Context, userID string) ([]string, error) {
+	mfaMethods := []string{}
+
+	totpEnabled, err := authMaster.databaseConnection.IsTOTPEnabled(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if totpEnabled {
+		mfaMethods = append(mfaMethods, mfaMethodTOTP)
+	}
+
+	if authMaster.webAuthn != nil {
+		credentials, err := authMaster.databaseConnection.GetWebAuthnCredentials(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if len(credentials) > 0 {
+			mfaMethods = append(mfaMethods, mfaMethodWebAuthn)
+		}
+	}
+
+	// Recovery codes stand in for a second factor, so do not require MFA on their own
+	if len(mfaMethods) > 0 {
+		recoveryCodeCount, err := authMaster.databaseConnection.CountRecoveryCodes(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if recoveryCodeCount > 0 {
+			mfaMethods = append(mfaMethods, mfaMethodRecoveryCode)
+		}
+	}
+
+	return mfaMethods, nil
+}
+
+// The issuer shown for TOTP codes in authenticator apps. If the issuer is a URL, only the host is shown.
+func (authMaster *AuthenticationMaster) totpIssuer() string {
+	if authMaster.issuerIsURL() {
+		issuerURL, _ := url.Parse(authMaster.issuer)
+		return issuerURL.Host
+	}
+	return authMaster.issuer
+}
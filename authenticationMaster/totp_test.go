@@ -0,0 +1,73 @@
+package authenticationmaster
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+	"github.com/hmcalister/AuthSSO/totp"
+)
+
+func TestTOTPIssuer(t *testing.T) {
+	testCases := []struct {
+		issuer     string
+		totpIssuer string
+	}{
+		{"https://sso.example.com", "sso.example.com"},
+		{"https://sso.example.com:8443/auth/", "sso.example.com:8443"},
+		{"AuthSSO", "AuthSSO"},
+	}
+
+	for _, testCase := range testCases {
+		authMaster := &AuthenticationMaster{issuer: testCase.issuer}
+		if authMaster.totpIssuer() != testCase.totpIssuer {
+			t.Errorf("TOTP issuer of %v is %v, expected %v", testCase.issuer, authMaster.totpIssuer(), testCase.totpIssuer)
+		}
+	}
+}
+
+func TestLoginMFAThrottled(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "loginMFATest.sqlite"))
+	if err != nil {
+		t.Fatalf("Error while opening test database: %v", err)
+	}
+	defer db.CloseDatabase()
+	db.SetEncryptionKey([]byte("01234567890123456789012345678901"))
+
+	db.RegisterNewUser(ctx, "John Smith", "Password123", "")
+	userID, _ := db.GetUserIDByUsername(ctx, "John Smith")
+	secret, _ := db.CreateTOTPSecret(ctx, userID, "Password123")
+	err = db.EnableTOTP(ctx, userID, totp.GenerateCode(secret, totp.Step(time.Now())-1))
+	if err != nil {
+		t.Fatalf("Error while enabling TOTP: %v", err)
+	}
+
+	authMaster := NewAuthenticationMaster(db, nil, "AuthSSO", nil, JWTSessions, nil, nil)
+	recorder := httptest.NewRecorder()
+	authMaster.Login(recorder, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"username": "John Smith", "password": "Password123"}`)))
+	var challenge mfaChallengeResponse
+	json.NewDecoder(recorder.Body).Decode(&challenge)
+	if recorder.Code != http.StatusOK || !challenge.MFARequired {
+		t.Fatalf("Correct password returned status %v without an MFA challenge", recorder.Code)
+	}
+
+	// The correct password does not clear the failures of the username, so incorrect codes are throttled before the MFA token runs out
+	wrongCode := totp.GenerateCode(secret, totp.Step(time.Now())+10)
+	for range 5 {
+		recorder = httptest.NewRecorder()
+		authMaster.LoginMFA(recorder, httptest.NewRequest(http.MethodPost, "/api/login/mfa", strings.NewReader(`{"mfa_token": "`+challenge.MFAToken+`", "code": "`+wrongCode+`"}`)))
+		if recorder.Code == http.StatusTooManyRequests {
+			break
+		}
+	}
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") == "" {
+		t.Errorf("Repeated incorrect codes returned status %v, expected %v with Retry-After", recorder.Code, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,711 @@
+package authenticationmaster
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+const (
+	// WebAuthn ceremonies must be finished within this time of being begun.
+	webAuthnSessionExpirationDuration time.Duration = 5 * time.Minute
+
+	// Maximum length of the name a user gives a passkey.
+	webAuthnCredentialNameMaxLen = 64
+
+	// The ceremonies a WebAuthn session may be begun for.
+	webAuthnRegistrationCeremony = "registration"
+	webAuthnLoginCeremony        = "login"
+	webAuthnMFACeremony          = "mfa"
+)
+
+type httpRequestBeginWebAuthnRegistration struct {
+	Password string `json:"password"`
+}
+
+type httpRequestBeginWebAuthnLogin struct {
+	MFAToken string `json:"mfa_token"`
+}
+
+type httpRequestFinishWebAuthn struct {
+	CeremonyToken string          `json:"ceremony_token"`
+	MFAToken      string          `json:"mfa_token"`
+	Name          string          `json:"name"`
+	Credential    json.RawMessage `json:"credential"`
+}
+
+type httpRequestDeleteWebAuthnCredential struct {
+	CredentialID string `json:"credential_id"`
+	Password     string `json:"password"`
+}
+
+// The options given to navigator.credentials.create or navigator.credentials.get,
+// along with the token identifying the ceremony, which must be given back to finish it.
+type webAuthnCeremonyResponse struct {
+	CeremonyToken string      `json:"ceremony_token"`
+	Options       interface{} `json:"options"`
+}
+
+type webAuthnCredentialResponse struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	CreatedAt  int64  `json:"created_at"`
+	LastUsedAt int64  `json:"last_used_at,omitempty"`
+}
+
+// A user as seen by the WebAuthn library.
+// The user handle is the userID, so passwordless logins find the user from the handle stored in the passkey.
+type webAuthnUser struct {
+	userID      string
+	username    string
+	credentials []webauthn.Credential
+}
+
+func (user *webAuthnUser) WebAuthnID() []byte                         { return []byte(user.userID) }
+func (user *webAuthnUser) WebAuthnName() string                       { return user.username }
+func (user *webAuthnUser) WebAuthnDisplayName() string                { return user.username }
+func (user *webAuthnUser) WebAuthnIcon() string                       { return "" }
+func (user *webAuthnUser) WebAuthnCredentials() []webauthn.Credential { return user.credentials }
+
+// Create the WebAuthn relying party of this server. The relying party ID and origin are taken from the issuer,
+// so WebAuthn is disabled (and nil is returned) unless the issuer is the URL of this server.
+func newWebAuthn(issuer string) *webauthn.WebAuthn {
+	issuerURL, err := url.Parse(issuer)
+	if err != nil || (issuerURL.Scheme != "http" && issuerURL.Scheme != "https") || issuerURL.Host == "" {
+		return nil
+	}
+
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          issuerURL.Hostname(),
+		RPDisplayName: issuerURL.Hostname(),
+		RPOrigins:     []string{issuerURL.Scheme + "://" + issuerURL.Host},
+	})
+	if err != nil {
+		slog.Error("Could not create WebAuthn relying party, WebAuthn is disabled", "Error", err, "Issuer", issuer)
+		return nil
+	}
+	return webAuthn
+}
+
+// Begin registering a passkey for the user of the access token in the request header (or cookie).
+// The response gives the options for navigator.credentials.create, and the token to finish the registration with.
+//
+// The password must be given again, as a passkey logs in without the password (and outlives changes to it),
+// so a stolen access token alone cannot be used to keep access to the account.
+//
+// Passkeys already registered to the user are excluded, so the same authenticator is not registered twice.
+func (authMaster *AuthenticationMaster) BeginWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	if authMaster.webAuthn == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Passkeys are not enabled."))
+		return
+	}
+
+	token, err := authMaster.verifyRequest(r)
+	if token == nil || err != nil {
+		slog.Debug("WebAuthn registration request without valid token", "Error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	}
+	userID := token.Subject()
+
+	var beginRequest httpRequestBeginWebAuthnRegistration
+	err = json.NewDecoder(r.Body).Decode(&beginRequest)
+	if err != nil {
+		slog.Error("Found error parsing request during WebAuthn registration", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not parse form!"))
+		return
+	}
+
+	if beginRequest.Password == "" {
+		slog.Info("Request did not include 'password' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'password' field!"))
+		return
+	}
+	if len(beginRequest.Password) > passwordMaxLen {
+		slog.Info("Password is too long!", "PasswordLength", len(beginRequest.Password))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(fmt.Sprintf("Password must be less than %v characters long!", passwordMaxLen)))
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	user, err := authMaster.loadWebAuthnUser(databaseQueryContext, userID)
+	if err != nil {
+		slog.Error("Error during retrieval of WebAuthn user", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during passkey registration, please try again."))
+		return
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, credential := range user.credentials {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+	creation, sessionData, err := authMaster.webAuthn.BeginRegistration(user,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+		webauthn.WithExclusions(exclusions),
+	)
+	if err != nil {
+		slog.Error("Error during beginning of WebAuthn registration", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during passkey registration, please try again."))
+		return
+	}
+
+	clientIP := authMaster.clientIP(r)
+	username, ok := authMaster.throttlePasswordCheck(w, databaseQueryContext, userID, clientIP)
+	if !ok {
+		return
+	}
+
+	// The ceremony is only stored if the password is correct, so it cannot be finished without the password
+	encodedSessionData, _ := json.Marshal(sessionData)
+	ceremonyToken, err := authMaster.databaseConnection.CreateWebAuthnSessionWithPassword(databaseQueryContext, database.WebAuthnSession{
+		UserID:      userID,
+		Ceremony:    webAuthnRegistrationCeremony,
+		SessionData: encodedSessionData,
+	}, beginRequest.Password, time.Now().Add(webAuthnSessionExpirationDuration))
+	if databaseQueryContext.Err() == context.DeadlineExceeded {
+		slog.Info("Database query duration exceeded!", "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Database Error!"))
+		return
+	}
+	switch err {
+	case nil:
+	case database.ErrIncorrectPassword:
+		slog.Info("WebAuthn registration with incorrect password", "UserID", userID)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Password is incorrect."))
+		return
+	case database.ErrOnFetchUserDoesNotExist:
+		slog.Error("UserID does not exist in database", "UserID", userID)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	default:
+		slog.Error("Error during creation of WebAuthn session!", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during passkey registration, please try again."))
+		return
+	}
+
+	authMaster.forgetLoginAttempt(context.Background(), username, clientIP)
+	writeWebAuthnCeremony(w, ceremonyToken, creation)
+}
+
+// Finish registering a passkey for the user of the access token in the request header (or cookie),
+// given the token from BeginWebAuthnRegistration and the credential from navigator.credentials.create.
+//
+// Once registered, the passkey can be used to log in without a password, and must be used (or a TOTP code given)
+// as a second factor when logging in with a password. If the user has no recovery codes yet, a set is created and given in the response.
+func (authMaster *AuthenticationMaster) FinishWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	if authMaster.webAuthn == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Passkeys are not enabled."))
+		return
+	}
+
+	token, err := authMaster.verifyRequest(r)
+	if token == nil || err != nil {
+		slog.Debug("WebAuthn registration request without valid token", "Error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	}
+	userID := token.Subject()
+
+	finishRequest, ok := decodeFinishWebAuthnRequest(w, r)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(finishRequest.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > webAuthnCredentialNameMaxLen || name != authMaster.htmlSanitizer.Sanitize(name) {
+		slog.Info("Invalid passkey name", "UserID", userID)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Passkey name must be less than %v characters long, and must not require sanitization!", webAuthnCredentialNameMaxLen)))
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	sessionData, ok := authMaster.consumeWebAuthnSession(w, databaseQueryContext, finishRequest.CeremonyToken, webAuthnRegistrationCeremony, userID)
+	if !ok {
+		return
+	}
+
+	user, err := authMaster.loadWebAuthnUser(databaseQueryContext, userID)
+	if err != nil {
+		slog.Error("Error during retrieval of WebAuthn user", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during passkey registration, please try again."))
+		return
+	}
+
+	parsedResponse, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(finishRequest.Credential))
+	var credential *webauthn.Credential
+	if err == nil {
+		credential, err = authMaster.webAuthn.CreateCredential(user, sessionData, parsedResponse)
+	}
+	if err != nil {
+		slog.Info("Invalid WebAuthn registration", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Passkey could not be verified."))
+		return
+	}
+
+	encodedCredential, _ := json.Marshal(credential)
+	err = authMaster.databaseConnection.AddWebAuthnCredential(databaseQueryContext, userID, database.WebAuthnCredential{
+		ID:         credential.ID,
+		Name:       name,
+		Credential: encodedCredential,
+	})
+	switch err {
+	case nil:
+	case database.ErrOnCreateCredentialExists:
+		slog.Info("Passkey already registered", "UserID", userID)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Passkey is already registered."))
+		return
+	default:
+		slog.Error("Found error during storing of WebAuthn credential!", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during passkey registration, please try again."))
+		return
+	}
+
+	slog.Info("Passkey registered", "UserID", userID)
+	authMaster.respondWithMFAEnrollment(w, databaseQueryContext, userID, "Passkey registered!")
+}
+
+// Begin logging in with a passkey. The response gives the options for navigator.credentials.get,
+// and the token to finish the login with.
+//
+// Without an mfa_token, this is a passwordless login: any passkey registered to this server may be used,
+// and the authenticator must verify the user (such as by PIN or biometrics).
+// With the mfa_token from a password login, the passkey is the second factor, and must belong to the user who gave their password.
+func (authMaster *AuthenticationMaster) BeginWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	if authMaster.webAuthn == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Passkeys are not enabled."))
+		return
+	}
+
+	var beginLoginRequest httpRequestBeginWebAuthnLogin
+	err := json.NewDecoder(r.Body).Decode(&beginLoginRequest)
+	if err != nil && err != io.EOF {
+		slog.Error("Found error parsing request during WebAuthn login", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not parse form!"))
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	if beginLoginRequest.MFAToken == "" {
+		assertion, sessionData, err := authMaster.webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+		if err != nil {
+			slog.Error("Error during beginning of WebAuthn login", "Error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("An error occurred during authentication attempt, please try again."))
+			return
+		}
+
+		authMaster.respondWithWebAuthnCeremony(w, databaseQueryContext, "", webAuthnLoginCeremony, sessionData, assertion)
+		return
+	}
+
+	userID, err := authMaster.databaseConnection.CheckMFAChallenge(databaseQueryContext, beginLoginRequest.MFAToken)
+	switch err {
+	case nil:
+	case database.ErrMFAChallengeInvalid, database.ErrMFAChallengeExpired:
+		slog.Info("Invalid MFA token presented", "Error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Login has expired, please log in again."))
+		return
+	default:
+		slog.Error("Found error during MFA login!", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during authentication attempt, please try again."))
+		return
+	}
+
+	user, err := authMaster.loadWebAuthnUser(databaseQueryContext, userID)
+	if err != nil {
+		slog.Error("Error during retrieval of WebAuthn user", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during authentication attempt, please try again."))
+		return
+	}
+	if len(user.credentials) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("No passkeys are registered."))
+		return
+	}
+
+	assertion, sessionData, err := authMaster.webAuthn.BeginLogin(user)
+	if err != nil {
+		slog.Error("Error during beginning of WebAuthn login", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during authentication attempt, please try again."))
+		return
+	}
+
+	authMaster.respondWithWebAuthnCeremony(w, databaseQueryContext, userID, webAuthnMFACeremony, sessionData, assertion)
+}
+
+// Finish logging in with a passkey, given the token from BeginWebAuthnLogin (and the mfa_token, if one was given there)
+// and the credential from navigator.credentials.get, giving the user their tokens (as for Login).
+func (authMaster *AuthenticationMaster) FinishWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	if authMaster.webAuthn == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Passkeys are not enabled."))
+		return
+	}
+
+	finishRequest, ok := decodeFinishWebAuthnRequest(w, r)
+	if !ok {
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	session, err := authMaster.databaseConnection.ConsumeWebAuthnSession(databaseQueryContext, finishRequest.CeremonyToken)
+	if err == nil && session.Ceremony != webAuthnLoginCeremony && session.Ceremony != webAuthnMFACeremony {
+		err = database.ErrWebAuthnSessionInvalid
+	}
+	sessionData, err := decodeWebAuthnSessionData(session, err)
+	if !authMaster.checkWebAuthnSession(w, err) {
+		return
+	}
+
+	// A passkey given as a second factor is throttled with the password of the user, as codes are in LoginMFA
+	clientIP := authMaster.clientIP(r)
+	var username string
+	if session.Ceremony == webAuthnMFACeremony {
+		username, ok = authMaster.throttleMFAAttempt(w, databaseQueryContext, session.UserID, clientIP)
+		if !ok {
+			return
+		}
+	}
+
+	parsedResponse, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(finishRequest.Credential))
+	var user *webAuthnUser
+	var credential *webauthn.Credential
+	if err == nil && session.Ceremony == webAuthnLoginCeremony {
+		credential, err = authMaster.webAuthn.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			user, err = authMaster.loadWebAuthnUser(databaseQueryContext, string(userHandle))
+			return user, err
+		}, sessionData, parsedResponse)
+	} else if err == nil {
+		user, err = authMaster.loadWebAuthnUser(databaseQueryContext, session.UserID)
+		if err == nil {
+			credential, err = authMaster.webAuthn.ValidateLogin(user, sessionData, parsedResponse)
+		}
+	}
+	if err == nil && credential.Authenticator.CloneWarning {
+		err = errors.New("signature counter did not increase, so the authenticator may have been cloned")
+	}
+	if err != nil {
+		slog.Info("Invalid WebAuthn login attempt!", "Error", err, "ClientIP", clientIP)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Passkey could not be verified."))
+		return
+	}
+	userID := user.userID
+
+	// A passkey given as a second factor completes the MFA challenge of the password login
+	if session.Ceremony == webAuthnMFACeremony {
+		err = authMaster.databaseConnection.ConsumeMFAChallenge(databaseQueryContext, finishRequest.MFAToken, userID)
+		if err != nil {
+			slog.Info("Invalid MFA token presented", "Error", err, "UserID", userID)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Login has expired, please log in again."))
+			return
+		}
+	}
+
+	// The signature counter of the credential is stored, so a cloned authenticator can be detected
+	encodedCredential, _ := json.Marshal(credential)
+	err = authMaster.databaseConnection.UpdateWebAuthnCredential(databaseQueryContext, userID, credential.ID, encodedCredential)
+	if err != nil {
+		slog.Error("Error during update of WebAuthn credential", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during authentication attempt, please try again."))
+		return
+	}
+
+	slog.Info("Passkey login", "UserID", userID, "Ceremony", session.Ceremony)
+	if session.Ceremony == webAuthnMFACeremony {
+		authMaster.forgetLoginAttempt(context.Background(), username, clientIP)
+	}
+	authMaster.completeLogin(w, userID)
+}
+
+// List the passkeys registered to the user of the access token in the request header (or cookie).
+func (authMaster *AuthenticationMaster) ListWebAuthnCredentials(w http.ResponseWriter, r *http.Request) {
+	token, err := authMaster.verifyRequest(r)
+	if token == nil || err != nil {
+		slog.Debug("WebAuthn credential list request without valid token", "Error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	}
+	userID := token.Subject()
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	credentials, err := authMaster.databaseConnection.GetWebAuthnCredentials(databaseQueryContext, userID)
+	if err != nil {
+		slog.Error("Error during retrieval of WebAuthn credentials", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred while listing passkeys, please try again."))
+		return
+	}
+
+	response := make([]webAuthnCredentialResponse, 0, len(credentials))
+	for _, credential := range credentials {
+		credentialResponse := webAuthnCredentialResponse{
+			ID:        base64.RawURLEncoding.EncodeToString(credential.ID),
+			Name:      credential.Name,
+			CreatedAt: credential.CreatedAt.Unix(),
+		}
+		if !credential.LastUsedAt.IsZero() {
+			credentialResponse.LastUsedAt = credential.LastUsedAt.Unix()
+		}
+		response = append(response, credentialResponse)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Remove a passkey of the user of the access token in the request header (or cookie).
+// The password must be given again, so a stolen access token alone cannot be used to remove a second factor.
+func (authMaster *AuthenticationMaster) DeleteWebAuthnCredential(w http.ResponseWriter, r *http.Request) {
+	token, err := authMaster.verifyRequest(r)
+	if token == nil || err != nil {
+		slog.Debug("WebAuthn credential deletion request without valid token", "Error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	}
+	userID := token.Subject()
+
+	var deleteCredentialRequest httpRequestDeleteWebAuthnCredential
+	err = json.NewDecoder(r.Body).Decode(&deleteCredentialRequest)
+	if err != nil {
+		slog.Error("Found error parsing request during WebAuthn credential deletion", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not parse form!"))
+		return
+	}
+
+	credentialID, err := base64.RawURLEncoding.DecodeString(deleteCredentialRequest.CredentialID)
+	if deleteCredentialRequest.CredentialID == "" || err != nil {
+		slog.Info("Request did not include valid 'credential_id' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include valid 'credential_id' field!"))
+		return
+	}
+	if deleteCredentialRequest.Password == "" {
+		slog.Info("Request did not include 'password' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'password' field!"))
+		return
+	}
+	if len(deleteCredentialRequest.Password) > passwordMaxLen {
+		slog.Info("Password is too long!", "PasswordLength", len(deleteCredentialRequest.Password))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(fmt.Sprintf("Password must be less than %v characters long!", passwordMaxLen)))
+		return
+	}
+
+	databaseQueryContext, databaseQueryContextCancel := context.WithTimeout(context.Background(), maximumDatabaseQueryDuration)
+	defer databaseQueryContextCancel()
+
+	err = authMaster.databaseConnection.DeleteWebAuthnCredential(databaseQueryContext, userID, credentialID, deleteCredentialRequest.Password)
+	if databaseQueryContext.Err() == context.DeadlineExceeded {
+		slog.Info("Database query duration exceeded!", "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Database Error!"))
+		return
+	}
+	switch err {
+	case nil:
+	case database.ErrIncorrectPassword:
+		slog.Info("WebAuthn credential deletion with incorrect password", "UserID", userID)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Password is incorrect."))
+		return
+	case database.ErrCredentialDoesNotExist:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Passkey does not exist."))
+		return
+	case database.ErrOnFetchUserDoesNotExist:
+		slog.Error("UserID does not exist in database", "UserID", userID)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Token unauthorized."))
+		return
+	default:
+		slog.Error("Found error during WebAuthn credential deletion!", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred while removing passkey, please try again."))
+		return
+	}
+
+	slog.Info("Passkey removed", "UserID", userID)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Passkey removed."))
+}
+
+// Load a user, along with their passkeys, for the WebAuthn library.
+func (authMaster *AuthenticationMaster) loadWebAuthnUser(ctx context.Context, userID string) (*webAuthnUser, error) {
+	username, err := authMaster.databaseConnection.GetUsernameByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	storedCredentials, err := authMaster.databaseConnection.GetWebAuthnCredentials(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]webauthn.Credential, 0, len(storedCredentials))
+	for _, storedCredential := range storedCredentials {
+		var credential webauthn.Credential
+		err = json.Unmarshal(storedCredential.Credential, &credential)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+
+	return &webAuthnUser{
+		userID:      userID,
+		username:    username,
+		credentials: credentials,
+	}, nil
+}
+
+// Store the state of a begun WebAuthn ceremony, and respond with the options for the browser and the token to finish the ceremony with.
+func (authMaster *AuthenticationMaster) respondWithWebAuthnCeremony(w http.ResponseWriter, ctx context.Context, userID string, ceremony string, sessionData *webauthn.SessionData, options interface{}) {
+	encodedSessionData, _ := json.Marshal(sessionData)
+	ceremonyToken, err := authMaster.databaseConnection.CreateWebAuthnSession(ctx, database.WebAuthnSession{
+		UserID:      userID,
+		Ceremony:    ceremony,
+		SessionData: encodedSessionData,
+	}, time.Now().Add(webAuthnSessionExpirationDuration))
+	if err != nil {
+		slog.Error("Error during creation of WebAuthn session!", "Error", err, "UserID", userID)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during passkey request, please try again."))
+		return
+	}
+
+	writeWebAuthnCeremony(w, ceremonyToken, options)
+}
+
+// Write the options for the browser and the token to finish a (stored) WebAuthn ceremony with as the response.
+func writeWebAuthnCeremony(w http.ResponseWriter, ceremonyToken string, options interface{}) {
+	response := webAuthnCeremonyResponse{
+		CeremonyToken: ceremonyToken,
+		Options:       options,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Consume the state of a WebAuthn ceremony, checking it was begun for the given ceremony and user.
+// If the state cannot be used, an error is written as the response and false is returned.
+func (authMaster *AuthenticationMaster) consumeWebAuthnSession(w http.ResponseWriter, ctx context.Context, ceremonyToken string, ceremony string, userID string) (webauthn.SessionData, bool) {
+	session, err := authMaster.databaseConnection.ConsumeWebAuthnSession(ctx, ceremonyToken)
+	if err == nil && (session.Ceremony != ceremony || session.UserID != userID) {
+		err = database.ErrWebAuthnSessionInvalid
+	}
+	sessionData, err := decodeWebAuthnSessionData(session, err)
+	return sessionData, authMaster.checkWebAuthnSession(w, err)
+}
+
+// Decode the session data of the WebAuthn library from a stored session, unless retrieving the session failed.
+func decodeWebAuthnSessionData(session database.WebAuthnSession, err error) (webauthn.SessionData, error) {
+	if err != nil {
+		return webauthn.SessionData{}, err
+	}
+
+	var sessionData webauthn.SessionData
+	err = json.Unmarshal(session.SessionData, &sessionData)
+	return sessionData, err
+}
+
+// Write the error from retrieving the state of a WebAuthn ceremony as the response. Returns true if there was no error.
+func (authMaster *AuthenticationMaster) checkWebAuthnSession(w http.ResponseWriter, err error) bool {
+	switch err {
+	case nil:
+		return true
+	case database.ErrWebAuthnSessionInvalid, database.ErrWebAuthnSessionExpired:
+		slog.Info("Invalid WebAuthn ceremony token presented", "Error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Passkey request is invalid or expired, please try again."))
+		return false
+	default:
+		slog.Error("Found error during retrieval of WebAuthn session!", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("An error occurred during passkey request, please try again."))
+		return false
+	}
+}
+
+// Decode the request to finish a WebAuthn ceremony. If the request is invalid, an error is written as the response and false is returned.
+func decodeFinishWebAuthnRequest(w http.ResponseWriter, r *http.Request) (httpRequestFinishWebAuthn, bool) {
+	var finishRequest httpRequestFinishWebAuthn
+	err := json.NewDecoder(r.Body).Decode(&finishRequest)
+	if err != nil {
+		slog.Error("Found error parsing request during WebAuthn ceremony", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Could not parse form!"))
+		return finishRequest, false
+	}
+
+	if finishRequest.CeremonyToken == "" {
+		slog.Info("Request did not include 'ceremony_token' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'ceremony_token' field!"))
+		return finishRequest, false
+	}
+	if len(finishRequest.Credential) == 0 {
+		slog.Info("Request did not include 'credential' field!")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Request must include 'credential' field!"))
+		return finishRequest, false
+	}
+	return finishRequest, true
+}
@@ -0,0 +1,43 @@
+package authenticationmaster
+
+import "testing"
+
+func TestNewWebAuthn(t *testing.T) {
+	testCases := []struct {
+		issuer string
+		rpID   string
+		origin string
+	}{
+		{"https://sso.example.com", "sso.example.com", "https://sso.example.com"},
+		{"https://sso.example.com:8443/auth/", "sso.example.com", "https://sso.example.com:8443"},
+		{"http://localhost:6585", "localhost", "http://localhost:6585"},
+	}
+
+	for _, testCase := range testCases {
+		webAuthn := newWebAuthn(testCase.issuer)
+		if webAuthn == nil {
+			t.Errorf("WebAuthn of %v is disabled", testCase.issuer)
+			continue
+		}
+		if webAuthn.Config.RPID != testCase.rpID {
+			t.Errorf("Relying party ID of %v is %v, expected %v", testCase.issuer, webAuthn.Config.RPID, testCase.rpID)
+		}
+		if len(webAuthn.Config.RPOrigins) != 1 || webAuthn.Config.RPOrigins[0] != testCase.origin {
+			t.Errorf("Origins of %v are %v, expected [%v]", testCase.issuer, webAuthn.Config.RPOrigins, testCase.origin)
+		}
+	}
+
+	if newWebAuthn("AuthSSO") != nil {
+		t.Errorf("WebAuthn of non-URL issuer is enabled")
+	}
+}
+
+func TestWebAuthnUser(t *testing.T) {
+	user := &webAuthnUser{userID: "2f1c2b8e-2a4b-4c8e-9d3a-4f6b1a2c3d4e", username: "alice"}
+	if string(user.WebAuthnID()) != user.userID {
+		t.Errorf("User handle is %v, expected the userID %v", string(user.WebAuthnID()), user.userID)
+	}
+	if user.WebAuthnName() != "alice" || user.WebAuthnDisplayName() != "alice" {
+		t.Errorf("User names are %v and %v, expected the username", user.WebAuthnName(), user.WebAuthnDisplayName())
+	}
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database/sqlc"
+)
+
+// An authorization code, issued to a client when a user authorizes it, to be exchanged for tokens.
+type AuthorizationCode struct {
+	ClientID string
+	UserID   string
+
+	// The redirect URI the code was sent to, which must be given again when exchanging the code.
+	RedirectURI string
+
+	Scope string
+	Nonce string
+
+	// The PKCE code challenge, which the code verifier given when exchanging the code must match.
+	CodeChallenge string
+}
+
+// Create a new authorization code. The returned (plaintext) code is sent to the client, only the hash is stored in the database.
+//
+// Fails (and returns a non-nil error) if:
+// - The code fails to be generated
+// - The code cannot be stored in the database
+func (database *DatabaseManager) CreateAuthorizationCode(ctx context.Context, authorizationCode AuthorizationCode, expiresAt time.Time) (string, error) {
+	code, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = database.queries.CreateAuthorizationCode(ctx, sqlc.CreateAuthorizationCodeParams{
+		CodeHash:      hashOpaqueToken(code),
+		ClientID:      authorizationCode.ClientID,
+		Uuid:          authorizationCode.UserID,
+		RedirectUri:   authorizationCode.RedirectURI,
+		Scope:         authorizationCode.Scope,
+		Nonce:         authorizationCode.Nonce,
+		CodeChallenge: authorizationCode.CodeChallenge,
+		ExpiresAt:     expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// Consume an authorization code, returning the details it was created with.
+// The code is removed from the database, so each code can be exchanged at most once (even if two requests race).
+//
+// Fails and returns a non-nil error if:
+// - The code does not exist, or has already been consumed (ErrAuthorizationCodeInvalid)
+// - The code has expired (ErrAuthorizationCodeExpired)
+func (database *DatabaseManager) ConsumeAuthorizationCode(ctx context.Context, code string) (AuthorizationCode, error) {
+	storedCode, err := database.queries.ConsumeAuthorizationCode(ctx, hashOpaqueToken(code))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return AuthorizationCode{}, ErrAuthorizationCodeInvalid
+		}
+		return AuthorizationCode{}, err
+	}
+
+	if time.Now().Unix() >= storedCode.ExpiresAt {
+		return AuthorizationCode{}, ErrAuthorizationCodeExpired
+	}
+
+	return AuthorizationCode{
+		ClientID:      storedCode.ClientID,
+		UserID:        storedCode.Uuid,
+		RedirectURI:   storedCode.RedirectUri,
+		Scope:         storedCode.Scope,
+		Nonce:         storedCode.Nonce,
+		CodeChallenge: storedCode.CodeChallenge,
+	}, nil
+}
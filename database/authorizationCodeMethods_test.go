@@ -0,0 +1,51 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+func TestConsumeAuthorizationCode(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "John Smith")
+	authorizationCode := database.AuthorizationCode{
+		ClientID:      "codeClient",
+		UserID:        userID,
+		RedirectURI:   "https://example.com/callback",
+		Scope:         "openid",
+		Nonce:         "nonce",
+		CodeChallenge: "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+	}
+
+	code, err := databaseManager.CreateAuthorizationCode(ctx, authorizationCode, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Error while creating authorization code: %v", err)
+	}
+
+	consumedCode, err := databaseManager.ConsumeAuthorizationCode(ctx, code)
+	if err != nil {
+		t.Fatalf("Error while consuming authorization code: %v", err)
+	}
+	if consumedCode != authorizationCode {
+		t.Errorf("Consumed authorization code does not match created code: expected %+v, got %+v", authorizationCode, consumedCode)
+	}
+
+	_, err = databaseManager.ConsumeAuthorizationCode(ctx, code)
+	if err != database.ErrAuthorizationCodeInvalid {
+		t.Errorf("Consuming authorization code twice did not return ErrAuthorizationCodeInvalid: %v", err)
+	}
+}
+
+func TestExpiredAuthorizationCode(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "John Smith")
+
+	code, _ := databaseManager.CreateAuthorizationCode(ctx, database.AuthorizationCode{ClientID: "codeClient", UserID: userID}, time.Now().Add(-time.Minute))
+	_, err := databaseManager.ConsumeAuthorizationCode(ctx, code)
+	if err != database.ErrAuthorizationCodeExpired {
+		t.Errorf("Expired authorization code did not return ErrAuthorizationCodeExpired: %v", err)
+	}
+}
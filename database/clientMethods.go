@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database/sqlc"
+	"github.com/mattn/go-sqlite3"
+)
+
+// A relying party (client) that may request tokens for users through the OpenID Connect flow.
+type Client struct {
+	ClientID string
+
+	// The exact URIs the client may ask for the user to be sent back to after authorization.
+	RedirectURIs []string
+
+	// Public clients (such as single page apps) cannot keep a secret, so have no client secret.
+	// Confidential clients must authenticate with their client secret.
+	Public bool
+
+	// The `aud` claim of access tokens issued to the client, naming the services that should accept them.
+	// If empty, the client ID is used.
+	Audience string
+
+	// How long access tokens issued to the client last. If zero, the default lifetime is used.
+	TokenLifetime time.Duration
+}
+
+// Register a new client, allowed to redirect to any of its redirect URIs.
+// Returns the client secret, which is only stored hashed and so cannot be retrieved again.
+// Public clients have no secret, and an empty string is returned.
+//
+// Fails (and returns a non-nil error) if:
+// - Any redirect URI is not an absolute URI without a fragment (ErrInvalidRedirectURI)
+// - The token lifetime is negative (ErrInvalidTokenLifetime)
+// - The client secret fails to be generated
+// - The clientID already exists in the database (ErrOnCreateClientExists)
+func (database *DatabaseManager) RegisterClient(ctx context.Context, client Client) (string, error) {
+	// Redirect URIs are stored space separated, so must not contain spaces themselves
+	for _, redirectURI := range client.RedirectURIs {
+		parsedRedirectURI, err := url.Parse(redirectURI)
+		if err != nil || !parsedRedirectURI.IsAbs() || parsedRedirectURI.Fragment != "" || strings.ContainsAny(redirectURI, " \t\n") {
+			return "", ErrInvalidRedirectURI
+		}
+	}
+
+	if client.TokenLifetime < 0 {
+		return "", ErrInvalidTokenLifetime
+	}
+
+	var clientSecret, clientSecretHash string
+	if !client.Public {
+		var err error
+		clientSecret, err = generateOpaqueToken()
+		if err != nil {
+			return "", err
+		}
+		clientSecretHash = hashOpaqueToken(clientSecret)
+	}
+
+	_, err := database.queries.CreateClient(ctx, sqlc.CreateClientParams{
+		ClientID:         client.ClientID,
+		ClientSecretHash: clientSecretHash,
+		RedirectUris:     strings.Join(client.RedirectURIs, " "),
+		Audience:         client.Audience,
+		TokenLifetime:    int64(client.TokenLifetime.Seconds()),
+	})
+	if sqliteErr, ok := err.(sqlite3.Error); ok {
+		switch errCode := sqliteErr.ExtendedCode; errCode {
+		case sqlite3.ErrConstraintPrimaryKey:
+			return "", ErrOnCreateClientExists
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return clientSecret, nil
+}
+
+// Gets a client by the clientID. Returns ErrOnFetchClientDoesNotExist if the clientID does not exist.
+func (database *DatabaseManager) GetClient(ctx context.Context, clientID string) (Client, error) {
+	client, err := database.queries.GetClient(ctx, clientID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Client{}, ErrOnFetchClientDoesNotExist
+		}
+		return Client{}, err
+	}
+
+	audience := client.Audience
+	if audience == "" {
+		audience = client.ClientID
+	}
+
+	return Client{
+		ClientID:      client.ClientID,
+		RedirectURIs:  strings.Fields(client.RedirectUris),
+		Public:        client.ClientSecretHash == "",
+		Audience:      audience,
+		TokenLifetime: time.Duration(client.TokenLifetime) * time.Second,
+	}, nil
+}
+
+// Given a clientID and client secret, validate the client is who it says it is.
+// Returns true if the client is confidential and the secret matches, or the client is public and no secret is given.
+// Returns false otherwise.
+//
+// Fails and returns a non-nil error if:
+// - The clientID does not exist in the database (ErrOnFetchClientDoesNotExist)
+func (database *DatabaseManager) ValidateClientSecret(ctx context.Context, clientID string, clientSecret string) (bool, error) {
+	client, err := database.queries.GetClient(ctx, clientID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, ErrOnFetchClientDoesNotExist
+		}
+		return false, err
+	}
+
+	if client.ClientSecretHash == "" {
+		return clientSecret == "", nil
+	}
+
+	attemptHash := hashOpaqueToken(clientSecret)
+	return subtle.ConstantTimeCompare([]byte(client.ClientSecretHash), []byte(attemptHash)) == 1, nil
+}
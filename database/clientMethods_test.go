@@ -0,0 +1,110 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+func TestRegisterClient(t *testing.T) {
+	ctx := context.Background()
+
+	clientSecret, err := databaseManager.RegisterClient(ctx, database.Client{ClientID: "confidentialClient", RedirectURIs: []string{"https://example.com/callback"}})
+	if err != nil {
+		t.Fatalf("Error while registering client: %v", err)
+	}
+
+	client, err := databaseManager.GetClient(ctx, "confidentialClient")
+	if err != nil {
+		t.Fatalf("Error while getting client: %v", err)
+	}
+	if client.Public || len(client.RedirectURIs) != 1 || client.RedirectURIs[0] != "https://example.com/callback" || client.Audience != "confidentialClient" {
+		t.Errorf("Client does not match registration: %+v", client)
+	}
+
+	valid, err := databaseManager.ValidateClientSecret(ctx, "confidentialClient", clientSecret)
+	if err != nil || !valid {
+		t.Errorf("Client secret rejected (when presented with correct secret): %v", err)
+	}
+	valid, err = databaseManager.ValidateClientSecret(ctx, "confidentialClient", "")
+	if err != nil || valid {
+		t.Errorf("Client secret accepted (when presented with no secret): %v", err)
+	}
+
+	_, err = databaseManager.RegisterClient(ctx, database.Client{ClientID: "confidentialClient", RedirectURIs: []string{"https://example.com/callback"}})
+	if err != database.ErrOnCreateClientExists {
+		t.Errorf("Registering existing client did not return ErrOnCreateClientExists: %v", err)
+	}
+}
+
+func TestRegisterPublicClient(t *testing.T) {
+	ctx := context.Background()
+
+	clientSecret, err := databaseManager.RegisterClient(ctx, database.Client{ClientID: "publicClient", RedirectURIs: []string{"https://example.com/a", "http://localhost:8080/b"}, Public: true})
+	if err != nil {
+		t.Fatalf("Error while registering client: %v", err)
+	}
+	if clientSecret != "" {
+		t.Errorf("Public client was given a client secret")
+	}
+
+	client, _ := databaseManager.GetClient(ctx, "publicClient")
+	if !client.Public || len(client.RedirectURIs) != 2 {
+		t.Errorf("Client does not match registration: %+v", client)
+	}
+
+	valid, err := databaseManager.ValidateClientSecret(ctx, "publicClient", "")
+	if err != nil || !valid {
+		t.Errorf("Public client rejected (when presented with no secret): %v", err)
+	}
+}
+
+func TestRegisterClientInvalidRedirectURI(t *testing.T) {
+	ctx := context.Background()
+
+	for _, redirectURI := range []string{"/relative", "https://example.com/#fragment", "https://example.com/a b"} {
+		_, err := databaseManager.RegisterClient(ctx, database.Client{ClientID: "invalidClient", RedirectURIs: []string{redirectURI}, Public: true})
+		if err != database.ErrInvalidRedirectURI {
+			t.Errorf("Registering client with redirect URI %q did not return ErrInvalidRedirectURI: %v", redirectURI, err)
+		}
+	}
+}
+
+func TestRegisterClientAudience(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := databaseManager.RegisterClient(ctx, database.Client{
+		ClientID:      "audienceClient",
+		RedirectURIs:  []string{"https://example.com/callback"},
+		Audience:      "https://api.example.com",
+		TokenLifetime: 5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Error while registering client: %v", err)
+	}
+
+	client, _ := databaseManager.GetClient(ctx, "audienceClient")
+	if client.Audience != "https://api.example.com" || client.TokenLifetime != 5*time.Minute {
+		t.Errorf("Client does not match registration: %+v", client)
+	}
+
+	_, err = databaseManager.RegisterClient(ctx, database.Client{ClientID: "negativeLifetimeClient", TokenLifetime: -time.Minute})
+	if err != database.ErrInvalidTokenLifetime {
+		t.Errorf("Registering client with negative token lifetime did not return ErrInvalidTokenLifetime: %v", err)
+	}
+}
+
+func TestGetUnknownClient(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := databaseManager.GetClient(ctx, "unknownClient")
+	if err != database.ErrOnFetchClientDoesNotExist {
+		t.Errorf("Getting unknown client did not return ErrOnFetchClientDoesNotExist: %v", err)
+	}
+	_, err = databaseManager.ValidateClientSecret(ctx, "unknownClient", "")
+	if err != database.ErrOnFetchClientDoesNotExist {
+		t.Errorf("Validating unknown client did not return ErrOnFetchClientDoesNotExist: %v", err)
+	}
+}
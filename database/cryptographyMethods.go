@@ -1,18 +1,75 @@
 package database
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
+	"runtime"
+	"strconv"
+	"strings"
 
 	"golang.org/x/crypto/argon2"
 )
 
 const (
-	saltLen    uint32 = 32
-	timeCost   uint32 = 1
-	memoryCost uint32 = 8 * 1024
-	threads    uint8  = 1
-	keyLen     uint32 = 32
+	saltLen uint32 = 32
+	keyLen  uint32 = 32
+
+	// Number of random bytes in an opaque token (e.g. a refresh token) handed to a client.
+	opaqueTokenLen uint32 = 32
+
+	// Length of the key secrets are encrypted with, selecting AES-256.
+	encryptionKeyLen int = 32
+
+	// Minimum length of a pepper keyed into password hashes.
+	pepperMinLen int = 32
+)
+
+// Parameters of an Argon2id hash. Each hash records the parameters it was calculated with, so the parameters can be changed.
+type argon2Params struct {
+	timeCost   uint32
+	memoryCost uint32
+	threads    uint8
+}
+
+// An Argon2id hash, as encoded in a PHC string.
+type passwordHash struct {
+	params argon2Params
+
+	// The version of the pepper the password was keyed with before hashing, or 0 if the password was not peppered.
+	pepperVersion int
+
+	salt string
+	hash string
+}
+
+// Secret peppers keyed into password hashes, by version. The zero value peppers nothing.
+//
+// Unlike the salt, peppers are not stored in the database, so a leaked database alone cannot be used to attack the hashes offline.
+type peppers struct {
+	// The version of the pepper keyed into new hashes, or 0 if new hashes are not peppered.
+	currentVersion int
+
+	keys map[int][]byte
+}
+
+var (
+	// Parameters of new hashes. Raising these replaces the hash of each user on their next successful login.
+	currentArgon2Params = argon2Params{timeCost: 2, memoryCost: 19 * 1024, threads: 1}
+
+	// Parameters of hashes stored as raw bytes (with the salt in a separate column), from before hashes were stored as PHC strings.
+	legacyArgon2Params = argon2Params{timeCost: 1, memoryCost: 8 * 1024, threads: 1}
+
+	// Limits the hashes calculated at once to one per CPU. Each hash holds memoryCost KiB, and more hashes than CPUs
+	// only starve other requests (such as those holding a database transaction) without finishing any sooner.
+	hashSemaphore = make(chan struct{}, runtime.NumCPU())
 )
 
 // Generate a new salt and return it.
@@ -28,8 +85,215 @@ func generateSalt() (string, error) {
 }
 
 // Perform the hash of a (plaintext) password with salt.
-func calculateHash(password string, salt string) string {
-	hash := argon2.IDKey([]byte(password), []byte(salt), timeCost, memoryCost, threads, keyLen)
+func calculateHash(password string, salt string, params argon2Params, hashLen uint32) string {
+	hashSemaphore <- struct{}{}
+	defer func() { <-hashSemaphore }()
+
+	hash := argon2.IDKey([]byte(password), []byte(salt), params.timeCost, params.memoryCost, params.threads, hashLen)
 
 	return string(hash)
 }
+
+// Key a (plaintext) password with the pepper of a version before it is hashed, or return it unchanged if the version is 0.
+//
+// Fails (and returns a non-nil error) if the pepper of the version is not loaded.
+func (peppers peppers) pepperPassword(password string, version int) (string, error) {
+	if version == 0 {
+		return password, nil
+	}
+	pepper, ok := peppers.keys[version]
+	if !ok {
+		return "", fmt.Errorf("pepper version %d is not loaded", version)
+	}
+
+	mac := hmac.New(sha256.New, pepper)
+	mac.Write([]byte(password))
+	return string(mac.Sum(nil)), nil
+}
+
+// Hash a (plaintext) password (or other secret, such as a recovery code) with a new salt, the current parameters, and the current pepper of peppers,
+// encoded as a PHC string such as "$argon2id$v=19$m=19456,t=2,p=1,pepper=1$<salt>$<hash>".
+//
+// This function can error if a kernel function errors, although this should never happen.
+func hashPassword(password string, peppers peppers) (string, error) {
+	pepperedPassword, err := peppers.pepperPassword(password, peppers.currentVersion)
+	if err != nil {
+		return "", err
+	}
+	salt, err := generateSalt()
+	if err != nil {
+		return "", err
+	}
+
+	return encodePHC(passwordHash{
+		params:        currentArgon2Params,
+		pepperVersion: peppers.currentVersion,
+		salt:          salt,
+		hash:          calculateHash(pepperedPassword, salt, currentArgon2Params, keyLen),
+	}), nil
+}
+
+// Check a (plaintext) password against a stored hash. Returns true if the password matches.
+// Also returns true if the hash should be replaced (by hashPassword), as it was calculated with outdated parameters or pepper.
+//
+// The stored hash is either a PHC string from hashPassword, or a raw hash from before PHC strings were used,
+// in which case legacySalt is the salt it was calculated with. PHC strings are stored with an empty salt.
+// The salt (rather than the hash) tells them apart, as a raw hash may begin with any byte, including the "$" of a PHC string.
+//
+// Fails (and returns a non-nil error) if:
+// - The stored hash cannot be decoded
+// - The pepper of the stored hash is not loaded
+func verifyPassword(password string, storedHash string, legacySalt string, peppers peppers) (ok bool, needsRehash bool, err error) {
+	decodedHash := passwordHash{params: legacyArgon2Params, salt: legacySalt, hash: storedHash}
+	if legacySalt == "" {
+		decodedHash, err = decodePHC(storedHash)
+		if err != nil {
+			return false, false, err
+		}
+	} else if len(decodedHash.salt) != int(saltLen) || len(decodedHash.hash) != int(keyLen) {
+		return false, false, errors.New("length of legacy salt or hash is not the expected length")
+	}
+
+	pepperedPassword, err := peppers.pepperPassword(password, decodedHash.pepperVersion)
+	if err != nil {
+		return false, false, err
+	}
+
+	attemptHash := calculateHash(pepperedPassword, decodedHash.salt, decodedHash.params, uint32(len(decodedHash.hash)))
+	ok = subtle.ConstantTimeCompare([]byte(decodedHash.hash), []byte(attemptHash)) == 1
+	needsRehash = decodedHash.params != currentArgon2Params ||
+		decodedHash.pepperVersion != peppers.currentVersion ||
+		len(decodedHash.salt) != int(saltLen) ||
+		len(decodedHash.hash) != int(keyLen)
+	return ok, needsRehash, nil
+}
+
+// Encode an Argon2id hash, along with its salt, parameters, and pepper version, as a PHC string.
+// The pepper version is left out of the string if the hash is not peppered.
+func encodePHC(decodedHash passwordHash) string {
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", decodedHash.params.memoryCost, decodedHash.params.timeCost, decodedHash.params.threads)
+	if decodedHash.pepperVersion != 0 {
+		params += fmt.Sprintf(",pepper=%d", decodedHash.pepperVersion)
+	}
+
+	return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s",
+		argon2.Version,
+		params,
+		base64.RawStdEncoding.EncodeToString([]byte(decodedHash.salt)),
+		base64.RawStdEncoding.EncodeToString([]byte(decodedHash.hash)),
+	)
+}
+
+// Decode a PHC string from encodePHC into the parameters, pepper version, salt, and hash.
+//
+// Fails (and returns a non-nil error) if the string is not an Argon2id hash of a supported version.
+func decodePHC(encodedHash string) (passwordHash, error) {
+	fields := strings.Split(encodedHash, "$")
+	if len(fields) != 6 || fields[0] != "" || fields[1] != "argon2id" {
+		return passwordHash{}, errors.New("hash is not an argon2id PHC string")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(fields[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return passwordHash{}, fmt.Errorf("argon2id version %q is not supported", fields[2])
+	}
+
+	var decodedHash passwordHash
+	params, pepperParam, peppered := strings.Cut(fields[3], ",pepper=")
+	_, err := fmt.Sscanf(params, "m=%d,t=%d,p=%d", &decodedHash.params.memoryCost, &decodedHash.params.timeCost, &decodedHash.params.threads)
+	if err != nil || decodedHash.params.memoryCost == 0 || decodedHash.params.timeCost == 0 || decodedHash.params.threads == 0 {
+		return passwordHash{}, fmt.Errorf("argon2id parameters %q are invalid", fields[3])
+	}
+	if peppered {
+		decodedHash.pepperVersion, err = strconv.Atoi(pepperParam)
+		if err != nil || decodedHash.pepperVersion <= 0 {
+			return passwordHash{}, fmt.Errorf("pepper version %q is invalid", pepperParam)
+		}
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil {
+		return passwordHash{}, err
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil {
+		return passwordHash{}, err
+	}
+	if len(hash) == 0 {
+		return passwordHash{}, errors.New("argon2id hash is empty")
+	}
+
+	decodedHash.salt = string(salt)
+	decodedHash.hash = string(hash)
+	return decodedHash, nil
+}
+
+// Generate a new random opaque token, encoded so it is safe to hand to a client.
+//
+// This function can error if a kernel function errors, although this should never happen.
+func generateOpaqueToken() (string, error) {
+	token := make([]byte, opaqueTokenLen)
+	if _, err := io.ReadFull(rand.Reader, token); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// Hash an opaque token for storage in the database.
+//
+// Opaque tokens already have high entropy, so a fast unsalted hash is sufficient.
+// Storing only the hash means a leaked database cannot be used to impersonate users.
+func hashOpaqueToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// Encrypt a secret (such as a TOTP secret) for storage in the database, using AES-GCM.
+//
+// The additional data (such as the userID the secret belongs to) is authenticated but not stored,
+// so the encrypted secret cannot be decrypted if it is moved to another row.
+func encryptSecret(key []byte, secret []byte, additionalData string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	encryptedSecret := aead.Seal(nonce, nonce, secret, []byte(additionalData))
+	return base64.RawStdEncoding.EncodeToString(encryptedSecret), nil
+}
+
+// Decrypt a secret encrypted by encryptSecret, with the same key and additional data.
+//
+// Fails (and returns a non-nil error) if the encrypted secret has been tampered with, or the key or additional data differ.
+func decryptSecret(key []byte, encryptedSecret string, additionalData string) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(encryptedSecret)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted secret is shorter than the nonce")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, []byte(additionalData))
+}
@@ -1,6 +1,8 @@
 package database
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 )
 
@@ -18,15 +20,224 @@ func TestHashCalculation(t *testing.T) {
 	salt1, _ := generateSalt()
 	salt2, _ := generateSalt()
 
-	if calculateHash(password1, salt1) == calculateHash(password1, salt2) {
+	params := currentArgon2Params
+
+	if calculateHash(password1, salt1, params, keyLen) == calculateHash(password1, salt2, params, keyLen) {
 		t.Error("Hashes of same password with different salt are equal")
 	}
 
-	if calculateHash(password1, salt1) == calculateHash(password2, salt1) {
+	if calculateHash(password1, salt1, params, keyLen) == calculateHash(password2, salt1, params, keyLen) {
 		t.Error("Hashes of different password with same salt are equal")
 	}
 
-	if calculateHash(password1, salt1) != calculateHash(password1, salt1) {
+	if calculateHash(password1, salt1, params, keyLen) != calculateHash(password1, salt1, params, keyLen) {
 		t.Error("Hashes of same password and same salt are not equal")
 	}
+
+	if calculateHash(password1, salt1, params, keyLen) == calculateHash(password1, salt1, legacyArgon2Params, keyLen) {
+		t.Error("Hashes of same password and same salt with different parameters are equal")
+	}
+}
+
+func TestPHCEncoding(t *testing.T) {
+	salt, _ := generateSalt()
+	encodedHash := passwordHash{
+		params:        currentArgon2Params,
+		pepperVersion: 2,
+		salt:          salt,
+		hash:          calculateHash("password123", salt, currentArgon2Params, keyLen),
+	}
+
+	encodedString := encodePHC(encodedHash)
+	if !strings.HasPrefix(encodedString, "$argon2id$v=19$m=19456,t=2,p=1,pepper=2$") {
+		t.Errorf("PHC string %q does not record the algorithm, version, parameters, and pepper version", encodedString)
+	}
+
+	decodedHash, err := decodePHC(encodedString)
+	if err != nil {
+		t.Fatalf("Error during PHC string decoding %v", err)
+	}
+	if decodedHash != encodedHash {
+		t.Error("Decoded PHC string does not equal the encoded parameters, pepper version, salt, and hash")
+	}
+
+	encodedHash.pepperVersion = 0
+	encodedString = encodePHC(encodedHash)
+	if strings.Contains(encodedString, "pepper") {
+		t.Errorf("PHC string %q of unpeppered hash records a pepper version", encodedString)
+	}
+	decodedHash, err = decodePHC(encodedString)
+	if err != nil || decodedHash != encodedHash {
+		t.Errorf("Decoded PHC string of unpeppered hash does not equal the encoded hash %v", err)
+	}
+
+	invalidHashes := []string{
+		"",
+		"$argon2i$v=19$m=19456,t=2,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=16$m=19456,t=2,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=0,t=2,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$t=2,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=19456,t=2,p=1,pepper=0$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=19456,t=2,p=1,pepper=x$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=19456,t=2,p=1$c2FsdA!$aGFzaA",
+		"$argon2id$v=19$m=19456,t=2,p=1$c2FsdA$",
+		"$argon2id$v=19$m=19456,t=2,p=1$c2FsdA",
+	}
+	for _, invalidHash := range invalidHashes {
+		if _, err := decodePHC(invalidHash); err == nil {
+			t.Errorf("Invalid PHC string %q decoded without error", invalidHash)
+		}
+	}
+}
+
+func TestPasswordVerification(t *testing.T) {
+	hashedPassword, err := hashPassword("password123", peppers{})
+	if err != nil {
+		t.Fatalf("Error during password hashing %v", err)
+	}
+
+	ok, needsRehash, err := verifyPassword("password123", hashedPassword, "", peppers{})
+	if err != nil || !ok {
+		t.Errorf("Correct password not verified %v", err)
+	}
+	if needsRehash {
+		t.Error("Hash with current parameters needs rehash")
+	}
+
+	ok, _, err = verifyPassword("qwerty321", hashedPassword, "", peppers{})
+	if err != nil || ok {
+		t.Errorf("Incorrect password verified %v", err)
+	}
+
+	outdatedParams := argon2Params{timeCost: 1, memoryCost: 1024, threads: 1}
+	salt, _ := generateSalt()
+	outdatedHash := encodePHC(passwordHash{
+		params: outdatedParams,
+		salt:   salt,
+		hash:   calculateHash("password123", salt, outdatedParams, keyLen),
+	})
+	ok, needsRehash, err = verifyPassword("password123", outdatedHash, "", peppers{})
+	if err != nil || !ok {
+		t.Errorf("Correct password not verified against hash with outdated parameters %v", err)
+	}
+	if !needsRehash {
+		t.Error("Hash with outdated parameters does not need rehash")
+	}
+}
+
+func TestPepperedPasswordVerification(t *testing.T) {
+	pepper1 := bytes.Repeat([]byte{1}, pepperMinLen)
+	pepper2 := bytes.Repeat([]byte{2}, pepperMinLen)
+	firstPeppers := peppers{currentVersion: 1, keys: map[int][]byte{1: pepper1}}
+	rotatedPeppers := peppers{currentVersion: 2, keys: map[int][]byte{1: pepper1, 2: pepper2}}
+
+	hashedPassword, err := hashPassword("password123", firstPeppers)
+	if err != nil {
+		t.Fatalf("Error during password hashing %v", err)
+	}
+
+	ok, needsRehash, err := verifyPassword("password123", hashedPassword, "", firstPeppers)
+	if err != nil || !ok {
+		t.Errorf("Correct password not verified with same pepper %v", err)
+	}
+	if needsRehash {
+		t.Error("Hash with current pepper needs rehash")
+	}
+
+	ok, needsRehash, err = verifyPassword("password123", hashedPassword, "", rotatedPeppers)
+	if err != nil || !ok {
+		t.Errorf("Correct password not verified after pepper rotation %v", err)
+	}
+	if !needsRehash {
+		t.Error("Hash with previous pepper does not need rehash")
+	}
+
+	wrongPeppers := peppers{currentVersion: 1, keys: map[int][]byte{1: pepper2}}
+	ok, _, err = verifyPassword("password123", hashedPassword, "", wrongPeppers)
+	if err != nil || ok {
+		t.Errorf("Correct password verified with a different pepper %v", err)
+	}
+
+	if _, _, err := verifyPassword("password123", hashedPassword, "", peppers{}); err == nil {
+		t.Error("Peppered hash verified without error when pepper is not loaded")
+	}
+
+	unpepperedPassword, _ := hashPassword("password123", peppers{})
+	ok, needsRehash, err = verifyPassword("password123", unpepperedPassword, "", firstPeppers)
+	if err != nil || !ok {
+		t.Errorf("Correct password not verified against unpeppered hash %v", err)
+	}
+	if !needsRehash {
+		t.Error("Unpeppered hash does not need rehash when a pepper is loaded")
+	}
+}
+
+func TestLegacyPasswordVerification(t *testing.T) {
+	salt, _ := generateSalt()
+	legacyHash := calculateHash("password123", salt, legacyArgon2Params, keyLen)
+
+	ok, needsRehash, err := verifyPassword("password123", legacyHash, salt, peppers{})
+	if err != nil || !ok {
+		t.Errorf("Correct password not verified against legacy hash %v", err)
+	}
+	if !needsRehash {
+		t.Error("Legacy hash does not need rehash")
+	}
+
+	ok, _, err = verifyPassword("qwerty321", legacyHash, salt, peppers{})
+	if err != nil || ok {
+		t.Errorf("Incorrect password verified against legacy hash %v", err)
+	}
+
+	if _, _, err := verifyPassword("password123", legacyHash, "", peppers{}); err == nil {
+		t.Error("Legacy hash without salt verified without error")
+	}
+}
+
+func TestLegacyPasswordVerificationDollarPrefix(t *testing.T) {
+	// This salt gives a legacy hash of "password123" beginning with "$", like a PHC string
+	salt := "00000000000000000000000000000136"
+	legacyHash := calculateHash("password123", salt, legacyArgon2Params, keyLen)
+	if !strings.HasPrefix(legacyHash, "$") {
+		t.Fatalf("Legacy hash does not begin with $")
+	}
+
+	ok, needsRehash, err := verifyPassword("password123", legacyHash, salt, peppers{})
+	if err != nil || !ok {
+		t.Errorf("Correct password not verified against legacy hash beginning with $ %v", err)
+	}
+	if !needsRehash {
+		t.Error("Legacy hash does not need rehash")
+	}
+
+	ok, _, err = verifyPassword("qwerty321", legacyHash, salt, peppers{})
+	if err != nil || ok {
+		t.Errorf("Incorrect password verified against legacy hash beginning with $ %v", err)
+	}
+}
+
+func TestSecretEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, encryptionKeyLen)
+	otherKey := bytes.Repeat([]byte{2}, encryptionKeyLen)
+	secret := []byte("12345678901234567890")
+
+	encryptedSecret, err := encryptSecret(key, secret, "user1")
+	if err != nil {
+		t.Fatalf("Error during secret encryption %v", err)
+	}
+	if bytes.Contains([]byte(encryptedSecret), secret) {
+		t.Error("Encrypted secret contains the plaintext secret")
+	}
+
+	decryptedSecret, err := decryptSecret(key, encryptedSecret, "user1")
+	if err != nil || !bytes.Equal(decryptedSecret, secret) {
+		t.Errorf("Decrypted secret does not equal secret %v", err)
+	}
+
+	if _, err := decryptSecret(otherKey, encryptedSecret, "user1"); err == nil {
+		t.Error("Secret decrypted with a different key")
+	}
+	if _, err := decryptSecret(key, encryptedSecret, "user2"); err == nil {
+		t.Error("Secret decrypted with different additional data")
+	}
 }
@@ -3,7 +3,11 @@ package database
 import (
 	"context"
 	"database/sql"
-	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/hmcalister/AuthSSO/database/sqlc"
@@ -17,9 +21,51 @@ import (
 //go:embed schema.sql
 var ddl string
 
+// Columns added to tables by schema.sql after those tables were first created.
+// CREATE TABLE IF NOT EXISTS leaves existing tables untouched, so databases created before a column was added
+// are migrated by adding the column here. Databases that already have the column are left unchanged.
+var addedColumns = []string{
+	"ALTER TABLE refreshTokens ADD COLUMN client_id text NOT NULL DEFAULT ''",
+	"ALTER TABLE refreshTokens ADD COLUMN scope text NOT NULL DEFAULT ''",
+	"ALTER TABLE sessions ADD COLUMN client_id text NOT NULL DEFAULT ''",
+	"ALTER TABLE sessions ADD COLUMN scope text NOT NULL DEFAULT ''",
+	"ALTER TABLE clients ADD COLUMN audience text NOT NULL DEFAULT ''",
+	"ALTER TABLE clients ADD COLUMN token_lifetime integer NOT NULL DEFAULT 0",
+	"ALTER TABLE users ADD COLUMN email text",
+	"ALTER TABLE users ADD COLUMN email_verified boolean NOT NULL DEFAULT FALSE",
+	"ALTER TABLE sessions ADD COLUMN family_id text NOT NULL DEFAULT ''",
+}
+
+// Indexes on columns in addedColumns, which can only be created once the columns have been added.
+var addedIndexes = []string{
+	// Only verified email addresses are unique. An unverified address may be given by several users,
+	// so no user can claim an address (or find out if it is registered) without receiving mail there.
+	"DROP INDEX IF EXISTS users_email",
+	"CREATE UNIQUE INDEX IF NOT EXISTS users_verified_email ON users (email) WHERE email_verified",
+}
+
+// Auth data checked in place of that of a username that does not exist, so the password is hashed either way (with the current parameters).
+// No password hashes to this, as the hash is all zeroes.
+var dummyAuthDatum = sqlc.AuthenticationDatum{
+	HashedPassword: encodePHC(passwordHash{
+		params: currentArgon2Params,
+		salt:   string(make([]byte, saltLen)),
+		hash:   string(make([]byte, keyLen)),
+	}),
+}
+
 type DatabaseManager struct {
 	db      *sql.DB
 	queries *sqlc.Queries
+
+	// Closed to stop the background pruning of expired tokens.
+	stopPruning chan struct{}
+
+	// The key secrets (such as TOTP secrets) are encrypted with. Nil until SetEncryptionKey is called.
+	encryptionKey []byte
+
+	// The peppers keyed into password hashes. Passwords are not peppered until SetPeppers is called.
+	peppers peppers
 }
 
 // Creates a new database struct at the given filepath (erroring if not possible)
@@ -41,18 +87,111 @@ func NewDatabase(databaseFilePath string) (*DatabaseManager, error) {
 		return nil, err
 	}
 
+	for _, addColumn := range addedColumns {
+		_, err := db.ExecContext(ctx, addColumn)
+		if err != nil && !strings.HasPrefix(err.Error(), "duplicate column name") {
+			return nil, err
+		}
+	}
+	for _, addIndex := range addedIndexes {
+		if _, err := db.ExecContext(ctx, addIndex); err != nil {
+			return nil, err
+		}
+	}
+
 	queries := sqlc.New(db)
 
-	return &DatabaseManager{
-		db:      db,
-		queries: queries,
-	}, nil
+	database := &DatabaseManager{
+		db:          db,
+		queries:     queries,
+		stopPruning: make(chan struct{}),
+	}
+	go database.pruneExpiredTokensPeriodically(expiredTokenPruneInterval)
+
+	return database, nil
 }
 
 func (database *DatabaseManager) CloseDatabase() error {
+	close(database.stopPruning)
 	return database.db.Close()
 }
 
+// Set the key that secrets stored in the database (such as TOTP secrets) are encrypted with.
+// Without a key, methods storing or reading these secrets fail with ErrNoEncryptionKey.
+//
+// Changing the key makes every secret encrypted with the previous key unreadable.
+//
+// Fails (and returns a non-nil error) if:
+// - The key is not 32 bytes long (ErrInvalidEncryptionKey)
+func (database *DatabaseManager) SetEncryptionKey(key []byte) error {
+	if len(key) != encryptionKeyLen {
+		return ErrInvalidEncryptionKey
+	}
+
+	database.encryptionKey = key
+	return nil
+}
+
+// Set the secret peppers keyed into password hashes, by version. New hashes are peppered with the pepper of currentVersion,
+// or not peppered if currentVersion is 0. Each hash records the version of its pepper, and is rehashed with the current pepper
+// on the next successful login, so the pepper can be rotated by setting a new current version while keeping the previous peppers.
+//
+// A previous pepper can be dropped once every user has logged in since it was replaced. Users whose hash uses a pepper
+// that is not set cannot log in until it is set again, and losing a pepper locks these users out for good.
+//
+// Fails (and returns a non-nil error) if:
+// - Any version is not positive, or any pepper is shorter than 32 bytes (ErrInvalidPepper)
+// - The current version is not 0, and has no pepper (ErrNoCurrentPepper)
+func (database *DatabaseManager) SetPeppers(currentVersion int, pepperKeys map[int][]byte) error {
+	for version, pepper := range pepperKeys {
+		if version <= 0 || len(pepper) < pepperMinLen {
+			return ErrInvalidPepper
+		}
+	}
+	if _, ok := pepperKeys[currentVersion]; currentVersion != 0 && !ok {
+		return ErrNoCurrentPepper
+	}
+
+	database.peppers = peppers{
+		currentVersion: currentVersion,
+		keys:           pepperKeys,
+	}
+	return nil
+}
+
+// Load the peppers keyed into password hashes from files, given as a space separated list of version=path pairs,
+// such as "2=pepper2.secret 1=pepper1.secret". The first pepper is the current pepper, see SetPeppers.
+//
+// Fails (and returns a non-nil error) if:
+// - Any pair is not a positive version and a path, or any version is duplicated
+// - Any pepper file cannot be read
+// - Any pepper is invalid (see SetPeppers)
+func (database *DatabaseManager) LoadPeppers(pepperFilesList string) error {
+	currentVersion := 0
+	pepperKeys := make(map[int][]byte)
+	for _, pepperFile := range strings.Fields(pepperFilesList) {
+		versionString, pepperFilePath, found := strings.Cut(pepperFile, "=")
+		version, err := strconv.Atoi(versionString)
+		if !found || err != nil || version <= 0 || pepperFilePath == "" {
+			return fmt.Errorf("pepper file %q is not a positive version and a path, such as 1=pepper.secret", pepperFile)
+		}
+		if _, ok := pepperKeys[version]; ok {
+			return fmt.Errorf("pepper version %d is duplicated", version)
+		}
+
+		pepper, err := os.ReadFile(pepperFilePath)
+		if err != nil {
+			return err
+		}
+		pepperKeys[version] = pepper
+		if currentVersion == 0 {
+			currentVersion = version
+		}
+	}
+
+	return database.SetPeppers(currentVersion, pepperKeys)
+}
+
 // Checks if a user exists in the database. Returns true if the user exists already.
 func (database *DatabaseManager) CheckUserExists(ctx context.Context, username string) (bool, error) {
 	user, err := database.queries.GetUserByUsername(ctx, username)
@@ -73,42 +212,55 @@ func (database *DatabaseManager) GetUsernameByUserID(ctx context.Context, userID
 	return user.Username, nil
 }
 
-// Gets the userID of a user specified by the username. Returns an error if the username does not exist.
+// Gets the userID of a user specified by the username. Returns ErrOnFetchUserDoesNotExist if the username does not exist.
 func (database *DatabaseManager) GetUserIDByUsername(ctx context.Context, username string) (string, error) {
 	user, err := database.queries.GetUserByUsername(ctx, username)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrOnFetchUserDoesNotExist
+		}
 		return "", err
 	}
 
 	return user.Uuid, nil
 }
 
-// Given a username and password, attempt to register a new user
+// Given a username, password, and email address, attempt to register a new user.
+// The email address is optional (and not stored if empty), and is unverified until VerifyEmail is called.
 //
 // Fails (and returns a non-nil error) if:
+// - The email address is not empty, and is not a valid address (ErrInvalidEmail)
 // - The salt fails to be generated
 // - The username already exists in the database (ErrUserExists)
 // - The transaction to store both the new user data and the new auth data fails
 //
 // This method ensures that the new user data and auth data is create atomically, so
 // a user cannot exist without auth data, and auth data cannot exist without a user
-func (database *DatabaseManager) RegisterNewUser(ctx context.Context, username string, password string) error {
-	salt, err := generateSalt()
+func (database *DatabaseManager) RegisterNewUser(ctx context.Context, username string, password string, email string) error {
+	var emailColumn sql.NullString
+	if email != "" {
+		normalizedEmail, err := normalizeEmail(email)
+		if err != nil {
+			return err
+		}
+		emailColumn = sql.NullString{String: normalizedEmail, Valid: true}
+	}
+
+	hashedPassword, err := hashPassword(password, database.peppers)
 	if err != nil {
 		return err
 	}
-	hashedPassword := calculateHash(password, salt)
 	newUserUUID := uuid.New().String()
 
 	newUserAuthDatum := sqlc.CreateAuthenticationDataParams{
 		Uuid:           newUserUUID,
-		HashedPassword: string(hashedPassword),
-		Salt:           string(salt),
+		HashedPassword: hashedPassword,
 	}
 
 	newUserDatum := sqlc.CreateUserParams{
 		Uuid:     newUserUUID,
 		Username: username,
+		Email:    emailColumn,
 	}
 
 	// Begin database transaction to ensure user and authdata created together
@@ -139,7 +291,7 @@ func (database *DatabaseManager) RegisterNewUser(ctx context.Context, username s
 	return tx.Commit()
 }
 
-// Delete a user from the database, including the authdata and user.
+// Delete a user from the database, including the authdata, refresh tokens, authorization codes, sessions, password reset and email verification tokens, TOTP secret, MFA challenges, WebAuthn credentials and sessions, roles, groups, and user.
 //
 // Fails and returns a non-nil error if:
 // - The user does not exist in the database
@@ -154,8 +306,6 @@ func (database *DatabaseManager) DeleteUserByUsername(ctx context.Context, usern
 		return err
 	}
 
-	userUUID := userData.Uuid
-
 	// Begin database transaction to ensure user and authdata deleted together
 	tx, err := database.db.Begin()
 	if err != nil {
@@ -164,28 +314,123 @@ func (database *DatabaseManager) DeleteUserByUsername(ctx context.Context, usern
 	// If anything fails, an early return is triggered (before tx.Commit is called) and tx.Rollback is called
 	defer tx.Rollback()
 
+	err = deleteUser(ctx, database.queries.WithTx(tx), userData.Uuid)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Given a userID and the password of that user, delete the user from the database as DeleteUserByUsername does.
+// The password is checked in the same transaction as the deletion, so the password cannot be changed in between.
+//
+// Fails (and returns a non-nil error) if:
+// - The user does not exist in the database (ErrOnFetchUserDoesNotExist)
+// - The password is incorrect (ErrIncorrectPassword)
+// - The transaction to check the password and delete the user fails
+func (database *DatabaseManager) DeleteUserWithPassword(ctx context.Context, userID string, password string) error {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+	// If anything fails, an early return is triggered (before tx.Commit is called) and tx.Rollback is called
+	defer tx.Rollback()
+
 	qtx := database.queries.WithTx(tx)
-	err = qtx.DeleteUser(ctx, userUUID)
+	authDatum, err := qtx.GetAuthData(ctx, userID)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrOnFetchUserDoesNotExist
+		}
 		return err
 	}
-	err = qtx.DeleteAuthData(ctx, userUUID)
+
+	ok, err := database.checkPassword(authDatum, password)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrIncorrectPassword
+	}
+
+	err = deleteUser(ctx, qtx, userID)
 	if err != nil {
 		return err
 	}
 	return tx.Commit()
 }
 
+// Delete every row belonging to a user, using queries that should be part of a transaction.
+func deleteUser(ctx context.Context, qtx *sqlc.Queries, userUUID string) error {
+	err := qtx.DeleteUser(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+	err = qtx.DeleteAuthData(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+	err = qtx.DeleteRefreshTokensByUser(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+	err = qtx.DeleteAuthorizationCodesByUser(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+	err = qtx.DeleteSessionsByUser(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+	err = qtx.DeletePasswordResetTokensByUser(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+	err = qtx.DeleteEmailVerificationTokensByUser(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+	_, err = qtx.DeleteTOTPSecret(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+	err = qtx.DeleteMFAChallengesByUser(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+	err = qtx.DeleteWebAuthnCredentialsByUser(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+	err = qtx.DeleteRecoveryCodesByUser(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+	err = qtx.DeleteWebAuthnSessionsByUser(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+	err = qtx.DeleteUserRolesByUser(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+	return qtx.DeleteUserGroupsByUser(ctx, userUUID)
+}
+
 // Given a username and a password, validate the login attempt.
 // Returns true if the username is valid, and the password matches the expected hash.
 // Returns false otherwise.
 //
+// If the username does not exist, the password is still hashed (against a dummy hash) before returning,
+// so the time taken does not reveal which usernames exist.
+//
 // Fails and returns a non-nil error if:
-// - The username does not exist in the database
+// - The username does not exist in the database (ErrOnFetchUserDoesNotExist)
 func (database *DatabaseManager) ValidateLoginAttempt(ctx context.Context, username string, passwordAttempt string) (bool, error) {
 	userDatum, err := database.queries.GetUserByUsername(ctx, username)
 	if err != nil {
 		if err == sql.ErrNoRows {
+			database.checkPassword(dummyAuthDatum, passwordAttempt)
 			return false, ErrOnFetchUserDoesNotExist
 		}
 		return false, err
@@ -195,14 +440,95 @@ func (database *DatabaseManager) ValidateLoginAttempt(ctx context.Context, usern
 		return false, err
 	}
 
-	if len(authDatum.Salt) != int(saltLen) {
-		return false, errors.New("length of authDatum salt does not equal expected saltLen")
+	ok, needsRehash, err := verifyPassword(passwordAttempt, authDatum.HashedPassword, authDatum.Salt, database.peppers)
+	if err != nil || !ok {
+		return false, err
+	}
+	if needsRehash {
+		database.rehashPassword(ctx, authDatum, passwordAttempt)
 	}
+	return true, nil
+}
 
-	if len(authDatum.HashedPassword) != int(keyLen) {
-		return false, errors.New("length of authDatum hashedPassword does not equal expected keyLen")
+// Given a userID, the current password, and a new password, change the password of the user.
+// The new password is stored with a newly generated salt.
+//
+// Fails (and returns a non-nil error) if:
+// - The user does not exist in the database (ErrOnFetchUserDoesNotExist)
+// - The current password is incorrect (ErrIncorrectPassword)
+// - The salt fails to be generated
+// - The transaction to check and update the auth data fails
+func (database *DatabaseManager) ChangePassword(ctx context.Context, userID string, currentPassword string, newPassword string) error {
+	hashedPassword, err := hashPassword(newPassword, database.peppers)
+	if err != nil {
+		return err
+	}
+
+	// Begin database transaction so the password cannot be changed by another request between the check and the update
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+	// If anything fails, an early return is triggered (before tx.Commit is called) and tx.Rollback is called
+	defer tx.Rollback()
+
+	qtx := database.queries.WithTx(tx)
+	authDatum, err := qtx.GetAuthData(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrOnFetchUserDoesNotExist
+		}
+		return err
+	}
+
+	ok, err := database.checkPassword(authDatum, currentPassword)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrIncorrectPassword
+	}
+
+	err = qtx.UpdateAuthenticationData(ctx, sqlc.UpdateAuthenticationDataParams{
+		HashedPassword: hashedPassword,
+		Uuid:           userID,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Check a password attempt against the stored auth data of a user.
+//
+// Fails (and returns a non-nil error) if:
+// - The stored hash cannot be decoded, or its pepper is not loaded (see verifyPassword)
+func (database *DatabaseManager) checkPassword(authDatum sqlc.AuthenticationDatum, passwordAttempt string) (bool, error) {
+	ok, _, err := verifyPassword(passwordAttempt, authDatum.HashedPassword, authDatum.Salt, database.peppers)
+	return ok, err
+}
+
+// Replace the hash of a user's (correct) password, calculated with outdated parameters or pepper, with one using the current parameters and pepper.
+// The hash is only replaced if it is unchanged, so a password changed by another request in the meantime is kept.
+//
+// The login has already succeeded, so failing to replace the hash is only logged, and the hash is replaced on a later login.
+func (database *DatabaseManager) rehashPassword(ctx context.Context, authDatum sqlc.AuthenticationDatum, password string) {
+	hashedPassword, err := hashPassword(password, database.peppers)
+	if err != nil {
+		slog.Error("Error during rehash of password", "Error", err, "UserID", authDatum.Uuid)
+		return
 	}
 
-	attemptHash := calculateHash(passwordAttempt, authDatum.Salt)
-	return authDatum.HashedPassword == attemptHash, nil
+	rowsAffected, err := database.queries.RehashAuthenticationData(ctx, sqlc.RehashAuthenticationDataParams{
+		HashedPassword:   hashedPassword,
+		Uuid:             authDatum.Uuid,
+		HashedPassword_2: authDatum.HashedPassword,
+	})
+	if err != nil {
+		slog.Error("Error during rehash of password", "Error", err, "UserID", authDatum.Uuid)
+		return
+	}
+	if rowsAffected == 1 {
+		slog.Info("Password rehashed with current parameters and pepper", "UserID", authDatum.Uuid)
+	}
 }
@@ -17,6 +17,8 @@ const (
 
 var (
 	databaseManager *database.DatabaseManager
+
+	testEncryptionKey = []byte("01234567890123456789012345678901")
 )
 
 func TestMain(m *testing.M) {
@@ -24,12 +26,16 @@ func TestMain(m *testing.M) {
 	databaseManager, err = database.NewDatabase(testDatabasePath)
 
 	ctx := context.Background()
-	databaseManager.RegisterNewUser(ctx, "John Smith", "Password123")
-	databaseManager.RegisterNewUser(ctx, "Jane Doe", "QWERTY321")
+	databaseManager.RegisterNewUser(ctx, "John Smith", "Password123", "")
+	databaseManager.RegisterNewUser(ctx, "Jane Doe", "QWERTY321", "")
 
 	if err != nil {
 		log.Fatalf("encountered error when opening test database %v", err)
 	}
+	err = databaseManager.SetEncryptionKey(testEncryptionKey)
+	if err != nil {
+		log.Fatalf("encountered error when setting encryption key %v", err)
+	}
 
 	m.Run()
 
@@ -42,7 +48,7 @@ func TestMain(m *testing.M) {
 
 func TestRegisterNewUser(t *testing.T) {
 	ctx := context.Background()
-	err := databaseManager.RegisterNewUser(ctx, "newUser", "Password123")
+	err := databaseManager.RegisterNewUser(ctx, "newUser", "Password123", "")
 	if err != nil {
 		t.Errorf("Error while registering new user: %v", err)
 	}
@@ -50,12 +56,12 @@ func TestRegisterNewUser(t *testing.T) {
 
 func TestAttemptRegisterExistingUser(t *testing.T) {
 	ctx := context.Background()
-	err := databaseManager.RegisterNewUser(ctx, "John Smith", "Password123")
+	err := databaseManager.RegisterNewUser(ctx, "John Smith", "Password123", "")
 	if err == nil {
 		t.Errorf("No error thrown while registering a new user with exact credentials: %v", err)
 	}
 
-	err = databaseManager.RegisterNewUser(ctx, "John Smith", "newPassword")
+	err = databaseManager.RegisterNewUser(ctx, "John Smith", "newPassword", "")
 	if err == nil {
 		t.Errorf("No error thrown while registering a new user with same username, different password: %v", err)
 	}
@@ -68,12 +74,37 @@ func TestDeleteExistingUser(t *testing.T) {
 		t.Fatalf("Error while deleting user: %v", err)
 	}
 
-	err = databaseManager.RegisterNewUser(ctx, "Jane Doe", "QWERTY321")
+	err = databaseManager.RegisterNewUser(ctx, "Jane Doe", "QWERTY321", "")
 	if err != nil {
 		t.Errorf("Error while recreating deleted user (checking user is *actually* deleted): %v", err)
 	}
 }
 
+func TestDeleteUserWithPassword(t *testing.T) {
+	ctx := context.Background()
+	databaseManager.RegisterNewUser(ctx, "deleteAccountUser", "Password123", "")
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "deleteAccountUser")
+
+	err := databaseManager.DeleteUserWithPassword(ctx, userID, "IncorrectPassword")
+	if err != database.ErrIncorrectPassword {
+		t.Errorf("Deletion with incorrect password did not return ErrIncorrectPassword: %v", err)
+	}
+
+	err = databaseManager.DeleteUserWithPassword(ctx, userID, "Password123")
+	if err != nil {
+		t.Fatalf("Error while deleting user: %v", err)
+	}
+
+	_, err = databaseManager.GetUserIDByUsername(ctx, "deleteAccountUser")
+	if err != database.ErrOnFetchUserDoesNotExist {
+		t.Errorf("Deleted user still exists: %v", err)
+	}
+	err = databaseManager.DeleteUserWithPassword(ctx, userID, "Password123")
+	if err != database.ErrOnFetchUserDoesNotExist {
+		t.Errorf("Deletion of deleted user did not return ErrOnFetchUserDoesNotExist: %v", err)
+	}
+}
+
 func TestValidateAuthenticationAttempt(t *testing.T) {
 	ctx := context.Background()
 
@@ -94,6 +125,146 @@ func TestValidateAuthenticationAttempt(t *testing.T) {
 	}
 }
 
+func TestValidateAuthenticationAttemptUnknownUser(t *testing.T) {
+	ctx := context.Background()
+
+	valid, err := databaseManager.ValidateLoginAttempt(ctx, "Unknown User", "Password123")
+	if err != database.ErrOnFetchUserDoesNotExist {
+		t.Errorf("Authentication attempt for unknown user did not return ErrOnFetchUserDoesNotExist: %v", err)
+	}
+	if valid == true {
+		t.Errorf("Authentication attempt succeeded (for unknown user)")
+	}
+
+	// The dummy hash checked for unknown users must not accept any password, including the empty password
+	valid, _ = databaseManager.ValidateLoginAttempt(ctx, "Unknown User", "")
+	if valid == true {
+		t.Errorf("Authentication attempt succeeded (for unknown user with empty password)")
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	ctx := context.Background()
+	databaseManager.RegisterNewUser(ctx, "passwordChangeUser", "Password123", "")
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "passwordChangeUser")
+
+	err := databaseManager.ChangePassword(ctx, userID, "IncorrectPassword", "NewPassword456")
+	if err != database.ErrIncorrectPassword {
+		t.Errorf("Password change with incorrect current password did not return ErrIncorrectPassword: %v", err)
+	}
+
+	err = databaseManager.ChangePassword(ctx, userID, "Password123", "NewPassword456")
+	if err != nil {
+		t.Fatalf("Error while changing password: %v", err)
+	}
+
+	valid, _ := databaseManager.ValidateLoginAttempt(ctx, "passwordChangeUser", "Password123")
+	if valid {
+		t.Errorf("Authentication attempt with old password succeeded after password change")
+	}
+	valid, _ = databaseManager.ValidateLoginAttempt(ctx, "passwordChangeUser", "NewPassword456")
+	if !valid {
+		t.Errorf("Authentication attempt with new password failed after password change")
+	}
+
+	err = databaseManager.ChangePassword(ctx, "not a user", "Password123", "NewPassword456")
+	if err != database.ErrOnFetchUserDoesNotExist {
+		t.Errorf("Password change for unknown user did not return ErrOnFetchUserDoesNotExist: %v", err)
+	}
+}
+
+func TestSetPeppers(t *testing.T) {
+	defer databaseManager.SetPeppers(0, nil)
+
+	err := databaseManager.SetPeppers(1, map[int][]byte{1: []byte("short pepper")})
+	if err != database.ErrInvalidPepper {
+		t.Errorf("Setting a short pepper did not return ErrInvalidPepper: %v", err)
+	}
+	err = databaseManager.SetPeppers(0, map[int][]byte{0: testEncryptionKey})
+	if err != database.ErrInvalidPepper {
+		t.Errorf("Setting a pepper with version 0 did not return ErrInvalidPepper: %v", err)
+	}
+	err = databaseManager.SetPeppers(2, map[int][]byte{1: testEncryptionKey})
+	if err != database.ErrNoCurrentPepper {
+		t.Errorf("Setting a current version without a pepper did not return ErrNoCurrentPepper: %v", err)
+	}
+
+	pepperDirectory := t.TempDir()
+	os.WriteFile(pepperDirectory+"/1.secret", testEncryptionKey, 0600)
+	invalidPepperFilesLists := []string{
+		"1.secret",
+		"x=" + pepperDirectory + "/1.secret",
+		"0=" + pepperDirectory + "/1.secret",
+		"1=" + pepperDirectory + "/1.secret 1=" + pepperDirectory + "/1.secret",
+		"2=" + pepperDirectory + "/2.secret",
+	}
+	for _, pepperFilesList := range invalidPepperFilesLists {
+		if err := databaseManager.LoadPeppers(pepperFilesList); err == nil {
+			t.Errorf("Loading invalid pepper files %q did not return an error", pepperFilesList)
+		}
+	}
+}
+
+func TestPepperRotation(t *testing.T) {
+	ctx := context.Background()
+	defer databaseManager.SetPeppers(0, nil)
+	defer databaseManager.DeleteUserByUsername(ctx, "pepperUser")
+	defer databaseManager.DeleteUserByUsername(ctx, "unpepperedUser")
+
+	pepperDirectory := t.TempDir()
+	os.WriteFile(pepperDirectory+"/1.secret", []byte("pepper 1 pepper 1 pepper 1 pepper 1"), 0600)
+	os.WriteFile(pepperDirectory+"/2.secret", []byte("pepper 2 pepper 2 pepper 2 pepper 2"), 0600)
+
+	databaseManager.RegisterNewUser(ctx, "unpepperedUser", "Password123", "")
+	err := databaseManager.LoadPeppers("1=" + pepperDirectory + "/1.secret")
+	if err != nil {
+		t.Fatalf("Error while loading pepper: %v", err)
+	}
+	err = databaseManager.RegisterNewUser(ctx, "pepperUser", "Password123", "")
+	if err != nil {
+		t.Fatalf("Error while registering new user with pepper: %v", err)
+	}
+
+	valid, err := databaseManager.ValidateLoginAttempt(ctx, "pepperUser", "Password123")
+	if err != nil || !valid {
+		t.Errorf("Authentication attempt with peppered hash failed: %v", err)
+	}
+	// Unpeppered hashes from before the pepper was set are still accepted
+	valid, err = databaseManager.ValidateLoginAttempt(ctx, "unpepperedUser", "Password123")
+	if err != nil || !valid {
+		t.Errorf("Authentication attempt with unpeppered hash failed: %v", err)
+	}
+
+	databaseManager.SetPeppers(0, nil)
+	_, err = databaseManager.ValidateLoginAttempt(ctx, "pepperUser", "Password123")
+	if err == nil {
+		t.Errorf("Authentication attempt with peppered hash did not fail without the pepper")
+	}
+
+	// Logging in with the previous pepper still loaded rehashes the password with the new pepper
+	err = databaseManager.LoadPeppers("2=" + pepperDirectory + "/2.secret 1=" + pepperDirectory + "/1.secret")
+	if err != nil {
+		t.Fatalf("Error while loading rotated peppers: %v", err)
+	}
+	valid, err = databaseManager.ValidateLoginAttempt(ctx, "pepperUser", "Password123")
+	if err != nil || !valid {
+		t.Fatalf("Authentication attempt after pepper rotation failed: %v", err)
+	}
+
+	err = databaseManager.LoadPeppers("2=" + pepperDirectory + "/2.secret")
+	if err != nil {
+		t.Fatalf("Error while loading only the new pepper: %v", err)
+	}
+	valid, err = databaseManager.ValidateLoginAttempt(ctx, "pepperUser", "Password123")
+	if err != nil || !valid {
+		t.Errorf("Authentication attempt after previous pepper dropped failed (password not rehashed): %v", err)
+	}
+	valid, _ = databaseManager.ValidateLoginAttempt(ctx, "pepperUser", "IncorrectPassword")
+	if valid {
+		t.Errorf("Authentication attempt with incorrect password succeeded with pepper")
+	}
+}
+
 func TestSequentialDatabaseAccess(t *testing.T) {
 	numUsers := 256
 
@@ -102,7 +273,7 @@ func TestSequentialDatabaseAccess(t *testing.T) {
 	for i := 0; i < numUsers; i += 1 {
 		username := fmt.Sprintf("User%v", i)
 
-		err := databaseManager.RegisterNewUser(ctx, username, password)
+		err := databaseManager.RegisterNewUser(ctx, username, password, "")
 		if err != nil {
 			t.Errorf("Failed to register user %v: %v", i, err)
 		}
@@ -147,7 +318,7 @@ func TestParallelDatabaseAccess(t *testing.T) {
 					return
 				case i := <-userChan:
 					username := fmt.Sprintf("user%v", i)
-					err := databaseManager.RegisterNewUser(ctx, username, "Password123")
+					err := databaseManager.RegisterNewUser(ctx, username, "Password123", "")
 					if err != nil {
 						errorChan <- err
 						workerCancel()
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database/sqlc"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Gets the email address of a user, and whether that address has been verified.
+// Returns an empty email address if the user has none.
+//
+// Fails (and returns a non-nil error) if:
+// - The user does not exist in the database (ErrOnFetchUserDoesNotExist)
+func (database *DatabaseManager) GetUserEmail(ctx context.Context, userID string) (string, bool, error) {
+	user, err := database.queries.GetUserByUUID(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, ErrOnFetchUserDoesNotExist
+		}
+		return "", false, err
+	}
+
+	return user.Email.String, user.EmailVerified, nil
+}
+
+// Given a userID and the current password of that user, set the email address of the user, which is unverified until VerifyEmail is called.
+// Setting the address the user already has changes nothing, so a verified address stays verified.
+// The password is checked in the same transaction as the update, as password resets are sent to the verified address.
+// Returns the previous email address of the user, and whether it was verified.
+//
+// Fails (and returns a non-nil error) if:
+// - The email address is not a valid address (ErrInvalidEmail)
+// - The user does not exist in the database (ErrOnFetchUserDoesNotExist)
+// - The password is incorrect (ErrIncorrectPassword)
+// - The transaction to check the password and update the email address fails
+func (database *DatabaseManager) SetUserEmail(ctx context.Context, userID string, currentPassword string, email string) (string, bool, error) {
+	normalizedEmail, err := normalizeEmail(email)
+	if err != nil {
+		return "", false, err
+	}
+
+	tx, err := database.db.Begin()
+	if err != nil {
+		return "", false, err
+	}
+	// If anything fails, an early return is triggered (before tx.Commit is called) and tx.Rollback is called
+	defer tx.Rollback()
+
+	qtx := database.queries.WithTx(tx)
+	authDatum, err := qtx.GetAuthData(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, ErrOnFetchUserDoesNotExist
+		}
+		return "", false, err
+	}
+
+	ok, err := database.checkPassword(authDatum, currentPassword)
+	if err != nil {
+		return "", false, err
+	}
+	if !ok {
+		return "", false, ErrIncorrectPassword
+	}
+
+	user, err := qtx.GetUserByUUID(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, ErrOnFetchUserDoesNotExist
+		}
+		return "", false, err
+	}
+	if user.Email.String == normalizedEmail {
+		return user.Email.String, user.EmailVerified, nil
+	}
+
+	err = qtx.UpdateUserEmail(ctx, sqlc.UpdateUserEmailParams{
+		Email: sql.NullString{String: normalizedEmail, Valid: true},
+		Uuid:  userID,
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", false, err
+	}
+	return user.Email.String, user.EmailVerified, nil
+}
+
+// Create a new email verification token, proving the user can receive mail at the given address.
+// The returned (plaintext) token is sent to the address, only the hash is stored in the database.
+//
+// Fails (and returns a non-nil error) if:
+// - The token fails to be generated
+// - The token cannot be stored in the database
+func (database *DatabaseManager) CreateEmailVerificationToken(ctx context.Context, userID string, email string, expiresAt time.Time) (string, error) {
+	verificationToken, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = database.queries.CreateEmailVerificationToken(ctx, sqlc.CreateEmailVerificationTokenParams{
+		TokenHash: hashOpaqueToken(verificationToken),
+		Uuid:      userID,
+		Email:     email,
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return verificationToken, nil
+}
+
+// Consume an email verification token, marking the email address it was sent to as verified. Returns the userID of the user.
+// The token is removed from the database, so each token can be used at most once.
+// Only verified addresses are unique, so an address is only checked against other users once it is verified.
+//
+// Fails (and returns a non-nil error) if:
+// - The token does not exist, or has already been used (ErrEmailVerificationInvalid)
+// - The token has expired (ErrEmailVerificationExpired)
+// - The email address of the user has changed since the token was sent (ErrEmailVerificationInvalid)
+// - The email address has already been verified by another user (ErrOnCreateEmailExists)
+func (database *DatabaseManager) VerifyEmail(ctx context.Context, verificationToken string) (string, error) {
+	storedToken, err := database.queries.ConsumeEmailVerificationToken(ctx, hashOpaqueToken(verificationToken))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrEmailVerificationInvalid
+		}
+		return "", err
+	}
+
+	if time.Now().Unix() >= storedToken.ExpiresAt {
+		return "", ErrEmailVerificationExpired
+	}
+
+	verifiedRows, err := database.queries.VerifyUserEmail(ctx, sqlc.VerifyUserEmailParams{
+		Uuid:  storedToken.Uuid,
+		Email: sql.NullString{String: storedToken.Email, Valid: true},
+	})
+	if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return "", ErrOnCreateEmailExists
+	}
+	if err != nil {
+		return "", err
+	}
+	if verifiedRows == 0 {
+		return "", ErrEmailVerificationInvalid
+	}
+
+	return storedToken.Uuid, nil
+}
+
+// Check an email address is a bare address (with no display name), and normalize it to lower case,
+// so the same address cannot be verified by two users with different capitalization.
+func normalizeEmail(email string) (string, error) {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", ErrInvalidEmail
+	}
+
+	return strings.ToLower(address.Address), nil
+}
@@ -0,0 +1,134 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+func TestRegisterNewUserWithEmail(t *testing.T) {
+	ctx := context.Background()
+	err := databaseManager.RegisterNewUser(ctx, "emailUser", "Password123", "Email.User@example.com")
+	if err != nil {
+		t.Fatalf("Error while registering user with email: %v", err)
+	}
+
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "emailUser")
+	email, verified, err := databaseManager.GetUserEmail(ctx, userID)
+	if err != nil {
+		t.Fatalf("Error while getting user email: %v", err)
+	}
+	if email != "email.user@example.com" || verified {
+		t.Errorf("User email is not normalized and unverified: got %v (verified %v)", email, verified)
+	}
+
+	err = databaseManager.RegisterNewUser(ctx, "invalidEmailUser", "Password123", "Email User <user@example.com>")
+	if err != database.ErrInvalidEmail {
+		t.Errorf("Registering with invalid email did not return ErrInvalidEmail: %v", err)
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	ctx := context.Background()
+	databaseManager.RegisterNewUser(ctx, "verifyEmailUser", "Password123", "verify@example.com")
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "verifyEmailUser")
+	expiresAt := time.Now().Add(time.Hour)
+
+	verificationToken, err := databaseManager.CreateEmailVerificationToken(ctx, userID, "verify@example.com", expiresAt)
+	if err != nil {
+		t.Fatalf("Error while creating email verification token: %v", err)
+	}
+	verifiedUserID, err := databaseManager.VerifyEmail(ctx, verificationToken)
+	if err != nil {
+		t.Fatalf("Error while verifying email: %v", err)
+	}
+	if verifiedUserID != userID {
+		t.Errorf("Email verified for wrong user: expected %v, got %v", userID, verifiedUserID)
+	}
+	_, verified, _ := databaseManager.GetUserEmail(ctx, userID)
+	if !verified {
+		t.Errorf("Email not verified after verification")
+	}
+
+	databaseManager.SetUserEmail(ctx, userID, "Password123", "Verify@example.com")
+	_, verified, _ = databaseManager.GetUserEmail(ctx, userID)
+	if !verified {
+		t.Errorf("Setting the same email unverified it")
+	}
+
+	_, err = databaseManager.VerifyEmail(ctx, verificationToken)
+	if err != database.ErrEmailVerificationInvalid {
+		t.Errorf("Reused email verification token did not return ErrEmailVerificationInvalid: %v", err)
+	}
+
+	// A token sent to a previous address must not verify the new address
+	verificationToken, _ = databaseManager.CreateEmailVerificationToken(ctx, userID, "verify@example.com", expiresAt)
+	previousEmail, previousEmailVerified, err := databaseManager.SetUserEmail(ctx, userID, "Password123", "changed@example.com")
+	if err != nil {
+		t.Fatalf("Error while setting user email: %v", err)
+	}
+	if previousEmail != "verify@example.com" || !previousEmailVerified {
+		t.Errorf("Setting user email returned wrong previous email: got %v (verified %v)", previousEmail, previousEmailVerified)
+	}
+	_, verified, _ = databaseManager.GetUserEmail(ctx, userID)
+	if verified {
+		t.Errorf("Changed email is verified without verification")
+	}
+	_, err = databaseManager.VerifyEmail(ctx, verificationToken)
+	if err != database.ErrEmailVerificationInvalid {
+		t.Errorf("Email verification token for previous email did not return ErrEmailVerificationInvalid: %v", err)
+	}
+
+	verificationToken, _ = databaseManager.CreateEmailVerificationToken(ctx, userID, "changed@example.com", time.Now().Add(-time.Minute))
+	_, err = databaseManager.VerifyEmail(ctx, verificationToken)
+	if err != database.ErrEmailVerificationExpired {
+		t.Errorf("Expired email verification token did not return ErrEmailVerificationExpired: %v", err)
+	}
+}
+
+func TestSetUserEmailIncorrectPassword(t *testing.T) {
+	ctx := context.Background()
+	databaseManager.RegisterNewUser(ctx, "setEmailUser", "Password123", "set.email@example.com")
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "setEmailUser")
+
+	_, _, err := databaseManager.SetUserEmail(ctx, userID, "WrongPassword", "attacker@example.com")
+	if err != database.ErrIncorrectPassword {
+		t.Errorf("Setting email with incorrect password did not return ErrIncorrectPassword: %v", err)
+	}
+
+	email, _, _ := databaseManager.GetUserEmail(ctx, userID)
+	if email != "set.email@example.com" {
+		t.Errorf("Email changed with incorrect password: got %v", email)
+	}
+}
+
+func TestVerifiedEmailUnique(t *testing.T) {
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+
+	// Unverified addresses are not unique, so registering with the address of another user succeeds
+	databaseManager.RegisterNewUser(ctx, "uniqueEmailUser", "Password123", "unique@example.com")
+	err := databaseManager.RegisterNewUser(ctx, "otherUniqueEmailUser", "Password123", "Unique@example.com")
+	if err != nil {
+		t.Fatalf("Error while registering user with unverified email of another user: %v", err)
+	}
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "uniqueEmailUser")
+	otherUserID, _ := databaseManager.GetUserIDByUsername(ctx, "otherUniqueEmailUser")
+
+	verificationToken, _ := databaseManager.CreateEmailVerificationToken(ctx, userID, "unique@example.com", expiresAt)
+	otherVerificationToken, _ := databaseManager.CreateEmailVerificationToken(ctx, otherUserID, "unique@example.com", expiresAt)
+	_, err = databaseManager.VerifyEmail(ctx, verificationToken)
+	if err != nil {
+		t.Fatalf("Error while verifying email: %v", err)
+	}
+	_, err = databaseManager.VerifyEmail(ctx, otherVerificationToken)
+	if err != database.ErrOnCreateEmailExists {
+		t.Errorf("Verifying email verified by another user did not return ErrOnCreateEmailExists: %v", err)
+	}
+	_, verified, _ := databaseManager.GetUserEmail(ctx, otherUserID)
+	if verified {
+		t.Errorf("Email verified for two users")
+	}
+}
@@ -5,4 +5,39 @@ import "errors"
 var (
 	ErrOnCreateUserExists      error = errors.New("user exists in database")
 	ErrOnFetchUserDoesNotExist error = errors.New("user does not exist in database")
+	ErrIncorrectPassword       error = errors.New("password is incorrect")
+	ErrRefreshTokenInvalid     error = errors.New("refresh token is invalid")
+	ErrRefreshTokenExpired     error = errors.New("refresh token is expired")
+	ErrRefreshTokenReused      error = errors.New("refresh token has already been used")
+	ErrSessionInvalid          error = errors.New("session is invalid")
+	ErrSessionExpired          error = errors.New("session is expired")
+	ErrInvalidRoleOrGroupName  error = errors.New("role or group name must not be empty or contain whitespace")
+	ErrOnCreateEmailExists     error = errors.New("email exists in database")
+	ErrInvalidEmail            error = errors.New("email must be a valid email address")
+	ErrNoEncryptionKey         error = errors.New("encryption key is not set")
+	ErrInvalidEncryptionKey    error = errors.New("encryption key must be 32 bytes")
+	ErrTOTPNotEnrolled         error = errors.New("user has not enrolled in TOTP")
+	ErrTOTPAlreadyEnabled      error = errors.New("TOTP is already enabled")
+	ErrIncorrectTOTPCode       error = errors.New("TOTP code is incorrect")
+	ErrMFAChallengeInvalid     error = errors.New("MFA challenge is invalid")
+	ErrMFAChallengeExpired     error = errors.New("MFA challenge is expired")
+	ErrIncorrectRecoveryCode   error = errors.New("recovery code is incorrect")
+	ErrInvalidPepper           error = errors.New("pepper must be at least 32 bytes, with a positive version")
+	ErrNoCurrentPepper         error = errors.New("current pepper version has no pepper")
+	ErrLoginThrottled          error = errors.New("logins are throttled")
+
+	ErrOnCreateClientExists      error = errors.New("client exists in database")
+	ErrOnFetchClientDoesNotExist error = errors.New("client does not exist in database")
+	ErrInvalidRedirectURI        error = errors.New("redirect URI must be an absolute URI without a fragment")
+	ErrInvalidTokenLifetime      error = errors.New("token lifetime must not be negative")
+	ErrAuthorizationCodeInvalid  error = errors.New("authorization code is invalid")
+	ErrAuthorizationCodeExpired  error = errors.New("authorization code is expired")
+	ErrPasswordResetTokenInvalid error = errors.New("password reset token is invalid")
+	ErrPasswordResetTokenExpired error = errors.New("password reset token is expired")
+	ErrEmailVerificationInvalid  error = errors.New("email verification token is invalid")
+	ErrEmailVerificationExpired  error = errors.New("email verification token is expired")
+	ErrOnCreateCredentialExists  error = errors.New("WebAuthn credential exists in database")
+	ErrCredentialDoesNotExist    error = errors.New("WebAuthn credential does not exist in database")
+	ErrWebAuthnSessionInvalid    error = errors.New("WebAuthn session is invalid")
+	ErrWebAuthnSessionExpired    error = errors.New("WebAuthn session is expired")
 )
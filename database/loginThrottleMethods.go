@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database/sqlc"
+)
+
+// A policy for throttling failed login attempts against one throttle key (such as a username, or a client IP).
+//
+// The first FreeAttempts failures are not delayed. Each failure after that delays the next attempt by BaseDelay,
+// doubling with each further failure up to MaxDelay. Once LockoutThreshold failures have been made,
+// the key is locked out for LockoutDuration. Failures are forgotten after FailureWindow without another failure.
+type ThrottlePolicy struct {
+	FreeAttempts     int64
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	LockoutThreshold int64
+	LockoutDuration  time.Duration
+	FailureWindow    time.Duration
+}
+
+// The delay before another attempt is allowed, after the given number of consecutive failures.
+func (policy ThrottlePolicy) Delay(failures int64) time.Duration {
+	if failures >= policy.LockoutThreshold {
+		return policy.LockoutDuration
+	}
+	if failures <= policy.FreeAttempts {
+		return 0
+	}
+
+	delay := policy.BaseDelay
+	for doublings := failures - policy.FreeAttempts - 1; doublings > 0 && delay < policy.MaxDelay; doublings-- {
+		delay *= 2
+	}
+	return min(delay, policy.MaxDelay)
+}
+
+// The throttle key for failed logins with a username. The username need not exist, so unknown usernames are throttled alike.
+func UsernameThrottleKey(username string) string {
+	return "username:" + username
+}
+
+// The throttle key for failed logins from a client IP address.
+func IPThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Checks if logins with a throttle key are currently delayed or locked out.
+// Returns how long until another attempt is allowed, or zero if an attempt is allowed now.
+func (database *DatabaseManager) CheckLoginThrottle(ctx context.Context, throttleKey string) (time.Duration, error) {
+	throttle, err := database.queries.GetLoginThrottle(ctx, throttleKey)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	retryAfter := time.Unix(throttle.LockedUntil, 0).Sub(time.Now())
+	return max(retryAfter, 0), nil
+}
+
+// Record a login attempt against a throttle key as a failure, returning how long until another attempt is allowed under the policy.
+// The attempt is counted before the password is checked (and forgotten if the login succeeds, see ForgetLoginFailure),
+// so concurrent attempts are each counted before any of them are checked.
+//
+// Fails (and returns a non-nil error) if:
+// - Logins with the throttle key are delayed or locked out, such as by a concurrent attempt (ErrLoginThrottled).
+// The returned duration is then how long until another attempt is allowed
+// - The transaction to count the failure fails
+func (database *DatabaseManager) RecordLoginFailure(ctx context.Context, throttleKey string, policy ThrottlePolicy) (time.Duration, error) {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	// If anything fails, an early return is triggered (before tx.Commit is called) and tx.Rollback is called
+	defer tx.Rollback()
+
+	qtx := database.queries.WithTx(tx)
+	currentTime := time.Now()
+
+	// The failure is counted in one statement, so concurrent failures cannot read the same count.
+	// Failures from before the throttle expired are forgotten, and nothing is counted while the key is delayed or locked out
+	throttle, err := qtx.CountLoginFailure(ctx, sqlc.CountLoginFailureParams{
+		ThrottleKey: throttleKey,
+		ExpiresAt:   currentTime.Add(policy.FailureWindow).Unix(),
+		ExpiresAt_2: currentTime.Unix(),
+		LockedUntil: currentTime.Unix(),
+	})
+	if err == sql.ErrNoRows {
+		throttle, err = qtx.GetLoginThrottle(ctx, throttleKey)
+		if err != nil {
+			return 0, err
+		}
+		return max(time.Unix(throttle.LockedUntil, 0).Sub(currentTime), 0), ErrLoginThrottled
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	delay := policy.Delay(throttle.Failures)
+	err = qtx.SetLoginThrottleLock(ctx, sqlc.SetLoginThrottleLockParams{
+		LockedUntil: currentTime.Add(delay).Unix(),
+		ExpiresAt:   currentTime.Add(max(delay, policy.FailureWindow)).Unix(),
+		ThrottleKey: throttleKey,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return delay, tx.Commit()
+}
+
+// Forget one login attempt recorded against a throttle key by RecordLoginFailure, once the attempt has succeeded.
+// Unlike ResetLoginThrottle, earlier failures are kept.
+func (database *DatabaseManager) ForgetLoginFailure(ctx context.Context, throttleKey string) error {
+	return database.queries.ForgetLoginFailure(ctx, throttleKey)
+}
+
+// Forget the failed logins against a throttle key, such as after a successful login, or to unlock a locked out account.
+// Returns true if there were any failures to forget.
+func (database *DatabaseManager) ResetLoginThrottle(ctx context.Context, throttleKey string) (bool, error) {
+	rowsAffected, err := database.queries.DeleteLoginThrottle(ctx, throttleKey)
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
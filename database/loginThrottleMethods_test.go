@@ -0,0 +1,148 @@
+package database_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+func TestThrottlePolicyDelay(t *testing.T) {
+	policy := database.ThrottlePolicy{
+		FreeAttempts:     2,
+		BaseDelay:        time.Second,
+		MaxDelay:         5 * time.Second,
+		LockoutThreshold: 8,
+		LockoutDuration:  time.Hour,
+	}
+	expectedDelays := []time.Duration{0, 0, 0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second, time.Hour, time.Hour}
+
+	for failures, expectedDelay := range expectedDelays {
+		delay := policy.Delay(int64(failures))
+		if delay != expectedDelay {
+			t.Errorf("Delay after %v failures is %v, expected %v", failures, delay, expectedDelay)
+		}
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	ctx := context.Background()
+	throttleKey := database.UsernameThrottleKey("throttledUser")
+	policy := database.ThrottlePolicy{
+		FreeAttempts:     2,
+		BaseDelay:        time.Minute,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 3,
+		LockoutDuration:  time.Hour,
+		FailureWindow:    time.Hour,
+	}
+
+	delay, err := databaseManager.RecordLoginFailure(ctx, throttleKey, policy)
+	if err != nil || delay != 0 {
+		t.Fatalf("First failure delayed by %v: %v", delay, err)
+	}
+	retryAfter, _ := databaseManager.CheckLoginThrottle(ctx, throttleKey)
+	if retryAfter != 0 {
+		t.Errorf("Login throttled after free failure, retry after %v", retryAfter)
+	}
+
+	// A successful attempt is forgotten, so it does not use up a free failure
+	delay, _ = databaseManager.RecordLoginFailure(ctx, throttleKey, policy)
+	if delay != 0 {
+		t.Errorf("Second failure delayed by %v, expected no delay", delay)
+	}
+	err = databaseManager.ForgetLoginFailure(ctx, throttleKey)
+	if err != nil {
+		t.Fatalf("Error while forgetting login failure: %v", err)
+	}
+	delay, _ = databaseManager.RecordLoginFailure(ctx, throttleKey, policy)
+	if delay != 0 {
+		t.Errorf("Failure after forgotten failure delayed by %v, expected no delay", delay)
+	}
+
+	delay, _ = databaseManager.RecordLoginFailure(ctx, throttleKey, policy)
+	if delay != time.Hour {
+		t.Errorf("Third failure delayed by %v, expected lockout of %v", delay, time.Hour)
+	}
+	retryAfter, _ = databaseManager.CheckLoginThrottle(ctx, throttleKey)
+	if retryAfter <= time.Minute || retryAfter > time.Hour {
+		t.Errorf("Login throttle retry after %v, expected up to %v", retryAfter, time.Hour)
+	}
+
+	// Attempts while locked out (such as concurrent attempts that passed CheckLoginThrottle) are refused, and not counted
+	delay, err = databaseManager.RecordLoginFailure(ctx, throttleKey, policy)
+	if err != database.ErrLoginThrottled || delay <= time.Minute || delay > time.Hour {
+		t.Errorf("Attempt while locked out returned delay %v: %v", delay, err)
+	}
+
+	unlocked, err := databaseManager.ResetLoginThrottle(ctx, throttleKey)
+	if err != nil || !unlocked {
+		t.Errorf("Locked out throttle key not unlocked: %v", err)
+	}
+	retryAfter, _ = databaseManager.CheckLoginThrottle(ctx, throttleKey)
+	if retryAfter != 0 {
+		t.Errorf("Login throttled after unlock, retry after %v", retryAfter)
+	}
+	unlocked, _ = databaseManager.ResetLoginThrottle(ctx, throttleKey)
+	if unlocked {
+		t.Errorf("Throttle key without failures reported as unlocked")
+	}
+}
+
+func TestLoginThrottleFailureWindow(t *testing.T) {
+	ctx := context.Background()
+	throttleKey := database.IPThrottleKey("192.0.2.1")
+	policy := database.ThrottlePolicy{
+		FreeAttempts:     1,
+		BaseDelay:        time.Minute,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 10,
+		LockoutDuration:  time.Hour,
+		FailureWindow:    -time.Minute,
+	}
+
+	// With a failure window in the past, each failure is forgotten before the next, so none are delayed
+	for i := 0; i < 3; i++ {
+		delay, _ := databaseManager.RecordLoginFailure(ctx, throttleKey, policy)
+		if delay != 0 {
+			t.Errorf("Failure %v delayed by %v, expected earlier failures to be forgotten", i, delay)
+		}
+	}
+}
+
+func TestLoginThrottleConcurrentFailures(t *testing.T) {
+	ctx := context.Background()
+	throttleKey := database.UsernameThrottleKey("concurrentlyThrottledUser")
+	policy := database.ThrottlePolicy{
+		FreeAttempts:     100,
+		BaseDelay:        time.Minute,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 200,
+		LockoutDuration:  time.Hour,
+		FailureWindow:    time.Hour,
+	}
+	databaseManager.ResetLoginThrottle(ctx, throttleKey)
+
+	const numFailures = 10
+	var wg sync.WaitGroup
+	for i := 0; i < numFailures; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := databaseManager.RecordLoginFailure(ctx, throttleKey, policy)
+			if err != nil {
+				t.Errorf("Error while recording concurrent login failure: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every failure was counted, so one more reaches the delay
+	policy.FreeAttempts = numFailures
+	delay, _ := databaseManager.RecordLoginFailure(ctx, throttleKey, policy)
+	if delay != time.Minute {
+		t.Errorf("Failure after %v concurrent failures delayed by %v, expected %v", numFailures, delay, time.Minute)
+	}
+}
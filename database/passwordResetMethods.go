@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database/sqlc"
+)
+
+// Create a new password reset token for a user. The returned (plaintext) token is sent to the user, only the hash is stored in the database.
+//
+// Fails (and returns a non-nil error) if:
+// - The token fails to be generated
+// - The token cannot be stored in the database
+func (database *DatabaseManager) CreatePasswordResetToken(ctx context.Context, userID string, expiresAt time.Time) (string, error) {
+	resetToken, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = database.queries.CreatePasswordResetToken(ctx, sqlc.CreatePasswordResetTokenParams{
+		TokenHash: hashOpaqueToken(resetToken),
+		Uuid:      userID,
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return resetToken, nil
+}
+
+// Get the userID of the user a password reset token was created for, without consuming the token.
+// Used to check the new password against the password policy (which depends on the user) before the token is consumed.
+//
+// Fails (and returns a non-nil error) if:
+// - The token does not exist, or has already been used (ErrPasswordResetTokenInvalid)
+// - The token has expired (ErrPasswordResetTokenExpired)
+func (database *DatabaseManager) GetPasswordResetTokenUser(ctx context.Context, resetToken string) (string, error) {
+	storedToken, err := database.queries.GetPasswordResetToken(ctx, hashOpaqueToken(resetToken))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrPasswordResetTokenInvalid
+		}
+		return "", err
+	}
+
+	if time.Now().Unix() >= storedToken.ExpiresAt {
+		return "", ErrPasswordResetTokenExpired
+	}
+	return storedToken.Uuid, nil
+}
+
+// Consume a password reset token, setting the password of the user it was created for. Returns the userID of that user.
+//
+// The token is removed from the database, so each token can be used at most once (even if two requests race).
+// Every other reset token, refresh token, and session of the user is also removed, so anyone who knew the old password is logged out.
+//
+// Fails (and returns a non-nil error) if:
+// - The token does not exist, or has already been used (ErrPasswordResetTokenInvalid)
+// - The token has expired (ErrPasswordResetTokenExpired)
+// - The salt fails to be generated
+// - The transaction to update the auth data and revoke the sessions fails
+func (database *DatabaseManager) ResetPassword(ctx context.Context, resetToken string, newPassword string) (string, error) {
+	hashedPassword, err := hashPassword(newPassword, database.peppers)
+	if err != nil {
+		return "", err
+	}
+
+	// Begin database transaction so the password is only changed if the sessions are also revoked
+	tx, err := database.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	// If anything fails, an early return is triggered (before tx.Commit is called) and tx.Rollback is called
+	defer tx.Rollback()
+
+	qtx := database.queries.WithTx(tx)
+	storedToken, err := qtx.ConsumePasswordResetToken(ctx, hashOpaqueToken(resetToken))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrPasswordResetTokenInvalid
+		}
+		return "", err
+	}
+
+	if time.Now().Unix() >= storedToken.ExpiresAt {
+		return "", ErrPasswordResetTokenExpired
+	}
+
+	userID := storedToken.Uuid
+	err = qtx.UpdateAuthenticationData(ctx, sqlc.UpdateAuthenticationDataParams{
+		HashedPassword: hashedPassword,
+		Uuid:           userID,
+	})
+	if err != nil {
+		return "", err
+	}
+	err = qtx.DeletePasswordResetTokensByUser(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	err = qtx.DeleteRefreshTokensByUser(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	err = qtx.DeleteSessionsByUser(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	return userID, tx.Commit()
+}
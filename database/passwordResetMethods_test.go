@@ -0,0 +1,78 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+func TestResetPassword(t *testing.T) {
+	ctx := context.Background()
+	databaseManager.RegisterNewUser(ctx, "passwordResetUser", "Password123", "")
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "passwordResetUser")
+	expiresAt := time.Now().Add(time.Hour)
+
+	resetToken, err := databaseManager.CreatePasswordResetToken(ctx, userID, expiresAt)
+	if err != nil {
+		t.Fatalf("Error while creating password reset token: %v", err)
+	}
+	otherResetToken, _ := databaseManager.CreatePasswordResetToken(ctx, userID, expiresAt)
+	_, refreshToken, _ := databaseManager.CreateRefreshToken(ctx, database.Grant{UserID: userID}, expiresAt)
+
+	tokenUserID, err := databaseManager.GetPasswordResetTokenUser(ctx, resetToken)
+	if err != nil || tokenUserID != userID {
+		t.Errorf("Password reset token user is not the user it was created for: %v", err)
+	}
+
+	resetUserID, err := databaseManager.ResetPassword(ctx, resetToken, "NewPassword456")
+	if err != nil {
+		t.Fatalf("Error while resetting password: %v", err)
+	}
+	if resetUserID != userID {
+		t.Errorf("Password reset for wrong user: expected %v, got %v", userID, resetUserID)
+	}
+
+	valid, _ := databaseManager.ValidateLoginAttempt(ctx, "passwordResetUser", "NewPassword456")
+	if !valid {
+		t.Errorf("Authentication attempt with new password failed after password reset")
+	}
+
+	_, err = databaseManager.GetPasswordResetTokenUser(ctx, resetToken)
+	if err != database.ErrPasswordResetTokenInvalid {
+		t.Errorf("User of used password reset token did not return ErrPasswordResetTokenInvalid: %v", err)
+	}
+	_, err = databaseManager.ResetPassword(ctx, resetToken, "OtherPassword789")
+	if err != database.ErrPasswordResetTokenInvalid {
+		t.Errorf("Reused password reset token did not return ErrPasswordResetTokenInvalid: %v", err)
+	}
+	_, err = databaseManager.ResetPassword(ctx, otherResetToken, "OtherPassword789")
+	if err != database.ErrPasswordResetTokenInvalid {
+		t.Errorf("Other password reset token of user did not return ErrPasswordResetTokenInvalid: %v", err)
+	}
+	_, _, err = databaseManager.RotateRefreshToken(ctx, refreshToken, "", expiresAt)
+	if err != database.ErrRefreshTokenInvalid {
+		t.Errorf("Refresh token issued before password reset did not return ErrRefreshTokenInvalid: %v", err)
+	}
+}
+
+func TestResetPasswordExpiredToken(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "John Smith")
+
+	resetToken, _ := databaseManager.CreatePasswordResetToken(ctx, userID, time.Now().Add(-time.Minute))
+	_, err := databaseManager.GetPasswordResetTokenUser(ctx, resetToken)
+	if err != database.ErrPasswordResetTokenExpired {
+		t.Errorf("User of expired password reset token did not return ErrPasswordResetTokenExpired: %v", err)
+	}
+	_, err = databaseManager.ResetPassword(ctx, resetToken, "NewPassword456")
+	if err != database.ErrPasswordResetTokenExpired {
+		t.Errorf("Expired password reset token did not return ErrPasswordResetTokenExpired: %v", err)
+	}
+
+	valid, _ := databaseManager.ValidateLoginAttempt(ctx, "John Smith", "Password123")
+	if !valid {
+		t.Errorf("Password changed by expired password reset token")
+	}
+}
@@ -3,12 +3,85 @@
 
 -- name: CreateAuthenticationData :one
 INSERT INTO authenticationData(uuid, hashed_password, salt)
-VALUES(?, ?, ?)
+VALUES(?, ?, '')
+RETURNING *;
+
+-- name: CreateAuthorizationCode :exec
+INSERT INTO authorizationCodes (code_hash, client_id, uuid, redirect_uri, scope, nonce, code_challenge, expires_at)
+VALUES(?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: CreateClient :one
+INSERT INTO clients (client_id, client_secret_hash, redirect_uris, audience, token_lifetime)
+VALUES(?, ?, ?, ?, ?)
 RETURNING *;
 
 -- name: CreateUser :one
-INSERT INTO users (uuid, username)
+INSERT INTO users (uuid, username, email)
+VALUES(?, ?, ?)
+RETURNING *;
+
+-- name: CreateUserGroup :exec
+INSERT INTO userGroups (uuid, group_name)
+VALUES(?, ?)
+ON CONFLICT (uuid, group_name) DO NOTHING;
+
+-- name: CreateUserRole :exec
+INSERT INTO userRoles (uuid, role)
+VALUES(?, ?)
+ON CONFLICT (uuid, role) DO NOTHING;
+
+-- name: CreateEmailVerificationToken :exec
+INSERT INTO emailVerificationTokens (token_hash, uuid, email, expires_at)
+VALUES(?, ?, ?, ?);
+
+-- name: CreatePasswordResetToken :exec
+INSERT INTO passwordResetTokens (token_hash, uuid, expires_at)
+VALUES(?, ?, ?);
+
+-- name: CreateRefreshToken :one
+INSERT INTO refreshTokens (token_hash, family_id, uuid, expires_at, client_id, scope)
+VALUES(?, ?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: CreateSession :exec
+INSERT INTO sessions (session_hash, uuid, created_at, last_seen_at, expires_at, client_id, scope, family_id)
+VALUES(?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: CreateRevokedToken :exec
+INSERT INTO revokedTokens (token_id, expires_at)
 VALUES(?, ?)
+ON CONFLICT (token_id) DO NOTHING;
+
+-- name: CreateTOTPSecret :execrows
+INSERT INTO totpSecrets (uuid, encrypted_secret)
+VALUES(?, ?)
+ON CONFLICT (uuid) DO UPDATE
+SET encrypted_secret = excluded.encrypted_secret, last_used_step = 0
+WHERE totpSecrets.enabled = FALSE;
+
+-- name: CreateMFAChallenge :exec
+INSERT INTO mfaChallenges (challenge_hash, uuid, expires_at)
+VALUES(?, ?, ?);
+
+-- name: CreateWebAuthnCredential :exec
+INSERT INTO webauthnCredentials (credential_id, uuid, name, credential, created_at)
+VALUES(?, ?, ?, ?, ?);
+
+-- name: CreateWebAuthnSession :exec
+INSERT INTO webauthnSessions (session_hash, uuid, ceremony, session_data, expires_at)
+VALUES(?, ?, ?, ?, ?);
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recoveryCodes (uuid, code_index, hashed_code)
+VALUES(?, ?, ?);
+
+-- name: CountLoginFailure :one
+INSERT INTO loginThrottles (throttle_key, failures, locked_until, expires_at)
+VALUES(?, 1, 0, ?)
+ON CONFLICT (throttle_key) DO UPDATE
+SET failures = CASE WHEN loginThrottles.expires_at > ? THEN loginThrottles.failures + 1 ELSE 1 END,
+    expires_at = MAX(loginThrottles.expires_at, excluded.expires_at)
+WHERE loginThrottles.locked_until <= ?
 RETURNING *;
 
 -------------------------------------------------------------------------------
@@ -19,6 +92,10 @@ SELECT * FROM authenticationData
 WHERE uuid = ? 
 LIMIT 1;
 
+-- name: GetClient :one
+SELECT * FROM clients
+WHERE client_id = ? LIMIT 1;
+
 -- name: GetUserByUUID :one
 SELECT * FROM users
 WHERE uuid = ? LIMIT 1;
@@ -27,14 +104,129 @@ WHERE uuid = ? LIMIT 1;
 SELECT * FROM users
 WHERE username = ? LIMIT 1;
 
+-- name: GetUserGroups :many
+SELECT group_name FROM userGroups
+WHERE uuid = ?
+ORDER BY group_name;
+
+-- name: GetUserRoles :many
+SELECT role FROM userRoles
+WHERE uuid = ?
+ORDER BY role;
+
+-- name: GetPasswordResetToken :one
+SELECT * FROM passwordResetTokens
+WHERE token_hash = ? LIMIT 1;
+
+-- name: GetRefreshToken :one
+SELECT * FROM refreshTokens
+WHERE token_hash = ? LIMIT 1;
+
+-- name: GetSession :one
+SELECT * FROM sessions
+WHERE session_hash = ? LIMIT 1;
+
+-- name: GetTOTPSecret :one
+SELECT * FROM totpSecrets
+WHERE uuid = ? LIMIT 1;
+
+-- name: GetMFAChallenge :one
+SELECT * FROM mfaChallenges
+WHERE challenge_hash = ? LIMIT 1;
+
+-- name: GetWebAuthnCredentialsByUser :many
+SELECT * FROM webauthnCredentials
+WHERE uuid = ?
+ORDER BY created_at;
+
+-- name: GetRecoveryCodesByUser :many
+SELECT * FROM recoveryCodes
+WHERE uuid = ?
+ORDER BY code_index;
+
+-- name: CountRecoveryCodes :one
+SELECT COUNT(*) FROM recoveryCodes
+WHERE uuid = ?;
+
+-- name: GetLoginThrottle :one
+SELECT * FROM loginThrottles
+WHERE throttle_key = ?;
+
+-- name: IsTokenRevoked :one
+SELECT EXISTS(
+    SELECT 1 FROM revokedTokens
+    WHERE token_id = ?
+);
+
 -------------------------------------------------------------------------------
 -- UPDATE QUERIES
 
 -- name: UpdateAuthenticationData :exec
 UPDATE authenticationData
-SET hashed_password = ?, salt = ?
+SET hashed_password = ?, salt = ''
 WHERE uuid = ?;
 
+-- name: RehashAuthenticationData :execrows
+UPDATE authenticationData
+SET hashed_password = ?, salt = ''
+WHERE uuid = ? AND hashed_password = ?;
+
+-- name: UpdateUserEmail :exec
+UPDATE users
+SET email = ?, email_verified = FALSE
+WHERE uuid = ?;
+
+-- name: VerifyUserEmail :execrows
+UPDATE users
+SET email_verified = TRUE
+WHERE uuid = ? AND email = ?;
+
+-- name: MarkRefreshTokenUsed :execrows
+UPDATE refreshTokens
+SET used = TRUE
+WHERE token_hash = ? AND used = FALSE;
+
+-- name: UpdateSessionLastSeen :exec
+UPDATE sessions
+SET last_seen_at = ?
+WHERE session_hash = ?;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refreshTokens
+SET revoked = TRUE
+WHERE family_id = ?;
+
+-- name: EnableTOTPSecret :execrows
+UPDATE totpSecrets
+SET enabled = TRUE, last_used_step = ?
+WHERE uuid = ? AND enabled = FALSE;
+
+-- name: UpdateTOTPLastUsedStep :execrows
+UPDATE totpSecrets
+SET last_used_step = ?
+WHERE uuid = ? AND enabled = TRUE AND last_used_step < ?;
+
+-- name: UseMFAChallengeAttempt :one
+UPDATE mfaChallenges
+SET attempts = attempts + 1
+WHERE challenge_hash = ? AND attempts < ?
+RETURNING *;
+
+-- name: UpdateWebAuthnCredential :execrows
+UPDATE webauthnCredentials
+SET credential = ?, last_used_at = ?
+WHERE credential_id = ? AND uuid = ?;
+
+-- name: SetLoginThrottleLock :exec
+UPDATE loginThrottles
+SET locked_until = MAX(locked_until, ?), expires_at = MAX(expires_at, ?)
+WHERE throttle_key = ?;
+
+-- name: ForgetLoginFailure :exec
+UPDATE loginThrottles
+SET failures = failures - 1
+WHERE throttle_key = ? AND failures > 0;
+
 -------------------------------------------------------------------------------
 -- DELETE QUERIES
 
@@ -42,6 +234,142 @@ WHERE uuid = ?;
 DELETE FROM authenticationData
 WHERE uuid = ?;
 
+-- name: ConsumeAuthorizationCode :one
+DELETE FROM authorizationCodes
+WHERE code_hash = ?
+RETURNING *;
+
+-- name: ConsumeEmailVerificationToken :one
+DELETE FROM emailVerificationTokens
+WHERE token_hash = ?
+RETURNING *;
+
+-- name: ConsumePasswordResetToken :one
+DELETE FROM passwordResetTokens
+WHERE token_hash = ?
+RETURNING *;
+
 -- name: DeleteUser :exec
 DELETE FROM users
 WHERE uuid = ?;
+
+-- name: DeleteUserGroup :exec
+DELETE FROM userGroups
+WHERE uuid = ? AND group_name = ?;
+
+-- name: DeleteUserRole :exec
+DELETE FROM userRoles
+WHERE uuid = ? AND role = ?;
+
+-- name: DeleteUserGroupsByUser :exec
+DELETE FROM userGroups
+WHERE uuid = ?;
+
+-- name: DeleteUserRolesByUser :exec
+DELETE FROM userRoles
+WHERE uuid = ?;
+
+-- name: DeleteRefreshTokensByUser :exec
+DELETE FROM refreshTokens
+WHERE uuid = ?;
+
+-- name: DeleteSession :exec
+DELETE FROM sessions
+WHERE session_hash = ?;
+
+-- name: DeleteSessionsByUser :exec
+DELETE FROM sessions
+WHERE uuid = ?;
+
+-- name: DeleteSessionsByFamily :exec
+DELETE FROM sessions
+WHERE family_id = ?;
+
+-- name: DeleteAuthorizationCodesByUser :exec
+DELETE FROM authorizationCodes
+WHERE uuid = ?;
+
+-- name: DeleteEmailVerificationTokensByUser :exec
+DELETE FROM emailVerificationTokens
+WHERE uuid = ?;
+
+-- name: DeletePasswordResetTokensByUser :exec
+DELETE FROM passwordResetTokens
+WHERE uuid = ?;
+
+-- name: DeleteTOTPSecret :execrows
+DELETE FROM totpSecrets
+WHERE uuid = ?;
+
+-- name: DeleteMFAChallenge :execrows
+DELETE FROM mfaChallenges
+WHERE challenge_hash = ? AND uuid = ?;
+
+-- name: DeleteMFAChallengesByUser :exec
+DELETE FROM mfaChallenges
+WHERE uuid = ?;
+
+-- name: DeleteWebAuthnCredential :execrows
+DELETE FROM webauthnCredentials
+WHERE credential_id = ? AND uuid = ?;
+
+-- name: DeleteWebAuthnCredentialsByUser :exec
+DELETE FROM webauthnCredentials
+WHERE uuid = ?;
+
+-- name: DeleteWebAuthnSessionsByUser :exec
+DELETE FROM webauthnSessions
+WHERE uuid = ?;
+
+-- name: ConsumeWebAuthnSession :one
+DELETE FROM webauthnSessions
+WHERE session_hash = ?
+RETURNING *;
+
+-- name: DeleteRecoveryCode :execrows
+DELETE FROM recoveryCodes
+WHERE uuid = ? AND code_index = ?;
+
+-- name: DeleteRecoveryCodesByUser :exec
+DELETE FROM recoveryCodes
+WHERE uuid = ?;
+
+-- name: DeleteLoginThrottle :execrows
+DELETE FROM loginThrottles
+WHERE throttle_key = ?;
+
+-- name: DeleteExpiredAuthorizationCodes :exec
+DELETE FROM authorizationCodes
+WHERE expires_at < ?;
+
+-- name: DeleteExpiredEmailVerificationTokens :exec
+DELETE FROM emailVerificationTokens
+WHERE expires_at < ?;
+
+-- name: DeleteExpiredMFAChallenges :exec
+DELETE FROM mfaChallenges
+WHERE expires_at < ?;
+
+-- name: DeleteExpiredWebAuthnSessions :exec
+DELETE FROM webauthnSessions
+WHERE expires_at < ?;
+
+-- name: DeleteExpiredPasswordResetTokens :exec
+DELETE FROM passwordResetTokens
+WHERE expires_at < ?;
+
+-- name: DeleteExpiredRefreshTokens :exec
+DELETE FROM refreshTokens
+WHERE expires_at < ?;
+
+-- name: DeleteExpiredSessions :exec
+DELETE FROM sessions
+WHERE expires_at < ?;
+
+-- name: DeleteExpiredRevokedTokens :exec
+DELETE FROM revokedTokens
+WHERE expires_at < ?;
+
+-- name: DeleteExpiredLoginThrottles :exec
+DELETE FROM loginThrottles
+WHERE expires_at < ?;
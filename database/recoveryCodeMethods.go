@@ -0,0 +1,222 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"io"
+	"strings"
+
+	"github.com/hmcalister/AuthSSO/database/sqlc"
+)
+
+const (
+	// Number of recovery codes in each set given to a user.
+	recoveryCodeCount = 10
+
+	// Number of random bytes in each recovery code (48 bits), encoded as 10 base32 characters.
+	recoveryCodeLen = 6
+)
+
+// Recovery codes are lowercase base32, without padding, split in two halves so they are easier to copy by hand.
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// Create the first set of recovery codes for a user who has just enrolled a second factor, returning the (plaintext) codes
+// so they can be shown to the user. Only the hashes (calculated as for passwords) are stored in the database.
+//
+// If the user already has recovery codes (such as from enrolling another second factor) they are kept, and nil is returned.
+//
+// Fails (and returns a non-nil error) if:
+// - The codes fail to be generated
+// - The transaction to check for and store the codes fails
+func (database *DatabaseManager) CreateInitialRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	codes, storedCodes, err := generateRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := database.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// If anything fails, an early return is triggered (before tx.Commit is called) and tx.Rollback is called
+	defer tx.Rollback()
+
+	qtx := database.queries.WithTx(tx)
+	count, err := qtx.CountRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, nil
+	}
+
+	err = storeRecoveryCodes(ctx, qtx, storedCodes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// Given a userID and the password of that user, replace the recovery codes of the user with a new set,
+// returning the (plaintext) codes so they can be shown to the user. Any codes from the previous set can no longer be used.
+// The password is checked in the same transaction as the replacement, so the password cannot be changed in between.
+//
+// Fails (and returns a non-nil error) if:
+// - The user does not exist in the database (ErrOnFetchUserDoesNotExist)
+// - The password is incorrect (ErrIncorrectPassword)
+// - The codes fail to be generated
+// - The transaction to check the password and replace the codes fails
+func (database *DatabaseManager) RegenerateRecoveryCodes(ctx context.Context, userID string, password string) ([]string, error) {
+	codes, storedCodes, err := generateRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := database.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// If anything fails, an early return is triggered (before tx.Commit is called) and tx.Rollback is called
+	defer tx.Rollback()
+
+	qtx := database.queries.WithTx(tx)
+	authDatum, err := qtx.GetAuthData(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOnFetchUserDoesNotExist
+		}
+		return nil, err
+	}
+
+	ok, err := database.checkPassword(authDatum, password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrIncorrectPassword
+	}
+
+	err = qtx.DeleteRecoveryCodesByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	err = storeRecoveryCodes(ctx, qtx, storedCodes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// Gets the number of unused recovery codes a user has left.
+func (database *DatabaseManager) CountRecoveryCodes(ctx context.Context, userID string) (int64, error) {
+	return database.queries.CountRecoveryCodes(ctx, userID)
+}
+
+// Given a userID and a recovery code, check the code against the unused recovery codes of the user.
+// Returns true if the code is correct, in which case the code is used up.
+// Returns false otherwise.
+//
+// Codes are compared ignoring case, spaces, and dashes. Each code is accepted at most once (even if two requests race).
+func (database *DatabaseManager) UseRecoveryCode(ctx context.Context, userID string, code string) (bool, error) {
+	storedCodes, err := database.queries.GetRecoveryCodesByUser(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	code = normalizeRecoveryCode(code)
+	for _, storedCode := range storedCodes {
+		ok, _, err := verifyPassword(code, storedCode.HashedCode, "", peppers{})
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			continue
+		}
+
+		rowsAffected, err := database.queries.DeleteRecoveryCode(ctx, sqlc.DeleteRecoveryCodeParams{
+			Uuid:      userID,
+			CodeIndex: storedCode.CodeIndex,
+		})
+		if err != nil {
+			return false, err
+		}
+		return rowsAffected == 1, nil
+	}
+	return false, nil
+}
+
+// Complete an MFA challenge with a recovery code in place of the second factor,
+// returning the userID of the user the challenge was created for.
+//
+// Fails (and returns a non-nil error) if:
+// - The challenge cannot be attempted (see CheckMFAChallenge)
+// - The code is incorrect, or has already been used (ErrIncorrectRecoveryCode)
+// - The challenge has already been completed by another request (ErrMFAChallengeInvalid)
+func (database *DatabaseManager) CompleteMFAChallengeWithRecoveryCode(ctx context.Context, challenge string, code string) (string, error) {
+	userID, err := database.CheckMFAChallenge(ctx, challenge)
+	if err != nil {
+		return "", err
+	}
+
+	ok, err := database.UseRecoveryCode(ctx, userID, code)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrIncorrectRecoveryCode
+	}
+
+	err = database.ConsumeMFAChallenge(ctx, challenge, userID)
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// Generate a new set of recovery codes for a user, returning the (plaintext) codes and the rows to store.
+// The codes are hashed here, outside of any transaction, as hashing is deliberately slow.
+// The codes are not peppered, as they are already too random to attack offline, and retiring a pepper must not invalidate them.
+func generateRecoveryCodes(userID string) ([]string, []sqlc.CreateRecoveryCodeParams, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	storedCodes := make([]sqlc.CreateRecoveryCodeParams, 0, recoveryCodeCount)
+	for codeIndex := 0; codeIndex < recoveryCodeCount; codeIndex++ {
+		codeBytes := make([]byte, recoveryCodeLen)
+		if _, err := io.ReadFull(rand.Reader, codeBytes); err != nil {
+			return nil, nil, err
+		}
+		code := recoveryCodeEncoding.EncodeToString(codeBytes)
+
+		hashedCode, err := hashPassword(code, peppers{})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes = append(codes, code[:len(code)/2]+"-"+code[len(code)/2:])
+		storedCodes = append(storedCodes, sqlc.CreateRecoveryCodeParams{
+			Uuid:       userID,
+			CodeIndex:  int64(codeIndex),
+			HashedCode: hashedCode,
+		})
+	}
+	return codes, storedCodes, nil
+}
+
+// Store a set of recovery codes from generateRecoveryCodes, using queries that should be part of a transaction.
+func storeRecoveryCodes(ctx context.Context, qtx *sqlc.Queries, storedCodes []sqlc.CreateRecoveryCodeParams) error {
+	for _, storedCode := range storedCodes {
+		err := qtx.CreateRecoveryCode(ctx, storedCode)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Normalize a recovery code as typed by a user to the form it was hashed in.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.Join(strings.Fields(code), "")
+}
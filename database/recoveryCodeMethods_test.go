@@ -0,0 +1,90 @@
+package database_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+func TestRecoveryCodes(t *testing.T) {
+	ctx := context.Background()
+	databaseManager.RegisterNewUser(ctx, "recoveryCodeUser", "Password123", "")
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "recoveryCodeUser")
+
+	codes, err := databaseManager.CreateInitialRecoveryCodes(ctx, userID)
+	if err != nil {
+		t.Fatalf("Error while creating recovery codes: %v", err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("Created %v recovery codes, expected 10", len(codes))
+	}
+	count, _ := databaseManager.CountRecoveryCodes(ctx, userID)
+	if count != 10 {
+		t.Errorf("User has %v recovery codes, expected 10", count)
+	}
+
+	codes2, err := databaseManager.CreateInitialRecoveryCodes(ctx, userID)
+	if err != nil || codes2 != nil {
+		t.Errorf("Creating initial recovery codes again replaced the existing codes: %v", err)
+	}
+
+	ok, _ := databaseManager.UseRecoveryCode(ctx, userID, "aaaaa-aaaaa")
+	if ok {
+		t.Errorf("Incorrect recovery code accepted")
+	}
+	ok, _ = databaseManager.UseRecoveryCode(ctx, userID, " "+strings.ToUpper(strings.ReplaceAll(codes[3], "-", ""))+" ")
+	if !ok {
+		t.Errorf("Correct recovery code (in different case, without dash) not accepted")
+	}
+	ok, _ = databaseManager.UseRecoveryCode(ctx, userID, codes[3])
+	if ok {
+		t.Errorf("Used recovery code accepted again")
+	}
+	count, _ = databaseManager.CountRecoveryCodes(ctx, userID)
+	if count != 9 {
+		t.Errorf("User has %v recovery codes after using one, expected 9", count)
+	}
+
+	_, err = databaseManager.RegenerateRecoveryCodes(ctx, userID, "WrongPassword")
+	if err != database.ErrIncorrectPassword {
+		t.Errorf("Regenerating recovery codes with incorrect password did not return ErrIncorrectPassword: %v", err)
+	}
+	newCodes, err := databaseManager.RegenerateRecoveryCodes(ctx, userID, "Password123")
+	if err != nil || len(newCodes) != 10 {
+		t.Fatalf("Error while regenerating recovery codes: %v", err)
+	}
+	ok, _ = databaseManager.UseRecoveryCode(ctx, userID, codes[4])
+	if ok {
+		t.Errorf("Recovery code from previous set accepted after regeneration")
+	}
+	ok, _ = databaseManager.UseRecoveryCode(ctx, userID, newCodes[4])
+	if !ok {
+		t.Errorf("Regenerated recovery code not accepted")
+	}
+}
+
+func TestCompleteMFAChallengeWithRecoveryCode(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := registerTOTPUser(t, "mfaRecoveryCodeUser")
+	codes, _ := databaseManager.CreateInitialRecoveryCodes(ctx, userID)
+
+	challenge, _ := databaseManager.CreateMFAChallenge(ctx, userID, time.Now().Add(time.Minute))
+	_, err := databaseManager.CompleteMFAChallengeWithRecoveryCode(ctx, challenge, "aaaaa-aaaaa")
+	if err != database.ErrIncorrectRecoveryCode {
+		t.Errorf("Incorrect recovery code did not return ErrIncorrectRecoveryCode: %v", err)
+	}
+
+	completedUserID, err := databaseManager.CompleteMFAChallengeWithRecoveryCode(ctx, challenge, codes[0])
+	if err != nil || completedUserID != userID {
+		t.Errorf("MFA challenge completed for %v, expected %v: %v", completedUserID, userID, err)
+	}
+
+	challenge, _ = databaseManager.CreateMFAChallenge(ctx, userID, time.Now().Add(time.Minute))
+	_, err = databaseManager.CompleteMFAChallengeWithRecoveryCode(ctx, challenge, codes[0])
+	if err != database.ErrIncorrectRecoveryCode {
+		t.Errorf("Used recovery code did not return ErrIncorrectRecoveryCode: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hmcalister/AuthSSO/database/sqlc"
+)
+
+// The user a token is issued to and, if the token is issued to an OpenID Connect client, that client and the scope granted to it.
+// Refresh tokens and sessions record their grant, so that tokens issued from them carry the same grant.
+type Grant struct {
+	UserID   string
+	ClientID string
+	Scope    string
+
+	// The family of the refresh token issued for the grant, if any.
+	// Sessions record the family, so rotating the refresh token revokes the session issued alongside the old token.
+	FamilyID string
+}
+
+// Create a new refresh token for a grant, starting a new token family.
+// The returned (plaintext) token is handed to the client, only the hash is stored in the database.
+// Returns the grant (naming the new token family), and the new refresh token.
+//
+// Fails (and returns a non-nil error) if:
+// - The token fails to be generated
+// - The token cannot be stored in the database
+func (database *DatabaseManager) CreateRefreshToken(ctx context.Context, grant Grant, expiresAt time.Time) (Grant, string, error) {
+	grant.FamilyID = uuid.New().String()
+	refreshToken, err := database.createRefreshTokenInFamily(ctx, database.queries, grant, expiresAt)
+	if err != nil {
+		return Grant{}, "", err
+	}
+	return grant, refreshToken, nil
+}
+
+// Exchange a refresh token for a new refresh token in the same family.
+// Each refresh token may be used exactly once. Returns the grant the token was issued for, and the new refresh token.
+// Any session issued alongside the old token is revoked.
+//
+// clientID is the client presenting the token, which must be the client the token was issued to
+// (or empty, for tokens not issued to an OpenID Connect client).
+//
+// If a token that has already been used is presented again, the token has likely been stolen.
+// In this case the entire token family is revoked, so neither the attacker nor the legitimate user can continue to refresh.
+//
+// Fails and returns a non-nil error if:
+// - The token does not exist, belongs to a revoked family, or was issued to another client (ErrRefreshTokenInvalid)
+// - The token has already been used (ErrRefreshTokenReused)
+// - The token has expired (ErrRefreshTokenExpired)
+// - The transaction to rotate the token fails
+func (database *DatabaseManager) RotateRefreshToken(ctx context.Context, refreshToken string, clientID string, expiresAt time.Time) (Grant, string, error) {
+	// Begin database transaction to ensure the old token is consumed and the new token created together
+	tx, err := database.db.Begin()
+	if err != nil {
+		return Grant{}, "", err
+	}
+	// If anything fails, an early return is triggered (before tx.Commit is called) and tx.Rollback is called
+	defer tx.Rollback()
+
+	qtx := database.queries.WithTx(tx)
+	storedToken, err := qtx.GetRefreshToken(ctx, hashOpaqueToken(refreshToken))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Grant{}, "", ErrRefreshTokenInvalid
+		}
+		return Grant{}, "", err
+	}
+
+	// Checked before the token is used, so another client cannot burn the token
+	if storedToken.Revoked || storedToken.ClientID != clientID {
+		return Grant{}, "", ErrRefreshTokenInvalid
+	}
+
+	if time.Now().Unix() >= storedToken.ExpiresAt {
+		return Grant{}, "", ErrRefreshTokenExpired
+	}
+
+	// Only one exchange of a token can ever succeed, even if two requests race
+	rowsAffected, err := qtx.MarkRefreshTokenUsed(ctx, storedToken.TokenHash)
+	if err != nil {
+		return Grant{}, "", err
+	}
+	if rowsAffected == 0 {
+		// The family may be held by an attacker, so its refresh tokens and sessions are all revoked
+		err = qtx.RevokeRefreshTokenFamily(ctx, storedToken.FamilyID)
+		if err != nil {
+			return Grant{}, "", err
+		}
+		err = qtx.DeleteSessionsByFamily(ctx, storedToken.FamilyID)
+		if err != nil {
+			return Grant{}, "", err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return Grant{}, "", err
+		}
+		return Grant{}, "", ErrRefreshTokenReused
+	}
+
+	// The session issued alongside the old token is replaced by the session issued alongside the new token
+	err = qtx.DeleteSessionsByFamily(ctx, storedToken.FamilyID)
+	if err != nil {
+		return Grant{}, "", err
+	}
+
+	grant := Grant{
+		UserID:   storedToken.Uuid,
+		ClientID: storedToken.ClientID,
+		Scope:    storedToken.Scope,
+		FamilyID: storedToken.FamilyID,
+	}
+	newRefreshToken, err := database.createRefreshTokenInFamily(ctx, qtx, grant, expiresAt)
+	if err != nil {
+		return Grant{}, "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return Grant{}, "", err
+	}
+	return grant, newRefreshToken, nil
+}
+
+// Revoke a refresh token, and every other refresh token and session in the same family.
+// Used on logout, so the refresh token cannot be used to get new access tokens.
+//
+// Fails and returns a non-nil error if:
+// - The token does not exist (ErrRefreshTokenInvalid)
+// - The transaction to revoke the family fails
+func (database *DatabaseManager) RevokeRefreshToken(ctx context.Context, refreshToken string) error {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+	// If anything fails, an early return is triggered (before tx.Commit is called) and tx.Rollback is called
+	defer tx.Rollback()
+
+	qtx := database.queries.WithTx(tx)
+	storedToken, err := qtx.GetRefreshToken(ctx, hashOpaqueToken(refreshToken))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrRefreshTokenInvalid
+		}
+		return err
+	}
+
+	err = qtx.RevokeRefreshTokenFamily(ctx, storedToken.FamilyID)
+	if err != nil {
+		return err
+	}
+	err = qtx.DeleteSessionsByFamily(ctx, storedToken.FamilyID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Generate and store a new refresh token in the family of the grant, using the given queries (which may be part of a transaction).
+func (database *DatabaseManager) createRefreshTokenInFamily(ctx context.Context, queries *sqlc.Queries, grant Grant, expiresAt time.Time) (string, error) {
+	refreshToken, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = queries.CreateRefreshToken(ctx, sqlc.CreateRefreshTokenParams{
+		TokenHash: hashOpaqueToken(refreshToken),
+		FamilyID:  grant.FamilyID,
+		Uuid:      grant.UserID,
+		ExpiresAt: expiresAt.Unix(),
+		ClientID:  grant.ClientID,
+		Scope:     grant.Scope,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
+}
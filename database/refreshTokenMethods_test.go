@@ -0,0 +1,103 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+func TestRotateRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "John Smith")
+	expiresAt := time.Now().Add(time.Hour)
+
+	_, refreshToken, err := databaseManager.CreateRefreshToken(ctx, database.Grant{UserID: userID}, expiresAt)
+	if err != nil {
+		t.Fatalf("Error while creating refresh token: %v", err)
+	}
+
+	rotatedGrant, rotatedToken, err := databaseManager.RotateRefreshToken(ctx, refreshToken, "", expiresAt)
+	if err != nil {
+		t.Fatalf("Error while rotating refresh token: %v", err)
+	}
+	if rotatedGrant.UserID != userID {
+		t.Errorf("Rotated refresh token belongs to wrong user: expected %v, got %v", userID, rotatedGrant.UserID)
+	}
+	if rotatedToken == refreshToken {
+		t.Errorf("Rotated refresh token is identical to original refresh token")
+	}
+
+	_, _, err = databaseManager.RotateRefreshToken(ctx, rotatedToken, "", expiresAt)
+	if err != nil {
+		t.Errorf("Error while rotating refresh token a second time: %v", err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "John Smith")
+	expiresAt := time.Now().Add(time.Hour)
+
+	_, refreshToken, _ := databaseManager.CreateRefreshToken(ctx, database.Grant{UserID: userID}, expiresAt)
+	_, rotatedToken, err := databaseManager.RotateRefreshToken(ctx, refreshToken, "", expiresAt)
+	if err != nil {
+		t.Fatalf("Error while rotating refresh token: %v", err)
+	}
+
+	_, _, err = databaseManager.RotateRefreshToken(ctx, refreshToken, "", expiresAt)
+	if err != database.ErrRefreshTokenReused {
+		t.Errorf("Reused refresh token did not return ErrRefreshTokenReused: %v", err)
+	}
+
+	_, _, err = databaseManager.RotateRefreshToken(ctx, rotatedToken, "", expiresAt)
+	if err != database.ErrRefreshTokenInvalid {
+		t.Errorf("Refresh token from revoked family did not return ErrRefreshTokenInvalid: %v", err)
+	}
+}
+
+func TestExpiredRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "John Smith")
+
+	_, refreshToken, _ := databaseManager.CreateRefreshToken(ctx, database.Grant{UserID: userID}, time.Now().Add(-time.Hour))
+	_, _, err := databaseManager.RotateRefreshToken(ctx, refreshToken, "", time.Now().Add(time.Hour))
+	if err != database.ErrRefreshTokenExpired {
+		t.Errorf("Expired refresh token did not return ErrRefreshTokenExpired: %v", err)
+	}
+}
+
+func TestUnknownRefreshToken(t *testing.T) {
+	ctx := context.Background()
+
+	_, _, err := databaseManager.RotateRefreshToken(ctx, "notARefreshToken", "", time.Now().Add(time.Hour))
+	if err != database.ErrRefreshTokenInvalid {
+		t.Errorf("Unknown refresh token did not return ErrRefreshTokenInvalid: %v", err)
+	}
+}
+
+func TestRefreshTokenBoundToClient(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "John Smith")
+	expiresAt := time.Now().Add(time.Hour)
+	grant := database.Grant{
+		UserID:   userID,
+		ClientID: "refreshTokenClient",
+		Scope:    "openid profile",
+	}
+
+	grant, refreshToken, _ := databaseManager.CreateRefreshToken(ctx, grant, expiresAt)
+	_, _, err := databaseManager.RotateRefreshToken(ctx, refreshToken, "", expiresAt)
+	if err != database.ErrRefreshTokenInvalid {
+		t.Errorf("Refresh token presented by wrong client did not return ErrRefreshTokenInvalid: %v", err)
+	}
+
+	rotatedGrant, _, err := databaseManager.RotateRefreshToken(ctx, refreshToken, grant.ClientID, expiresAt)
+	if err != nil {
+		t.Fatalf("Error while rotating refresh token after wrong client presented it: %v", err)
+	}
+	if rotatedGrant != grant {
+		t.Errorf("Rotated refresh token has wrong grant: expected %v, got %v", grant, rotatedGrant)
+	}
+}
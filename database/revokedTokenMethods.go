@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database/sqlc"
+)
+
+const (
+	// How often expired revoked tokens, refresh tokens, authorization codes, and sessions are removed from the database.
+	expiredTokenPruneInterval time.Duration = 1 * time.Hour
+)
+
+// Revoke a token by its ID (the `jti` claim), so it is rejected even though it has not yet expired.
+//
+// The revocation is only kept until expiresAt (the expiry of the token itself), after which the
+// token would be rejected anyway, and the revocation can be pruned.
+func (database *DatabaseManager) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	return database.queries.CreateRevokedToken(ctx, sqlc.CreateRevokedTokenParams{
+		TokenID:   tokenID,
+		ExpiresAt: expiresAt.Unix(),
+	})
+}
+
+// Checks if a token has been revoked by its ID (the `jti` claim). Returns true if the token is revoked.
+func (database *DatabaseManager) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	revoked, err := database.queries.IsTokenRevoked(ctx, tokenID)
+	if err != nil {
+		return false, err
+	}
+
+	return revoked != 0, nil
+}
+
+// Remove revoked tokens, refresh tokens, authorization codes, sessions, password reset and email verification tokens, MFA challenges, WebAuthn sessions, and login throttles that have expired, and hence no longer need to be stored.
+func (database *DatabaseManager) PruneExpiredTokens(ctx context.Context) error {
+	currentTime := time.Now().Unix()
+
+	err := database.queries.DeleteExpiredRevokedTokens(ctx, currentTime)
+	if err != nil {
+		return err
+	}
+	err = database.queries.DeleteExpiredRefreshTokens(ctx, currentTime)
+	if err != nil {
+		return err
+	}
+	err = database.queries.DeleteExpiredAuthorizationCodes(ctx, currentTime)
+	if err != nil {
+		return err
+	}
+	err = database.queries.DeleteExpiredSessions(ctx, currentTime)
+	if err != nil {
+		return err
+	}
+	err = database.queries.DeleteExpiredPasswordResetTokens(ctx, currentTime)
+	if err != nil {
+		return err
+	}
+	err = database.queries.DeleteExpiredEmailVerificationTokens(ctx, currentTime)
+	if err != nil {
+		return err
+	}
+	err = database.queries.DeleteExpiredMFAChallenges(ctx, currentTime)
+	if err != nil {
+		return err
+	}
+	err = database.queries.DeleteExpiredWebAuthnSessions(ctx, currentTime)
+	if err != nil {
+		return err
+	}
+	return database.queries.DeleteExpiredLoginThrottles(ctx, currentTime)
+}
+
+// Prune expired tokens every interval, until stopPruning is closed.
+//
+// This is started by NewDatabase and stopped by CloseDatabase, so callers never need to prune manually.
+func (database *DatabaseManager) pruneExpiredTokensPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-database.stopPruning:
+			return
+		case <-ticker.C:
+			err := database.PruneExpiredTokens(context.Background())
+			if err != nil {
+				slog.Error("Error during pruning of expired tokens", "Error", err)
+			}
+		}
+	}
+}
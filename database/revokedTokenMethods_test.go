@@ -0,0 +1,79 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+func TestRevokeToken(t *testing.T) {
+	ctx := context.Background()
+
+	revoked, err := databaseManager.IsTokenRevoked(ctx, "revokedTokenID")
+	if err != nil {
+		t.Fatalf("Error while checking token revocation: %v", err)
+	}
+	if revoked {
+		t.Errorf("Token revoked before revocation")
+	}
+
+	err = databaseManager.RevokeToken(ctx, "revokedTokenID", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Error while revoking token: %v", err)
+	}
+	err = databaseManager.RevokeToken(ctx, "revokedTokenID", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Errorf("Error while revoking token a second time: %v", err)
+	}
+
+	revoked, err = databaseManager.IsTokenRevoked(ctx, "revokedTokenID")
+	if err != nil {
+		t.Fatalf("Error while checking token revocation: %v", err)
+	}
+	if !revoked {
+		t.Errorf("Token not revoked after revocation")
+	}
+}
+
+func TestPruneExpiredTokens(t *testing.T) {
+	ctx := context.Background()
+
+	databaseManager.RevokeToken(ctx, "expiredTokenID", time.Now().Add(-time.Hour))
+	databaseManager.RevokeToken(ctx, "unexpiredTokenID", time.Now().Add(time.Hour))
+
+	err := databaseManager.PruneExpiredTokens(ctx)
+	if err != nil {
+		t.Fatalf("Error while pruning expired tokens: %v", err)
+	}
+
+	if revoked, _ := databaseManager.IsTokenRevoked(ctx, "expiredTokenID"); revoked {
+		t.Errorf("Expired revoked token not pruned")
+	}
+	if revoked, _ := databaseManager.IsTokenRevoked(ctx, "unexpiredTokenID"); !revoked {
+		t.Errorf("Unexpired revoked token pruned")
+	}
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "John Smith")
+	expiresAt := time.Now().Add(time.Hour)
+
+	_, refreshToken, _ := databaseManager.CreateRefreshToken(ctx, database.Grant{UserID: userID}, expiresAt)
+	_, rotatedToken, err := databaseManager.RotateRefreshToken(ctx, refreshToken, "", expiresAt)
+	if err != nil {
+		t.Fatalf("Error while rotating refresh token: %v", err)
+	}
+
+	err = databaseManager.RevokeRefreshToken(ctx, rotatedToken)
+	if err != nil {
+		t.Fatalf("Error while revoking refresh token: %v", err)
+	}
+
+	_, _, err = databaseManager.RotateRefreshToken(ctx, rotatedToken, "", expiresAt)
+	if err != database.ErrRefreshTokenInvalid {
+		t.Errorf("Revoked refresh token did not return ErrRefreshTokenInvalid: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hmcalister/AuthSSO/database/sqlc"
+)
+
+// Give a user a role. Giving a user a role they already have is not an error.
+//
+// Fails (and returns a non-nil error) if:
+// - The role is empty or contains whitespace (ErrInvalidRoleOrGroupName)
+// - The role cannot be stored in the database
+func (database *DatabaseManager) AddUserRole(ctx context.Context, userID string, role string) error {
+	if !validRoleOrGroupName(role) {
+		return ErrInvalidRoleOrGroupName
+	}
+
+	return database.queries.CreateUserRole(ctx, sqlc.CreateUserRoleParams{
+		Uuid: userID,
+		Role: role,
+	})
+}
+
+// Take a role from a user. Taking a role the user does not have is not an error.
+func (database *DatabaseManager) RemoveUserRole(ctx context.Context, userID string, role string) error {
+	return database.queries.DeleteUserRole(ctx, sqlc.DeleteUserRoleParams{
+		Uuid: userID,
+		Role: role,
+	})
+}
+
+// Gets the roles of a user, sorted by name. Returns an empty slice if the user has no roles.
+func (database *DatabaseManager) GetUserRoles(ctx context.Context, userID string) ([]string, error) {
+	roles, err := database.queries.GetUserRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+
+	return roles, nil
+}
+
+// Add a user to a group. Adding a user to a group they are already in is not an error.
+//
+// Fails (and returns a non-nil error) if:
+// - The group is empty or contains whitespace (ErrInvalidRoleOrGroupName)
+// - The group membership cannot be stored in the database
+func (database *DatabaseManager) AddUserGroup(ctx context.Context, userID string, group string) error {
+	if !validRoleOrGroupName(group) {
+		return ErrInvalidRoleOrGroupName
+	}
+
+	return database.queries.CreateUserGroup(ctx, sqlc.CreateUserGroupParams{
+		Uuid:      userID,
+		GroupName: group,
+	})
+}
+
+// Remove a user from a group. Removing a user from a group they are not in is not an error.
+func (database *DatabaseManager) RemoveUserGroup(ctx context.Context, userID string, group string) error {
+	return database.queries.DeleteUserGroup(ctx, sqlc.DeleteUserGroupParams{
+		Uuid:      userID,
+		GroupName: group,
+	})
+}
+
+// Gets the groups a user is in, sorted by name. Returns an empty slice if the user is in no groups.
+func (database *DatabaseManager) GetUserGroups(ctx context.Context, userID string) ([]string, error) {
+	groups, err := database.queries.GetUserGroups(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if groups == nil {
+		groups = []string{}
+	}
+
+	return groups, nil
+}
+
+// Roles and groups are given as space separated lists on the command line, so must not contain whitespace themselves.
+func validRoleOrGroupName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t\n\r")
+}
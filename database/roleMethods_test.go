@@ -0,0 +1,65 @@
+package database_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+func TestUserRoles(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "Jane Doe")
+
+	for _, role := range []string{"editor", "admin", "admin"} {
+		err := databaseManager.AddUserRole(ctx, userID, role)
+		if err != nil {
+			t.Fatalf("Error while adding role %v: %v", role, err)
+		}
+	}
+
+	roles, err := databaseManager.GetUserRoles(ctx, userID)
+	if err != nil {
+		t.Fatalf("Error while getting roles: %v", err)
+	}
+	if !slices.Equal(roles, []string{"admin", "editor"}) {
+		t.Errorf("Roles do not match roles added: %v", roles)
+	}
+
+	err = databaseManager.RemoveUserRole(ctx, userID, "admin")
+	if err != nil {
+		t.Fatalf("Error while removing role: %v", err)
+	}
+	roles, _ = databaseManager.GetUserRoles(ctx, userID)
+	if !slices.Equal(roles, []string{"editor"}) {
+		t.Errorf("Roles do not match after removing role: %v", roles)
+	}
+
+	err = databaseManager.AddUserRole(ctx, userID, "two words")
+	if err != database.ErrInvalidRoleOrGroupName {
+		t.Errorf("Adding role containing whitespace did not return ErrInvalidRoleOrGroupName: %v", err)
+	}
+}
+
+func TestUserGroups(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "Jane Doe")
+
+	groups, err := databaseManager.GetUserGroups(ctx, userID)
+	if err != nil || groups == nil || len(groups) != 0 {
+		t.Errorf("User with no groups did not return empty groups: %v, %v", groups, err)
+	}
+
+	databaseManager.AddUserGroup(ctx, userID, "engineering")
+	groups, _ = databaseManager.GetUserGroups(ctx, userID)
+	if !slices.Equal(groups, []string{"engineering"}) {
+		t.Errorf("Groups do not match groups added: %v", groups)
+	}
+
+	databaseManager.RemoveUserGroup(ctx, userID, "engineering")
+	groups, _ = databaseManager.GetUserGroups(ctx, userID)
+	if len(groups) != 0 {
+		t.Errorf("Groups do not match after removing group: %v", groups)
+	}
+}
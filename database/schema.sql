@@ -1,6 +1,8 @@
 CREATE TABLE IF NOT EXISTS users (
     uuid text PRIMARY KEY,
     username text NOT NULL UNIQUE,
+    email text,
+    email_verified boolean NOT NULL DEFAULT FALSE,
     FOREIGN KEY (uuid) REFERENCES authenticationData(uuid)
 );
 
@@ -9,3 +11,131 @@ CREATE TABLE IF NOT EXISTS authenticationData (
     hashed_password text NOT NULL,
     salt text NOT NULL
 );
+
+CREATE TABLE IF NOT EXISTS refreshTokens (
+    token_hash text PRIMARY KEY,
+    family_id text NOT NULL,
+    uuid text NOT NULL,
+    expires_at integer NOT NULL,
+    used boolean NOT NULL DEFAULT FALSE,
+    revoked boolean NOT NULL DEFAULT FALSE,
+    client_id text NOT NULL DEFAULT '',
+    scope text NOT NULL DEFAULT '',
+    FOREIGN KEY (uuid) REFERENCES users(uuid)
+);
+
+CREATE TABLE IF NOT EXISTS revokedTokens (
+    token_id text PRIMARY KEY,
+    expires_at integer NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS clients (
+    client_id text PRIMARY KEY,
+    client_secret_hash text NOT NULL,
+    redirect_uris text NOT NULL,
+    audience text NOT NULL DEFAULT '',
+    token_lifetime integer NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS authorizationCodes (
+    code_hash text PRIMARY KEY,
+    client_id text NOT NULL,
+    uuid text NOT NULL,
+    redirect_uri text NOT NULL,
+    scope text NOT NULL,
+    nonce text NOT NULL,
+    code_challenge text NOT NULL,
+    expires_at integer NOT NULL,
+    FOREIGN KEY (client_id) REFERENCES clients(client_id),
+    FOREIGN KEY (uuid) REFERENCES users(uuid)
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    session_hash text PRIMARY KEY,
+    uuid text NOT NULL,
+    created_at integer NOT NULL,
+    last_seen_at integer NOT NULL,
+    expires_at integer NOT NULL,
+    client_id text NOT NULL DEFAULT '',
+    scope text NOT NULL DEFAULT '',
+    family_id text NOT NULL DEFAULT '',
+    FOREIGN KEY (uuid) REFERENCES users(uuid)
+);
+
+CREATE TABLE IF NOT EXISTS userRoles (
+    uuid text NOT NULL,
+    role text NOT NULL,
+    PRIMARY KEY (uuid, role),
+    FOREIGN KEY (uuid) REFERENCES users(uuid)
+);
+
+CREATE TABLE IF NOT EXISTS userGroups (
+    uuid text NOT NULL,
+    group_name text NOT NULL,
+    PRIMARY KEY (uuid, group_name),
+    FOREIGN KEY (uuid) REFERENCES users(uuid)
+);
+
+CREATE TABLE IF NOT EXISTS passwordResetTokens (
+    token_hash text PRIMARY KEY,
+    uuid text NOT NULL,
+    expires_at integer NOT NULL,
+    FOREIGN KEY (uuid) REFERENCES users(uuid)
+);
+
+CREATE TABLE IF NOT EXISTS emailVerificationTokens (
+    token_hash text PRIMARY KEY,
+    uuid text NOT NULL,
+    email text NOT NULL,
+    expires_at integer NOT NULL,
+    FOREIGN KEY (uuid) REFERENCES users(uuid)
+);
+
+CREATE TABLE IF NOT EXISTS totpSecrets (
+    uuid text PRIMARY KEY,
+    encrypted_secret text NOT NULL,
+    enabled boolean NOT NULL DEFAULT FALSE,
+    last_used_step integer NOT NULL DEFAULT 0,
+    FOREIGN KEY (uuid) REFERENCES users(uuid)
+);
+
+CREATE TABLE IF NOT EXISTS mfaChallenges (
+    challenge_hash text PRIMARY KEY,
+    uuid text NOT NULL,
+    expires_at integer NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    FOREIGN KEY (uuid) REFERENCES users(uuid)
+);
+
+CREATE TABLE IF NOT EXISTS webauthnCredentials (
+    credential_id text PRIMARY KEY,
+    uuid text NOT NULL,
+    name text NOT NULL,
+    credential text NOT NULL,
+    created_at integer NOT NULL,
+    last_used_at integer NOT NULL DEFAULT 0,
+    FOREIGN KEY (uuid) REFERENCES users(uuid)
+);
+
+CREATE TABLE IF NOT EXISTS webauthnSessions (
+    session_hash text PRIMARY KEY,
+    uuid text NOT NULL,
+    ceremony text NOT NULL,
+    session_data text NOT NULL,
+    expires_at integer NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS recoveryCodes (
+    uuid text NOT NULL,
+    code_index integer NOT NULL,
+    hashed_code text NOT NULL,
+    PRIMARY KEY (uuid, code_index),
+    FOREIGN KEY (uuid) REFERENCES users(uuid)
+);
+
+CREATE TABLE IF NOT EXISTS loginThrottles (
+    throttle_key text PRIMARY KEY,
+    failures integer NOT NULL,
+    locked_until integer NOT NULL,
+    expires_at integer NOT NULL
+);
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database/sqlc"
+)
+
+// A server-side session, identified to the client only by an opaque session ID.
+type Session struct {
+	Grant
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
+
+// Create a new session for a grant, expiring at expiresAt.
+// The returned (plaintext) session ID is handed to the client, only the hash is stored in the database.
+//
+// Fails (and returns a non-nil error) if:
+// - The session ID fails to be generated
+// - The session cannot be stored in the database
+func (database *DatabaseManager) CreateSession(ctx context.Context, grant Grant, expiresAt time.Time) (string, error) {
+	sessionID, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	currentTime := time.Now().Unix()
+	err = database.queries.CreateSession(ctx, sqlc.CreateSessionParams{
+		SessionHash: hashOpaqueToken(sessionID),
+		Uuid:        grant.UserID,
+		CreatedAt:   currentTime,
+		LastSeenAt:  currentTime,
+		ExpiresAt:   expiresAt.Unix(),
+		ClientID:    grant.ClientID,
+		Scope:       grant.Scope,
+		FamilyID:    grant.FamilyID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return sessionID, nil
+}
+
+// Get the session with the given session ID, and record that it was seen now.
+// Sessions that have not been seen for longer than idleTimeout are treated as expired.
+//
+// Fails and returns a non-nil error if:
+// - The session does not exist, or has been revoked (ErrSessionInvalid)
+// - The session has expired, or been idle too long (ErrSessionExpired)
+// - The last seen time cannot be updated
+func (database *DatabaseManager) GetSession(ctx context.Context, sessionID string, idleTimeout time.Duration) (Session, error) {
+	sessionHash := hashOpaqueToken(sessionID)
+	storedSession, err := database.queries.GetSession(ctx, sessionHash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Session{}, ErrSessionInvalid
+		}
+		return Session{}, err
+	}
+
+	currentTime := time.Now()
+	if currentTime.Unix() >= storedSession.ExpiresAt || currentTime.Sub(time.Unix(storedSession.LastSeenAt, 0)) >= idleTimeout {
+		return Session{}, ErrSessionExpired
+	}
+
+	err = database.queries.UpdateSessionLastSeen(ctx, sqlc.UpdateSessionLastSeenParams{
+		LastSeenAt:  currentTime.Unix(),
+		SessionHash: sessionHash,
+	})
+	if err != nil {
+		return Session{}, err
+	}
+
+	return Session{
+		Grant: Grant{
+			UserID:   storedSession.Uuid,
+			ClientID: storedSession.ClientID,
+			Scope:    storedSession.Scope,
+			FamilyID: storedSession.FamilyID,
+		},
+		CreatedAt:  time.Unix(storedSession.CreatedAt, 0),
+		LastSeenAt: currentTime,
+		ExpiresAt:  time.Unix(storedSession.ExpiresAt, 0),
+	}, nil
+}
+
+// Revoke a session, so the session ID is rejected immediately.
+// Revoking a session that does not exist is not an error.
+func (database *DatabaseManager) RevokeSession(ctx context.Context, sessionID string) error {
+	return database.queries.DeleteSession(ctx, hashOpaqueToken(sessionID))
+}
+
+// Revoke every refresh token and session of a user, logging the user out everywhere.
+// Access tokens already issued as JWTs are not revoked, and are accepted until they expire.
+//
+// Fails (and returns a non-nil error) if:
+// - The transaction to delete the refresh tokens and sessions fails
+func (database *DatabaseManager) RevokeUserSessions(ctx context.Context, userID string) error {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+	// If anything fails, an early return is triggered (before tx.Commit is called) and tx.Rollback is called
+	defer tx.Rollback()
+
+	qtx := database.queries.WithTx(tx)
+	err = qtx.DeleteRefreshTokensByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = qtx.DeleteSessionsByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
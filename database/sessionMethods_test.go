@@ -0,0 +1,162 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+func TestGetSession(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "John Smith")
+
+	sessionID, err := databaseManager.CreateSession(ctx, database.Grant{UserID: userID}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Error while creating session: %v", err)
+	}
+
+	session, err := databaseManager.GetSession(ctx, sessionID, time.Hour)
+	if err != nil {
+		t.Fatalf("Error while getting session: %v", err)
+	}
+	if session.UserID != userID {
+		t.Errorf("Session belongs to wrong user: expected %v, got %v", userID, session.UserID)
+	}
+
+	_, err = databaseManager.GetSession(ctx, "not a session", time.Hour)
+	if err != database.ErrSessionInvalid {
+		t.Errorf("Unknown session did not return ErrSessionInvalid: %v", err)
+	}
+}
+
+func TestExpiredSession(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "John Smith")
+
+	sessionID, _ := databaseManager.CreateSession(ctx, database.Grant{UserID: userID}, time.Now().Add(-time.Minute))
+	_, err := databaseManager.GetSession(ctx, sessionID, time.Hour)
+	if err != database.ErrSessionExpired {
+		t.Errorf("Expired session did not return ErrSessionExpired: %v", err)
+	}
+
+	sessionID, _ = databaseManager.CreateSession(ctx, database.Grant{UserID: userID}, time.Now().Add(time.Hour))
+	_, err = databaseManager.GetSession(ctx, sessionID, 0)
+	if err != database.ErrSessionExpired {
+		t.Errorf("Idle session did not return ErrSessionExpired: %v", err)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "John Smith")
+
+	sessionID, _ := databaseManager.CreateSession(ctx, database.Grant{UserID: userID}, time.Now().Add(time.Hour))
+	err := databaseManager.RevokeSession(ctx, sessionID)
+	if err != nil {
+		t.Fatalf("Error while revoking session: %v", err)
+	}
+
+	_, err = databaseManager.GetSession(ctx, sessionID, time.Hour)
+	if err != database.ErrSessionInvalid {
+		t.Errorf("Revoked session did not return ErrSessionInvalid: %v", err)
+	}
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	ctx := context.Background()
+	databaseManager.RegisterNewUser(ctx, "revokeSessionsUser", "Password123", "")
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "revokeSessionsUser")
+	otherUserID, _ := databaseManager.GetUserIDByUsername(ctx, "John Smith")
+	expiresAt := time.Now().Add(time.Hour)
+
+	sessionID, _ := databaseManager.CreateSession(ctx, database.Grant{UserID: userID}, expiresAt)
+	_, refreshToken, _ := databaseManager.CreateRefreshToken(ctx, database.Grant{UserID: userID}, expiresAt)
+	otherSessionID, _ := databaseManager.CreateSession(ctx, database.Grant{UserID: otherUserID}, expiresAt)
+
+	err := databaseManager.RevokeUserSessions(ctx, userID)
+	if err != nil {
+		t.Fatalf("Error while revoking user sessions: %v", err)
+	}
+
+	_, err = databaseManager.GetSession(ctx, sessionID, time.Hour)
+	if err != database.ErrSessionInvalid {
+		t.Errorf("Revoked session did not return ErrSessionInvalid: %v", err)
+	}
+	_, _, err = databaseManager.RotateRefreshToken(ctx, refreshToken, "", expiresAt)
+	if err != database.ErrRefreshTokenInvalid {
+		t.Errorf("Revoked refresh token did not return ErrRefreshTokenInvalid: %v", err)
+	}
+	_, err = databaseManager.GetSession(ctx, otherSessionID, time.Hour)
+	if err != nil {
+		t.Errorf("Session of other user revoked: %v", err)
+	}
+}
+
+func TestRotateRefreshTokenRevokesSession(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "John Smith")
+	expiresAt := time.Now().Add(time.Hour)
+
+	grant, refreshToken, _ := databaseManager.CreateRefreshToken(ctx, database.Grant{UserID: userID}, expiresAt)
+	sessionID, _ := databaseManager.CreateSession(ctx, grant, expiresAt)
+	otherSessionID, _ := databaseManager.CreateSession(ctx, database.Grant{UserID: userID}, expiresAt)
+
+	rotatedGrant, _, err := databaseManager.RotateRefreshToken(ctx, refreshToken, "", expiresAt)
+	if err != nil {
+		t.Fatalf("Error while rotating refresh token: %v", err)
+	}
+	if rotatedGrant.FamilyID != grant.FamilyID {
+		t.Errorf("Rotated refresh token has wrong family: expected %v, got %v", grant.FamilyID, rotatedGrant.FamilyID)
+	}
+
+	_, err = databaseManager.GetSession(ctx, sessionID, time.Hour)
+	if err != database.ErrSessionInvalid {
+		t.Errorf("Session issued alongside rotated refresh token did not return ErrSessionInvalid: %v", err)
+	}
+	_, err = databaseManager.GetSession(ctx, otherSessionID, time.Hour)
+	if err != nil {
+		t.Errorf("Session of other refresh token family revoked: %v", err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "John Smith")
+	expiresAt := time.Now().Add(time.Hour)
+
+	_, refreshToken, _ := databaseManager.CreateRefreshToken(ctx, database.Grant{UserID: userID}, expiresAt)
+	rotatedGrant, _, err := databaseManager.RotateRefreshToken(ctx, refreshToken, "", expiresAt)
+	if err != nil {
+		t.Fatalf("Error while rotating refresh token: %v", err)
+	}
+	sessionID, _ := databaseManager.CreateSession(ctx, rotatedGrant, expiresAt)
+
+	_, _, err = databaseManager.RotateRefreshToken(ctx, refreshToken, "", expiresAt)
+	if err != database.ErrRefreshTokenReused {
+		t.Fatalf("Reused refresh token did not return ErrRefreshTokenReused: %v", err)
+	}
+	_, err = databaseManager.GetSession(ctx, sessionID, time.Hour)
+	if err != database.ErrSessionInvalid {
+		t.Errorf("Session of reused refresh token family did not return ErrSessionInvalid: %v", err)
+	}
+}
+
+func TestRevokeRefreshTokenRevokesSession(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "John Smith")
+	expiresAt := time.Now().Add(time.Hour)
+
+	grant, refreshToken, _ := databaseManager.CreateRefreshToken(ctx, database.Grant{UserID: userID}, expiresAt)
+	sessionID, _ := databaseManager.CreateSession(ctx, grant, expiresAt)
+
+	err := databaseManager.RevokeRefreshToken(ctx, refreshToken)
+	if err != nil {
+		t.Fatalf("Error while revoking refresh token: %v", err)
+	}
+	_, err = databaseManager.GetSession(ctx, sessionID, time.Hour)
+	if err != database.ErrSessionInvalid {
+		t.Errorf("Session of revoked refresh token family did not return ErrSessionInvalid: %v", err)
+	}
+}
@@ -4,7 +4,9 @@
 
 package sqlc
 
-import ()
+import (
+	"database/sql"
+)
 
 type AuthenticationDatum struct {
 	Uuid           string
@@ -12,7 +14,122 @@ type AuthenticationDatum struct {
 	Salt           string
 }
 
+type AuthorizationCode struct {
+	CodeHash      string
+	ClientID      string
+	Uuid          string
+	RedirectUri   string
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+	ExpiresAt     int64
+}
+
+type Client struct {
+	ClientID         string
+	ClientSecretHash string
+	RedirectUris     string
+	Audience         string
+	TokenLifetime    int64
+}
+
+type EmailVerificationToken struct {
+	TokenHash string
+	Uuid      string
+	Email     string
+	ExpiresAt int64
+}
+
+type LoginThrottle struct {
+	ThrottleKey string
+	Failures    int64
+	LockedUntil int64
+	ExpiresAt   int64
+}
+
+type MfaChallenge struct {
+	ChallengeHash string
+	Uuid          string
+	ExpiresAt     int64
+	Attempts      int64
+}
+
+type PasswordResetToken struct {
+	TokenHash string
+	Uuid      string
+	ExpiresAt int64
+}
+
+type RecoveryCode struct {
+	Uuid       string
+	CodeIndex  int64
+	HashedCode string
+}
+
+type RefreshToken struct {
+	TokenHash string
+	FamilyID  string
+	Uuid      string
+	ExpiresAt int64
+	Used      bool
+	Revoked   bool
+	ClientID  string
+	Scope     string
+}
+
+type RevokedToken struct {
+	TokenID   string
+	ExpiresAt int64
+}
+
+type Session struct {
+	SessionHash string
+	Uuid        string
+	CreatedAt   int64
+	LastSeenAt  int64
+	ExpiresAt   int64
+	ClientID    string
+	Scope       string
+	FamilyID    string
+}
+
+type TotpSecret struct {
+	Uuid            string
+	EncryptedSecret string
+	Enabled         bool
+	LastUsedStep    int64
+}
+
 type User struct {
-	Uuid     string
-	Username string
+	Uuid          string
+	Username      string
+	Email         sql.NullString
+	EmailVerified bool
+}
+
+type UserGroup struct {
+	Uuid      string
+	GroupName string
+}
+
+type UserRole struct {
+	Uuid string
+	Role string
+}
+
+type WebauthnCredential struct {
+	CredentialID string
+	Uuid         string
+	Name         string
+	Credential   string
+	CreatedAt    int64
+	LastUsedAt   int64
+}
+
+type WebauthnSession struct {
+	SessionHash string
+	Uuid        string
+	Ceremony    string
+	SessionData string
+	ExpiresAt   int64
 }
@@ -7,48 +7,490 @@ package sqlc
 
 import (
 	"context"
+	"database/sql"
 )
 
+const consumeAuthorizationCode = `-- name: ConsumeAuthorizationCode :one
+DELETE FROM authorizationCodes
+WHERE code_hash = ?
+RETURNING code_hash, client_id, uuid, redirect_uri, scope, nonce, code_challenge, expires_at
+`
+
+func (q *Queries) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (AuthorizationCode, error) {
+	row := q.db.QueryRowContext(ctx, consumeAuthorizationCode, codeHash)
+	var i AuthorizationCode
+	err := row.Scan(
+		&i.CodeHash,
+		&i.ClientID,
+		&i.Uuid,
+		&i.RedirectUri,
+		&i.Scope,
+		&i.Nonce,
+		&i.CodeChallenge,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const consumeEmailVerificationToken = `-- name: ConsumeEmailVerificationToken :one
+DELETE FROM emailVerificationTokens
+WHERE token_hash = ?
+RETURNING token_hash, uuid, email, expires_at
+`
+
+func (q *Queries) ConsumeEmailVerificationToken(ctx context.Context, tokenHash string) (EmailVerificationToken, error) {
+	row := q.db.QueryRowContext(ctx, consumeEmailVerificationToken, tokenHash)
+	var i EmailVerificationToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.Uuid,
+		&i.Email,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const consumePasswordResetToken = `-- name: ConsumePasswordResetToken :one
+DELETE FROM passwordResetTokens
+WHERE token_hash = ?
+RETURNING token_hash, uuid, expires_at
+`
+
+func (q *Queries) ConsumePasswordResetToken(ctx context.Context, tokenHash string) (PasswordResetToken, error) {
+	row := q.db.QueryRowContext(ctx, consumePasswordResetToken, tokenHash)
+	var i PasswordResetToken
+	err := row.Scan(&i.TokenHash, &i.Uuid, &i.ExpiresAt)
+	return i, err
+}
+
+const consumeWebAuthnSession = `-- name: ConsumeWebAuthnSession :one
+DELETE FROM webauthnSessions
+WHERE session_hash = ?
+RETURNING session_hash, uuid, ceremony, session_data, expires_at
+`
+
+func (q *Queries) ConsumeWebAuthnSession(ctx context.Context, sessionHash string) (WebauthnSession, error) {
+	row := q.db.QueryRowContext(ctx, consumeWebAuthnSession, sessionHash)
+	var i WebauthnSession
+	err := row.Scan(
+		&i.SessionHash,
+		&i.Uuid,
+		&i.Ceremony,
+		&i.SessionData,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const countLoginFailure = `-- name: CountLoginFailure :one
+INSERT INTO loginThrottles (throttle_key, failures, locked_until, expires_at)
+VALUES(?, 1, 0, ?)
+ON CONFLICT (throttle_key) DO UPDATE
+SET failures = CASE WHEN loginThrottles.expires_at > ? THEN loginThrottles.failures + 1 ELSE 1 END,
+    expires_at = MAX(loginThrottles.expires_at, excluded.expires_at)
+WHERE loginThrottles.locked_until <= ?
+RETURNING throttle_key, failures, locked_until, expires_at
+`
+
+type CountLoginFailureParams struct {
+	ThrottleKey string
+	ExpiresAt   int64
+	ExpiresAt_2 int64
+	LockedUntil int64
+}
+
+func (q *Queries) CountLoginFailure(ctx context.Context, arg CountLoginFailureParams) (LoginThrottle, error) {
+	row := q.db.QueryRowContext(ctx, countLoginFailure,
+		arg.ThrottleKey,
+		arg.ExpiresAt,
+		arg.ExpiresAt_2,
+		arg.LockedUntil,
+	)
+	var i LoginThrottle
+	err := row.Scan(
+		&i.ThrottleKey,
+		&i.Failures,
+		&i.LockedUntil,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const countRecoveryCodes = `-- name: CountRecoveryCodes :one
+SELECT COUNT(*) FROM recoveryCodes
+WHERE uuid = ?
+`
+
+func (q *Queries) CountRecoveryCodes(ctx context.Context, uuid string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countRecoveryCodes, uuid)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createAuthenticationData = `-- name: CreateAuthenticationData :one
 
 INSERT INTO authenticationData(uuid, hashed_password, salt)
-VALUES(?, ?, ?)
+VALUES(?, ?, '')
 RETURNING uuid, hashed_password, salt
 `
 
 type CreateAuthenticationDataParams struct {
 	Uuid           string
 	HashedPassword string
-	Salt           string
 }
 
 // -----------------------------------------------------------------------------
 // CREATE QUERIES
 func (q *Queries) CreateAuthenticationData(ctx context.Context, arg CreateAuthenticationDataParams) (AuthenticationDatum, error) {
-	row := q.db.QueryRowContext(ctx, createAuthenticationData, arg.Uuid, arg.HashedPassword, arg.Salt)
+	row := q.db.QueryRowContext(ctx, createAuthenticationData, arg.Uuid, arg.HashedPassword)
 	var i AuthenticationDatum
 	err := row.Scan(&i.Uuid, &i.HashedPassword, &i.Salt)
 	return i, err
 }
 
-const createUser = `-- name: CreateUser :one
-INSERT INTO users (uuid, username)
+const createAuthorizationCode = `-- name: CreateAuthorizationCode :exec
+INSERT INTO authorizationCodes (code_hash, client_id, uuid, redirect_uri, scope, nonce, code_challenge, expires_at)
+VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+type CreateAuthorizationCodeParams struct {
+	CodeHash      string
+	ClientID      string
+	Uuid          string
+	RedirectUri   string
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+	ExpiresAt     int64
+}
+
+func (q *Queries) CreateAuthorizationCode(ctx context.Context, arg CreateAuthorizationCodeParams) error {
+	_, err := q.db.ExecContext(ctx, createAuthorizationCode,
+		arg.CodeHash,
+		arg.ClientID,
+		arg.Uuid,
+		arg.RedirectUri,
+		arg.Scope,
+		arg.Nonce,
+		arg.CodeChallenge,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const createClient = `-- name: CreateClient :one
+INSERT INTO clients (client_id, client_secret_hash, redirect_uris, audience, token_lifetime)
+VALUES(?, ?, ?, ?, ?)
+RETURNING client_id, client_secret_hash, redirect_uris, audience, token_lifetime
+`
+
+type CreateClientParams struct {
+	ClientID         string
+	ClientSecretHash string
+	RedirectUris     string
+	Audience         string
+	TokenLifetime    int64
+}
+
+func (q *Queries) CreateClient(ctx context.Context, arg CreateClientParams) (Client, error) {
+	row := q.db.QueryRowContext(ctx, createClient,
+		arg.ClientID,
+		arg.ClientSecretHash,
+		arg.RedirectUris,
+		arg.Audience,
+		arg.TokenLifetime,
+	)
+	var i Client
+	err := row.Scan(
+		&i.ClientID,
+		&i.ClientSecretHash,
+		&i.RedirectUris,
+		&i.Audience,
+		&i.TokenLifetime,
+	)
+	return i, err
+}
+
+const createEmailVerificationToken = `-- name: CreateEmailVerificationToken :exec
+INSERT INTO emailVerificationTokens (token_hash, uuid, email, expires_at)
+VALUES(?, ?, ?, ?)
+`
+
+type CreateEmailVerificationTokenParams struct {
+	TokenHash string
+	Uuid      string
+	Email     string
+	ExpiresAt int64
+}
+
+func (q *Queries) CreateEmailVerificationToken(ctx context.Context, arg CreateEmailVerificationTokenParams) error {
+	_, err := q.db.ExecContext(ctx, createEmailVerificationToken,
+		arg.TokenHash,
+		arg.Uuid,
+		arg.Email,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const createMFAChallenge = `-- name: CreateMFAChallenge :exec
+INSERT INTO mfaChallenges (challenge_hash, uuid, expires_at)
+VALUES(?, ?, ?)
+`
+
+type CreateMFAChallengeParams struct {
+	ChallengeHash string
+	Uuid          string
+	ExpiresAt     int64
+}
+
+func (q *Queries) CreateMFAChallenge(ctx context.Context, arg CreateMFAChallengeParams) error {
+	_, err := q.db.ExecContext(ctx, createMFAChallenge, arg.ChallengeHash, arg.Uuid, arg.ExpiresAt)
+	return err
+}
+
+const createPasswordResetToken = `-- name: CreatePasswordResetToken :exec
+INSERT INTO passwordResetTokens (token_hash, uuid, expires_at)
+VALUES(?, ?, ?)
+`
+
+type CreatePasswordResetTokenParams struct {
+	TokenHash string
+	Uuid      string
+	ExpiresAt int64
+}
+
+func (q *Queries) CreatePasswordResetToken(ctx context.Context, arg CreatePasswordResetTokenParams) error {
+	_, err := q.db.ExecContext(ctx, createPasswordResetToken, arg.TokenHash, arg.Uuid, arg.ExpiresAt)
+	return err
+}
+
+const createRecoveryCode = `-- name: CreateRecoveryCode :exec
+INSERT INTO recoveryCodes (uuid, code_index, hashed_code)
+VALUES(?, ?, ?)
+`
+
+type CreateRecoveryCodeParams struct {
+	Uuid       string
+	CodeIndex  int64
+	HashedCode string
+}
+
+func (q *Queries) CreateRecoveryCode(ctx context.Context, arg CreateRecoveryCodeParams) error {
+	_, err := q.db.ExecContext(ctx, createRecoveryCode, arg.Uuid, arg.CodeIndex, arg.HashedCode)
+	return err
+}
+
+const createRefreshToken = `-- name: CreateRefreshToken :one
+INSERT INTO refreshTokens (token_hash, family_id, uuid, expires_at, client_id, scope)
+VALUES(?, ?, ?, ?, ?, ?)
+RETURNING token_hash, family_id, uuid, expires_at, used, revoked, client_id, scope
+`
+
+type CreateRefreshTokenParams struct {
+	TokenHash string
+	FamilyID  string
+	Uuid      string
+	ExpiresAt int64
+	ClientID  string
+	Scope     string
+}
+
+func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, createRefreshToken,
+		arg.TokenHash,
+		arg.FamilyID,
+		arg.Uuid,
+		arg.ExpiresAt,
+		arg.ClientID,
+		arg.Scope,
+	)
+	var i RefreshToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.FamilyID,
+		&i.Uuid,
+		&i.ExpiresAt,
+		&i.Used,
+		&i.Revoked,
+		&i.ClientID,
+		&i.Scope,
+	)
+	return i, err
+}
+
+const createRevokedToken = `-- name: CreateRevokedToken :exec
+INSERT INTO revokedTokens (token_id, expires_at)
 VALUES(?, ?)
-RETURNING uuid, username
+ON CONFLICT (token_id) DO NOTHING
+`
+
+type CreateRevokedTokenParams struct {
+	TokenID   string
+	ExpiresAt int64
+}
+
+func (q *Queries) CreateRevokedToken(ctx context.Context, arg CreateRevokedTokenParams) error {
+	_, err := q.db.ExecContext(ctx, createRevokedToken, arg.TokenID, arg.ExpiresAt)
+	return err
+}
+
+const createSession = `-- name: CreateSession :exec
+INSERT INTO sessions (session_hash, uuid, created_at, last_seen_at, expires_at, client_id, scope, family_id)
+VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+type CreateSessionParams struct {
+	SessionHash string
+	Uuid        string
+	CreatedAt   int64
+	LastSeenAt  int64
+	ExpiresAt   int64
+	ClientID    string
+	Scope       string
+	FamilyID    string
+}
+
+func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) error {
+	_, err := q.db.ExecContext(ctx, createSession,
+		arg.SessionHash,
+		arg.Uuid,
+		arg.CreatedAt,
+		arg.LastSeenAt,
+		arg.ExpiresAt,
+		arg.ClientID,
+		arg.Scope,
+		arg.FamilyID,
+	)
+	return err
+}
+
+const createTOTPSecret = `-- name: CreateTOTPSecret :execrows
+INSERT INTO totpSecrets (uuid, encrypted_secret)
+VALUES(?, ?)
+ON CONFLICT (uuid) DO UPDATE
+SET encrypted_secret = excluded.encrypted_secret, last_used_step = 0
+WHERE totpSecrets.enabled = FALSE
+`
+
+type CreateTOTPSecretParams struct {
+	Uuid            string
+	EncryptedSecret string
+}
+
+func (q *Queries) CreateTOTPSecret(ctx context.Context, arg CreateTOTPSecretParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, createTOTPSecret, arg.Uuid, arg.EncryptedSecret)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const createUser = `-- name: CreateUser :one
+INSERT INTO users (uuid, username, email)
+VALUES(?, ?, ?)
+RETURNING uuid, username, email, email_verified
 `
 
 type CreateUserParams struct {
 	Uuid     string
 	Username string
+	Email    sql.NullString
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.Uuid, arg.Username)
+	row := q.db.QueryRowContext(ctx, createUser, arg.Uuid, arg.Username, arg.Email)
 	var i User
-	err := row.Scan(&i.Uuid, &i.Username)
+	err := row.Scan(
+		&i.Uuid,
+		&i.Username,
+		&i.Email,
+		&i.EmailVerified,
+	)
 	return i, err
 }
 
+const createUserGroup = `-- name: CreateUserGroup :exec
+INSERT INTO userGroups (uuid, group_name)
+VALUES(?, ?)
+ON CONFLICT (uuid, group_name) DO NOTHING
+`
+
+type CreateUserGroupParams struct {
+	Uuid      string
+	GroupName string
+}
+
+func (q *Queries) CreateUserGroup(ctx context.Context, arg CreateUserGroupParams) error {
+	_, err := q.db.ExecContext(ctx, createUserGroup, arg.Uuid, arg.GroupName)
+	return err
+}
+
+const createUserRole = `-- name: CreateUserRole :exec
+INSERT INTO userRoles (uuid, role)
+VALUES(?, ?)
+ON CONFLICT (uuid, role) DO NOTHING
+`
+
+type CreateUserRoleParams struct {
+	Uuid string
+	Role string
+}
+
+func (q *Queries) CreateUserRole(ctx context.Context, arg CreateUserRoleParams) error {
+	_, err := q.db.ExecContext(ctx, createUserRole, arg.Uuid, arg.Role)
+	return err
+}
+
+const createWebAuthnCredential = `-- name: CreateWebAuthnCredential :exec
+INSERT INTO webauthnCredentials (credential_id, uuid, name, credential, created_at)
+VALUES(?, ?, ?, ?, ?)
+`
+
+type CreateWebAuthnCredentialParams struct {
+	CredentialID string
+	Uuid         string
+	Name         string
+	Credential   string
+	CreatedAt    int64
+}
+
+func (q *Queries) CreateWebAuthnCredential(ctx context.Context, arg CreateWebAuthnCredentialParams) error {
+	_, err := q.db.ExecContext(ctx, createWebAuthnCredential,
+		arg.CredentialID,
+		arg.Uuid,
+		arg.Name,
+		arg.Credential,
+		arg.CreatedAt,
+	)
+	return err
+}
+
+const createWebAuthnSession = `-- name: CreateWebAuthnSession :exec
+INSERT INTO webauthnSessions (session_hash, uuid, ceremony, session_data, expires_at)
+VALUES(?, ?, ?, ?, ?)
+`
+
+type CreateWebAuthnSessionParams struct {
+	SessionHash string
+	Uuid        string
+	Ceremony    string
+	SessionData string
+	ExpiresAt   int64
+}
+
+func (q *Queries) CreateWebAuthnSession(ctx context.Context, arg CreateWebAuthnSessionParams) error {
+	_, err := q.db.ExecContext(ctx, createWebAuthnSession,
+		arg.SessionHash,
+		arg.Uuid,
+		arg.Ceremony,
+		arg.SessionData,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
 const deleteAuthData = `-- name: DeleteAuthData :exec
 
 DELETE FROM authenticationData
@@ -62,6 +504,248 @@ func (q *Queries) DeleteAuthData(ctx context.Context, uuid string) error {
 	return err
 }
 
+const deleteAuthorizationCodesByUser = `-- name: DeleteAuthorizationCodesByUser :exec
+DELETE FROM authorizationCodes
+WHERE uuid = ?
+`
+
+func (q *Queries) DeleteAuthorizationCodesByUser(ctx context.Context, uuid string) error {
+	_, err := q.db.ExecContext(ctx, deleteAuthorizationCodesByUser, uuid)
+	return err
+}
+
+const deleteEmailVerificationTokensByUser = `-- name: DeleteEmailVerificationTokensByUser :exec
+DELETE FROM emailVerificationTokens
+WHERE uuid = ?
+`
+
+func (q *Queries) DeleteEmailVerificationTokensByUser(ctx context.Context, uuid string) error {
+	_, err := q.db.ExecContext(ctx, deleteEmailVerificationTokensByUser, uuid)
+	return err
+}
+
+const deleteExpiredAuthorizationCodes = `-- name: DeleteExpiredAuthorizationCodes :exec
+DELETE FROM authorizationCodes
+WHERE expires_at < ?
+`
+
+func (q *Queries) DeleteExpiredAuthorizationCodes(ctx context.Context, expiresAt int64) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredAuthorizationCodes, expiresAt)
+	return err
+}
+
+const deleteExpiredEmailVerificationTokens = `-- name: DeleteExpiredEmailVerificationTokens :exec
+DELETE FROM emailVerificationTokens
+WHERE expires_at < ?
+`
+
+func (q *Queries) DeleteExpiredEmailVerificationTokens(ctx context.Context, expiresAt int64) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredEmailVerificationTokens, expiresAt)
+	return err
+}
+
+const deleteExpiredLoginThrottles = `-- name: DeleteExpiredLoginThrottles :exec
+DELETE FROM loginThrottles
+WHERE expires_at < ?
+`
+
+func (q *Queries) DeleteExpiredLoginThrottles(ctx context.Context, expiresAt int64) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredLoginThrottles, expiresAt)
+	return err
+}
+
+const deleteExpiredMFAChallenges = `-- name: DeleteExpiredMFAChallenges :exec
+DELETE FROM mfaChallenges
+WHERE expires_at < ?
+`
+
+func (q *Queries) DeleteExpiredMFAChallenges(ctx context.Context, expiresAt int64) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredMFAChallenges, expiresAt)
+	return err
+}
+
+const deleteExpiredPasswordResetTokens = `-- name: DeleteExpiredPasswordResetTokens :exec
+DELETE FROM passwordResetTokens
+WHERE expires_at < ?
+`
+
+func (q *Queries) DeleteExpiredPasswordResetTokens(ctx context.Context, expiresAt int64) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredPasswordResetTokens, expiresAt)
+	return err
+}
+
+const deleteExpiredRefreshTokens = `-- name: DeleteExpiredRefreshTokens :exec
+DELETE FROM refreshTokens
+WHERE expires_at < ?
+`
+
+func (q *Queries) DeleteExpiredRefreshTokens(ctx context.Context, expiresAt int64) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredRefreshTokens, expiresAt)
+	return err
+}
+
+const deleteExpiredRevokedTokens = `-- name: DeleteExpiredRevokedTokens :exec
+DELETE FROM revokedTokens
+WHERE expires_at < ?
+`
+
+func (q *Queries) DeleteExpiredRevokedTokens(ctx context.Context, expiresAt int64) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredRevokedTokens, expiresAt)
+	return err
+}
+
+const deleteExpiredSessions = `-- name: DeleteExpiredSessions :exec
+DELETE FROM sessions
+WHERE expires_at < ?
+`
+
+func (q *Queries) DeleteExpiredSessions(ctx context.Context, expiresAt int64) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredSessions, expiresAt)
+	return err
+}
+
+const deleteExpiredWebAuthnSessions = `-- name: DeleteExpiredWebAuthnSessions :exec
+DELETE FROM webauthnSessions
+WHERE expires_at < ?
+`
+
+func (q *Queries) DeleteExpiredWebAuthnSessions(ctx context.Context, expiresAt int64) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredWebAuthnSessions, expiresAt)
+	return err
+}
+
+const deleteLoginThrottle = `-- name: DeleteLoginThrottle :execrows
+DELETE FROM loginThrottles
+WHERE throttle_key = ?
+`
+
+func (q *Queries) DeleteLoginThrottle(ctx context.Context, throttleKey string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteLoginThrottle, throttleKey)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteMFAChallenge = `-- name: DeleteMFAChallenge :execrows
+DELETE FROM mfaChallenges
+WHERE challenge_hash = ? AND uuid = ?
+`
+
+type DeleteMFAChallengeParams struct {
+	ChallengeHash string
+	Uuid          string
+}
+
+func (q *Queries) DeleteMFAChallenge(ctx context.Context, arg DeleteMFAChallengeParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteMFAChallenge, arg.ChallengeHash, arg.Uuid)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteMFAChallengesByUser = `-- name: DeleteMFAChallengesByUser :exec
+DELETE FROM mfaChallenges
+WHERE uuid = ?
+`
+
+func (q *Queries) DeleteMFAChallengesByUser(ctx context.Context, uuid string) error {
+	_, err := q.db.ExecContext(ctx, deleteMFAChallengesByUser, uuid)
+	return err
+}
+
+const deletePasswordResetTokensByUser = `-- name: DeletePasswordResetTokensByUser :exec
+DELETE FROM passwordResetTokens
+WHERE uuid = ?
+`
+
+func (q *Queries) DeletePasswordResetTokensByUser(ctx context.Context, uuid string) error {
+	_, err := q.db.ExecContext(ctx, deletePasswordResetTokensByUser, uuid)
+	return err
+}
+
+const deleteRecoveryCode = `-- name: DeleteRecoveryCode :execrows
+DELETE FROM recoveryCodes
+WHERE uuid = ? AND code_index = ?
+`
+
+type DeleteRecoveryCodeParams struct {
+	Uuid      string
+	CodeIndex int64
+}
+
+func (q *Queries) DeleteRecoveryCode(ctx context.Context, arg DeleteRecoveryCodeParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteRecoveryCode, arg.Uuid, arg.CodeIndex)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteRecoveryCodesByUser = `-- name: DeleteRecoveryCodesByUser :exec
+DELETE FROM recoveryCodes
+WHERE uuid = ?
+`
+
+func (q *Queries) DeleteRecoveryCodesByUser(ctx context.Context, uuid string) error {
+	_, err := q.db.ExecContext(ctx, deleteRecoveryCodesByUser, uuid)
+	return err
+}
+
+const deleteRefreshTokensByUser = `-- name: DeleteRefreshTokensByUser :exec
+DELETE FROM refreshTokens
+WHERE uuid = ?
+`
+
+func (q *Queries) DeleteRefreshTokensByUser(ctx context.Context, uuid string) error {
+	_, err := q.db.ExecContext(ctx, deleteRefreshTokensByUser, uuid)
+	return err
+}
+
+const deleteSession = `-- name: DeleteSession :exec
+DELETE FROM sessions
+WHERE session_hash = ?
+`
+
+func (q *Queries) DeleteSession(ctx context.Context, sessionHash string) error {
+	_, err := q.db.ExecContext(ctx, deleteSession, sessionHash)
+	return err
+}
+
+const deleteSessionsByFamily = `-- name: DeleteSessionsByFamily :exec
+DELETE FROM sessions
+WHERE family_id = ?
+`
+
+func (q *Queries) DeleteSessionsByFamily(ctx context.Context, familyID string) error {
+	_, err := q.db.ExecContext(ctx, deleteSessionsByFamily, familyID)
+	return err
+}
+
+const deleteSessionsByUser = `-- name: DeleteSessionsByUser :exec
+DELETE FROM sessions
+WHERE uuid = ?
+`
+
+func (q *Queries) DeleteSessionsByUser(ctx context.Context, uuid string) error {
+	_, err := q.db.ExecContext(ctx, deleteSessionsByUser, uuid)
+	return err
+}
+
+const deleteTOTPSecret = `-- name: DeleteTOTPSecret :execrows
+DELETE FROM totpSecrets
+WHERE uuid = ?
+`
+
+func (q *Queries) DeleteTOTPSecret(ctx context.Context, uuid string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteTOTPSecret, uuid)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const deleteUser = `-- name: DeleteUser :exec
 DELETE FROM users
 WHERE uuid = ?
@@ -72,6 +756,124 @@ func (q *Queries) DeleteUser(ctx context.Context, uuid string) error {
 	return err
 }
 
+const deleteUserGroup = `-- name: DeleteUserGroup :exec
+DELETE FROM userGroups
+WHERE uuid = ? AND group_name = ?
+`
+
+type DeleteUserGroupParams struct {
+	Uuid      string
+	GroupName string
+}
+
+func (q *Queries) DeleteUserGroup(ctx context.Context, arg DeleteUserGroupParams) error {
+	_, err := q.db.ExecContext(ctx, deleteUserGroup, arg.Uuid, arg.GroupName)
+	return err
+}
+
+const deleteUserGroupsByUser = `-- name: DeleteUserGroupsByUser :exec
+DELETE FROM userGroups
+WHERE uuid = ?
+`
+
+func (q *Queries) DeleteUserGroupsByUser(ctx context.Context, uuid string) error {
+	_, err := q.db.ExecContext(ctx, deleteUserGroupsByUser, uuid)
+	return err
+}
+
+const deleteUserRole = `-- name: DeleteUserRole :exec
+DELETE FROM userRoles
+WHERE uuid = ? AND role = ?
+`
+
+type DeleteUserRoleParams struct {
+	Uuid string
+	Role string
+}
+
+func (q *Queries) DeleteUserRole(ctx context.Context, arg DeleteUserRoleParams) error {
+	_, err := q.db.ExecContext(ctx, deleteUserRole, arg.Uuid, arg.Role)
+	return err
+}
+
+const deleteUserRolesByUser = `-- name: DeleteUserRolesByUser :exec
+DELETE FROM userRoles
+WHERE uuid = ?
+`
+
+func (q *Queries) DeleteUserRolesByUser(ctx context.Context, uuid string) error {
+	_, err := q.db.ExecContext(ctx, deleteUserRolesByUser, uuid)
+	return err
+}
+
+const deleteWebAuthnCredential = `-- name: DeleteWebAuthnCredential :execrows
+DELETE FROM webauthnCredentials
+WHERE credential_id = ? AND uuid = ?
+`
+
+type DeleteWebAuthnCredentialParams struct {
+	CredentialID string
+	Uuid         string
+}
+
+func (q *Queries) DeleteWebAuthnCredential(ctx context.Context, arg DeleteWebAuthnCredentialParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteWebAuthnCredential, arg.CredentialID, arg.Uuid)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteWebAuthnCredentialsByUser = `-- name: DeleteWebAuthnCredentialsByUser :exec
+DELETE FROM webauthnCredentials
+WHERE uuid = ?
+`
+
+func (q *Queries) DeleteWebAuthnCredentialsByUser(ctx context.Context, uuid string) error {
+	_, err := q.db.ExecContext(ctx, deleteWebAuthnCredentialsByUser, uuid)
+	return err
+}
+
+const deleteWebAuthnSessionsByUser = `-- name: DeleteWebAuthnSessionsByUser :exec
+DELETE FROM webauthnSessions
+WHERE uuid = ?
+`
+
+func (q *Queries) DeleteWebAuthnSessionsByUser(ctx context.Context, uuid string) error {
+	_, err := q.db.ExecContext(ctx, deleteWebAuthnSessionsByUser, uuid)
+	return err
+}
+
+const enableTOTPSecret = `-- name: EnableTOTPSecret :execrows
+UPDATE totpSecrets
+SET enabled = TRUE, last_used_step = ?
+WHERE uuid = ? AND enabled = FALSE
+`
+
+type EnableTOTPSecretParams struct {
+	LastUsedStep int64
+	Uuid         string
+}
+
+func (q *Queries) EnableTOTPSecret(ctx context.Context, arg EnableTOTPSecretParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, enableTOTPSecret, arg.LastUsedStep, arg.Uuid)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const forgetLoginFailure = `-- name: ForgetLoginFailure :exec
+UPDATE loginThrottles
+SET failures = failures - 1
+WHERE throttle_key = ? AND failures > 0
+`
+
+func (q *Queries) ForgetLoginFailure(ctx context.Context, throttleKey string) error {
+	_, err := q.db.ExecContext(ctx, forgetLoginFailure, throttleKey)
+	return err
+}
+
 const getAuthData = `-- name: GetAuthData :one
 
 SELECT uuid, hashed_password, salt FROM authenticationData
@@ -88,46 +890,502 @@ func (q *Queries) GetAuthData(ctx context.Context, uuid string) (AuthenticationD
 	return i, err
 }
 
+const getClient = `-- name: GetClient :one
+SELECT client_id, client_secret_hash, redirect_uris, audience, token_lifetime FROM clients
+WHERE client_id = ? LIMIT 1
+`
+
+func (q *Queries) GetClient(ctx context.Context, clientID string) (Client, error) {
+	row := q.db.QueryRowContext(ctx, getClient, clientID)
+	var i Client
+	err := row.Scan(
+		&i.ClientID,
+		&i.ClientSecretHash,
+		&i.RedirectUris,
+		&i.Audience,
+		&i.TokenLifetime,
+	)
+	return i, err
+}
+
+const getLoginThrottle = `-- name: GetLoginThrottle :one
+SELECT throttle_key, failures, locked_until, expires_at FROM loginThrottles
+WHERE throttle_key = ?
+`
+
+func (q *Queries) GetLoginThrottle(ctx context.Context, throttleKey string) (LoginThrottle, error) {
+	row := q.db.QueryRowContext(ctx, getLoginThrottle, throttleKey)
+	var i LoginThrottle
+	err := row.Scan(
+		&i.ThrottleKey,
+		&i.Failures,
+		&i.LockedUntil,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const getMFAChallenge = `-- name: GetMFAChallenge :one
+SELECT challenge_hash, uuid, expires_at, attempts FROM mfaChallenges
+WHERE challenge_hash = ? LIMIT 1
+`
+
+func (q *Queries) GetMFAChallenge(ctx context.Context, challengeHash string) (MfaChallenge, error) {
+	row := q.db.QueryRowContext(ctx, getMFAChallenge, challengeHash)
+	var i MfaChallenge
+	err := row.Scan(
+		&i.ChallengeHash,
+		&i.Uuid,
+		&i.ExpiresAt,
+		&i.Attempts,
+	)
+	return i, err
+}
+
+const getPasswordResetToken = `-- name: GetPasswordResetToken :one
+SELECT token_hash, uuid, expires_at FROM passwordResetTokens
+WHERE token_hash = ? LIMIT 1
+`
+
+func (q *Queries) GetPasswordResetToken(ctx context.Context, tokenHash string) (PasswordResetToken, error) {
+	row := q.db.QueryRowContext(ctx, getPasswordResetToken, tokenHash)
+	var i PasswordResetToken
+	err := row.Scan(&i.TokenHash, &i.Uuid, &i.ExpiresAt)
+	return i, err
+}
+
+const getRecoveryCodesByUser = `-- name: GetRecoveryCodesByUser :many
+SELECT uuid, code_index, hashed_code FROM recoveryCodes
+WHERE uuid = ?
+ORDER BY code_index
+`
+
+func (q *Queries) GetRecoveryCodesByUser(ctx context.Context, uuid string) ([]RecoveryCode, error) {
+	rows, err := q.db.QueryContext(ctx, getRecoveryCodesByUser, uuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RecoveryCode
+	for rows.Next() {
+		var i RecoveryCode
+		if err := rows.Scan(
+			&i.Uuid,
+			&i.CodeIndex,
+			&i.HashedCode,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRefreshToken = `-- name: GetRefreshToken :one
+SELECT token_hash, family_id, uuid, expires_at, used, revoked, client_id, scope FROM refreshTokens
+WHERE token_hash = ? LIMIT 1
+`
+
+func (q *Queries) GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshToken, tokenHash)
+	var i RefreshToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.FamilyID,
+		&i.Uuid,
+		&i.ExpiresAt,
+		&i.Used,
+		&i.Revoked,
+		&i.ClientID,
+		&i.Scope,
+	)
+	return i, err
+}
+
+const getSession = `-- name: GetSession :one
+SELECT session_hash, uuid, created_at, last_seen_at, expires_at, client_id, scope, family_id FROM sessions
+WHERE session_hash = ? LIMIT 1
+`
+
+func (q *Queries) GetSession(ctx context.Context, sessionHash string) (Session, error) {
+	row := q.db.QueryRowContext(ctx, getSession, sessionHash)
+	var i Session
+	err := row.Scan(
+		&i.SessionHash,
+		&i.Uuid,
+		&i.CreatedAt,
+		&i.LastSeenAt,
+		&i.ExpiresAt,
+		&i.ClientID,
+		&i.Scope,
+		&i.FamilyID,
+	)
+	return i, err
+}
+
+const getTOTPSecret = `-- name: GetTOTPSecret :one
+SELECT uuid, encrypted_secret, enabled, last_used_step FROM totpSecrets
+WHERE uuid = ? LIMIT 1
+`
+
+func (q *Queries) GetTOTPSecret(ctx context.Context, uuid string) (TotpSecret, error) {
+	row := q.db.QueryRowContext(ctx, getTOTPSecret, uuid)
+	var i TotpSecret
+	err := row.Scan(
+		&i.Uuid,
+		&i.EncryptedSecret,
+		&i.Enabled,
+		&i.LastUsedStep,
+	)
+	return i, err
+}
+
 const getUserByUUID = `-- name: GetUserByUUID :one
-SELECT uuid, username FROM users
+SELECT uuid, username, email, email_verified FROM users
 WHERE uuid = ? LIMIT 1
 `
 
 func (q *Queries) GetUserByUUID(ctx context.Context, uuid string) (User, error) {
 	row := q.db.QueryRowContext(ctx, getUserByUUID, uuid)
 	var i User
-	err := row.Scan(&i.Uuid, &i.Username)
+	err := row.Scan(
+		&i.Uuid,
+		&i.Username,
+		&i.Email,
+		&i.EmailVerified,
+	)
 	return i, err
 }
 
 const getUserByUsername = `-- name: GetUserByUsername :one
-SELECT uuid, username FROM users
+SELECT uuid, username, email, email_verified FROM users
 WHERE username = ? LIMIT 1
 `
 
 func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User, error) {
 	row := q.db.QueryRowContext(ctx, getUserByUsername, username)
 	var i User
-	err := row.Scan(&i.Uuid, &i.Username)
+	err := row.Scan(
+		&i.Uuid,
+		&i.Username,
+		&i.Email,
+		&i.EmailVerified,
+	)
 	return i, err
 }
 
+const getUserGroups = `-- name: GetUserGroups :many
+SELECT group_name FROM userGroups
+WHERE uuid = ?
+ORDER BY group_name
+`
+
+func (q *Queries) GetUserGroups(ctx context.Context, uuid string) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, getUserGroups, uuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var groupName string
+		if err := rows.Scan(&groupName); err != nil {
+			return nil, err
+		}
+		items = append(items, groupName)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserRoles = `-- name: GetUserRoles :many
+SELECT role FROM userRoles
+WHERE uuid = ?
+ORDER BY role
+`
+
+func (q *Queries) GetUserRoles(ctx context.Context, uuid string) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, getUserRoles, uuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		items = append(items, role)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWebAuthnCredentialsByUser = `-- name: GetWebAuthnCredentialsByUser :many
+SELECT credential_id, uuid, name, credential, created_at, last_used_at FROM webauthnCredentials
+WHERE uuid = ?
+ORDER BY created_at
+`
+
+func (q *Queries) GetWebAuthnCredentialsByUser(ctx context.Context, uuid string) ([]WebauthnCredential, error) {
+	rows, err := q.db.QueryContext(ctx, getWebAuthnCredentialsByUser, uuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebauthnCredential
+	for rows.Next() {
+		var i WebauthnCredential
+		if err := rows.Scan(
+			&i.CredentialID,
+			&i.Uuid,
+			&i.Name,
+			&i.Credential,
+			&i.CreatedAt,
+			&i.LastUsedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const isTokenRevoked = `-- name: IsTokenRevoked :one
+SELECT EXISTS(
+    SELECT 1 FROM revokedTokens
+    WHERE token_id = ?
+)
+`
+
+func (q *Queries) IsTokenRevoked(ctx context.Context, tokenID string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, isTokenRevoked, tokenID)
+	var column_1 int64
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const markRefreshTokenUsed = `-- name: MarkRefreshTokenUsed :execrows
+UPDATE refreshTokens
+SET used = TRUE
+WHERE token_hash = ? AND used = FALSE
+`
+
+func (q *Queries) MarkRefreshTokenUsed(ctx context.Context, tokenHash string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markRefreshTokenUsed, tokenHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rehashAuthenticationData = `-- name: RehashAuthenticationData :execrows
+UPDATE authenticationData
+SET hashed_password = ?, salt = ''
+WHERE uuid = ? AND hashed_password = ?
+`
+
+type RehashAuthenticationDataParams struct {
+	HashedPassword   string
+	Uuid             string
+	HashedPassword_2 string
+}
+
+func (q *Queries) RehashAuthenticationData(ctx context.Context, arg RehashAuthenticationDataParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rehashAuthenticationData, arg.HashedPassword, arg.Uuid, arg.HashedPassword_2)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :exec
+UPDATE refreshTokens
+SET revoked = TRUE
+WHERE family_id = ?
+`
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshTokenFamily, familyID)
+	return err
+}
+
+const setLoginThrottleLock = `-- name: SetLoginThrottleLock :exec
+UPDATE loginThrottles
+SET locked_until = MAX(locked_until, ?), expires_at = MAX(expires_at, ?)
+WHERE throttle_key = ?
+`
+
+type SetLoginThrottleLockParams struct {
+	LockedUntil int64
+	ExpiresAt   int64
+	ThrottleKey string
+}
+
+func (q *Queries) SetLoginThrottleLock(ctx context.Context, arg SetLoginThrottleLockParams) error {
+	_, err := q.db.ExecContext(ctx, setLoginThrottleLock, arg.LockedUntil, arg.ExpiresAt, arg.ThrottleKey)
+	return err
+}
+
 const updateAuthenticationData = `-- name: UpdateAuthenticationData :exec
 
 UPDATE authenticationData
-SET hashed_password = ?, salt = ?
+SET hashed_password = ?, salt = ''
 WHERE uuid = ?
 `
 
 type UpdateAuthenticationDataParams struct {
 	HashedPassword string
-	Salt           string
 	Uuid           string
 }
 
 // -----------------------------------------------------------------------------
 // UPDATE QUERIES
 func (q *Queries) UpdateAuthenticationData(ctx context.Context, arg UpdateAuthenticationDataParams) error {
-	_, err := q.db.ExecContext(ctx, updateAuthenticationData, arg.HashedPassword, arg.Salt, arg.Uuid)
+	_, err := q.db.ExecContext(ctx, updateAuthenticationData, arg.HashedPassword, arg.Uuid)
+	return err
+}
+
+const updateSessionLastSeen = `-- name: UpdateSessionLastSeen :exec
+UPDATE sessions
+SET last_seen_at = ?
+WHERE session_hash = ?
+`
+
+type UpdateSessionLastSeenParams struct {
+	LastSeenAt  int64
+	SessionHash string
+}
+
+func (q *Queries) UpdateSessionLastSeen(ctx context.Context, arg UpdateSessionLastSeenParams) error {
+	_, err := q.db.ExecContext(ctx, updateSessionLastSeen, arg.LastSeenAt, arg.SessionHash)
+	return err
+}
+
+const updateTOTPLastUsedStep = `-- name: UpdateTOTPLastUsedStep :execrows
+UPDATE totpSecrets
+SET last_used_step = ?
+WHERE uuid = ? AND enabled = TRUE AND last_used_step < ?
+`
+
+type UpdateTOTPLastUsedStepParams struct {
+	LastUsedStep   int64
+	Uuid           string
+	LastUsedStep_2 int64
+}
+
+func (q *Queries) UpdateTOTPLastUsedStep(ctx context.Context, arg UpdateTOTPLastUsedStepParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateTOTPLastUsedStep, arg.LastUsedStep, arg.Uuid, arg.LastUsedStep_2)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateUserEmail = `-- name: UpdateUserEmail :exec
+UPDATE users
+SET email = ?, email_verified = FALSE
+WHERE uuid = ?
+`
+
+type UpdateUserEmailParams struct {
+	Email sql.NullString
+	Uuid  string
+}
+
+func (q *Queries) UpdateUserEmail(ctx context.Context, arg UpdateUserEmailParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserEmail, arg.Email, arg.Uuid)
 	return err
 }
+
+const updateWebAuthnCredential = `-- name: UpdateWebAuthnCredential :execrows
+UPDATE webauthnCredentials
+SET credential = ?, last_used_at = ?
+WHERE credential_id = ? AND uuid = ?
+`
+
+type UpdateWebAuthnCredentialParams struct {
+	Credential   string
+	LastUsedAt   int64
+	CredentialID string
+	Uuid         string
+}
+
+func (q *Queries) UpdateWebAuthnCredential(ctx context.Context, arg UpdateWebAuthnCredentialParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateWebAuthnCredential,
+		arg.Credential,
+		arg.LastUsedAt,
+		arg.CredentialID,
+		arg.Uuid,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const useMFAChallengeAttempt = `-- name: UseMFAChallengeAttempt :one
+UPDATE mfaChallenges
+SET attempts = attempts + 1
+WHERE challenge_hash = ? AND attempts < ?
+RETURNING challenge_hash, uuid, expires_at, attempts
+`
+
+type UseMFAChallengeAttemptParams struct {
+	ChallengeHash string
+	Attempts      int64
+}
+
+func (q *Queries) UseMFAChallengeAttempt(ctx context.Context, arg UseMFAChallengeAttemptParams) (MfaChallenge, error) {
+	row := q.db.QueryRowContext(ctx, useMFAChallengeAttempt, arg.ChallengeHash, arg.Attempts)
+	var i MfaChallenge
+	err := row.Scan(
+		&i.ChallengeHash,
+		&i.Uuid,
+		&i.ExpiresAt,
+		&i.Attempts,
+	)
+	return i, err
+}
+
+const verifyUserEmail = `-- name: VerifyUserEmail :execrows
+UPDATE users
+SET email_verified = TRUE
+WHERE uuid = ? AND email = ?
+`
+
+type VerifyUserEmailParams struct {
+	Uuid  string
+	Email sql.NullString
+}
+
+func (q *Queries) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, verifyUserEmail, arg.Uuid, arg.Email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
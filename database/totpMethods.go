@@ -0,0 +1,364 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database/sqlc"
+	"github.com/hmcalister/AuthSSO/totp"
+)
+
+const (
+	// Each MFA challenge accepts at most this many codes, so a code cannot be guessed by trying every possibility.
+	maximumMFAChallengeAttempts int64 = 5
+)
+
+// Given a userID and the current password of that user, generate a new TOTP secret, returning the (plaintext) secret so it can be given to the user's authenticator app.
+// The secret is stored encrypted with the key from SetEncryptionKey.
+//
+// The secret is not enabled (and so not required to log in) until EnableTOTP is called with a code generated from it,
+// which shows the user has saved it. Until then, calling this again replaces the secret.
+//
+// Fails (and returns a non-nil error) if:
+// - No encryption key is set (ErrNoEncryptionKey)
+// - The user does not exist in the database (ErrOnFetchUserDoesNotExist)
+// - The password is incorrect (ErrIncorrectPassword)
+// - The user already has TOTP enabled (ErrTOTPAlreadyEnabled)
+// - The secret fails to be generated or encrypted
+// - The transaction to check the password and store the secret fails
+func (database *DatabaseManager) CreateTOTPSecret(ctx context.Context, userID string, password string) ([]byte, error) {
+	if database.encryptionKey == nil {
+		return nil, ErrNoEncryptionKey
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	encryptedSecret, err := encryptSecret(database.encryptionKey, secret, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := database.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// If anything fails, an early return is triggered (before tx.Commit is called) and tx.Rollback is called
+	defer tx.Rollback()
+
+	qtx := database.queries.WithTx(tx)
+	authDatum, err := qtx.GetAuthData(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOnFetchUserDoesNotExist
+		}
+		return nil, err
+	}
+
+	ok, err := database.checkPassword(authDatum, password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrIncorrectPassword
+	}
+
+	rowsAffected, err := qtx.CreateTOTPSecret(ctx, sqlc.CreateTOTPSecretParams{
+		Uuid:            userID,
+		EncryptedSecret: encryptedSecret,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// Enable the TOTP secret created by CreateTOTPSecret, given a code generated from it.
+// Once enabled, a code is required (through an MFA challenge) every time the user logs in.
+//
+// Fails (and returns a non-nil error) if:
+// - The user has no TOTP secret (ErrTOTPNotEnrolled)
+// - The user already has TOTP enabled (ErrTOTPAlreadyEnabled)
+// - The code is incorrect (ErrIncorrectTOTPCode)
+// - The secret cannot be decrypted, including if no encryption key is set (ErrNoEncryptionKey)
+func (database *DatabaseManager) EnableTOTP(ctx context.Context, userID string, code string) error {
+	storedSecret, err := database.getTOTPSecret(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if storedSecret.Enabled {
+		return ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := database.decryptTOTPSecret(storedSecret)
+	if err != nil {
+		return err
+	}
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return ErrIncorrectTOTPCode
+	}
+
+	// The code used to enable the secret is recorded, so it cannot be used again to log in
+	rowsAffected, err := database.queries.EnableTOTPSecret(ctx, sqlc.EnableTOTPSecretParams{
+		LastUsedStep: step,
+		Uuid:         userID,
+	})
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTOTPAlreadyEnabled
+	}
+	return nil
+}
+
+// Checks if a user has TOTP enabled, and so must complete an MFA challenge to log in. Returns true if TOTP is enabled.
+func (database *DatabaseManager) IsTOTPEnabled(ctx context.Context, userID string) (bool, error) {
+	storedSecret, err := database.getTOTPSecret(ctx, userID)
+	if err == ErrTOTPNotEnrolled {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return storedSecret.Enabled, nil
+}
+
+// Given a userID and a TOTP code, validate the code against the enabled TOTP secret of the user.
+// Returns true if the code is correct, and has not been used before.
+// Returns false otherwise.
+//
+// Each code is accepted at most once (even if two requests race), so a code seen by an attacker cannot be replayed.
+//
+// Fails (and returns a non-nil error) if:
+// - The user does not have TOTP enabled (ErrTOTPNotEnrolled)
+// - The secret cannot be decrypted, including if no encryption key is set (ErrNoEncryptionKey)
+func (database *DatabaseManager) ValidateTOTPCode(ctx context.Context, userID string, code string) (bool, error) {
+	storedSecret, err := database.getTOTPSecret(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if !storedSecret.Enabled {
+		return false, ErrTOTPNotEnrolled
+	}
+
+	secret, err := database.decryptTOTPSecret(storedSecret)
+	if err != nil {
+		return false, err
+	}
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	rowsAffected, err := database.queries.UpdateTOTPLastUsedStep(ctx, sqlc.UpdateTOTPLastUsedStepParams{
+		LastUsedStep:   step,
+		Uuid:           userID,
+		LastUsedStep_2: step,
+	})
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// Given a userID and the password of that user, remove the TOTP secret of the user, so codes are no longer required to log in.
+// The password is checked in the same transaction as the removal, so the password cannot be changed in between.
+//
+// Fails (and returns a non-nil error) if:
+// - The user does not exist in the database (ErrOnFetchUserDoesNotExist)
+// - The password is incorrect (ErrIncorrectPassword)
+// - The user has no TOTP secret (ErrTOTPNotEnrolled)
+// - The transaction to check the password and remove the secret fails
+func (database *DatabaseManager) DisableTOTP(ctx context.Context, userID string, password string) error {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+	// If anything fails, an early return is triggered (before tx.Commit is called) and tx.Rollback is called
+	defer tx.Rollback()
+
+	qtx := database.queries.WithTx(tx)
+	authDatum, err := qtx.GetAuthData(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrOnFetchUserDoesNotExist
+		}
+		return err
+	}
+
+	ok, err := database.checkPassword(authDatum, password)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrIncorrectPassword
+	}
+
+	rowsAffected, err := qtx.DeleteTOTPSecret(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTOTPNotEnrolled
+	}
+	err = qtx.DeleteMFAChallengesByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Create a new MFA challenge for a user, who has given their password but must still give a second factor (a TOTP code or passkey) to log in.
+// The returned (plaintext) challenge is handed to the client, only the hash is stored in the database.
+//
+// Fails (and returns a non-nil error) if:
+// - The challenge fails to be generated
+// - The challenge cannot be stored in the database
+func (database *DatabaseManager) CreateMFAChallenge(ctx context.Context, userID string, expiresAt time.Time) (string, error) {
+	challenge, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = database.queries.CreateMFAChallenge(ctx, sqlc.CreateMFAChallengeParams{
+		ChallengeHash: hashOpaqueToken(challenge),
+		Uuid:          userID,
+		ExpiresAt:     expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+// Complete an MFA challenge with a TOTP code, returning the userID of the user the challenge was created for.
+//
+// A correct code consumes the challenge, so each challenge can be completed at most once.
+// An incorrect code leaves the challenge to be attempted again, up to a maximum number of attempts.
+//
+// Fails (and returns a non-nil error) if:
+// - The challenge cannot be attempted (see CheckMFAChallenge)
+// - The code is incorrect, or has already been used (ErrIncorrectTOTPCode)
+// - The code cannot be validated (see ValidateTOTPCode)
+// - The challenge has already been completed by another request (ErrMFAChallengeInvalid)
+func (database *DatabaseManager) CompleteMFAChallenge(ctx context.Context, challenge string, code string) (string, error) {
+	userID, err := database.CheckMFAChallenge(ctx, challenge)
+	if err != nil {
+		return "", err
+	}
+
+	ok, err := database.ValidateTOTPCode(ctx, userID, code)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrIncorrectTOTPCode
+	}
+
+	err = database.ConsumeMFAChallenge(ctx, challenge, userID)
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// Check an MFA challenge can be attempted, returning the userID of the user the challenge was created for.
+// Each check uses one of the limited attempts of the challenge, whether or not the second factor is then given correctly.
+//
+// Fails (and returns a non-nil error) if:
+// - The challenge does not exist, has already been completed, or has no attempts left (ErrMFAChallengeInvalid)
+// - The challenge has expired (ErrMFAChallengeExpired)
+func (database *DatabaseManager) CheckMFAChallenge(ctx context.Context, challenge string) (string, error) {
+	// The attempt is counted before the second factor is checked, so concurrent requests cannot exceed the maximum
+	storedChallenge, err := database.queries.UseMFAChallengeAttempt(ctx, sqlc.UseMFAChallengeAttemptParams{
+		ChallengeHash: hashOpaqueToken(challenge),
+		Attempts:      maximumMFAChallengeAttempts,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrMFAChallengeInvalid
+		}
+		return "", err
+	}
+
+	if time.Now().Unix() >= storedChallenge.ExpiresAt {
+		return "", ErrMFAChallengeExpired
+	}
+
+	return storedChallenge.Uuid, nil
+}
+
+// Get the userID of the user an MFA challenge was created for, without using an attempt of the challenge,
+// so the attempt can be throttled (with the other logins of the user) before the second factor is checked.
+//
+// Fails (and returns a non-nil error) if:
+// - The challenge does not exist, has already been completed, or has no attempts left (ErrMFAChallengeInvalid)
+// - The challenge has expired (ErrMFAChallengeExpired)
+func (database *DatabaseManager) GetMFAChallengeUserID(ctx context.Context, challenge string) (string, error) {
+	storedChallenge, err := database.queries.GetMFAChallenge(ctx, hashOpaqueToken(challenge))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrMFAChallengeInvalid
+		}
+		return "", err
+	}
+
+	if storedChallenge.Attempts >= maximumMFAChallengeAttempts {
+		return "", ErrMFAChallengeInvalid
+	}
+	if time.Now().Unix() >= storedChallenge.ExpiresAt {
+		return "", ErrMFAChallengeExpired
+	}
+
+	return storedChallenge.Uuid, nil
+}
+
+// Consume an MFA challenge of a user once the second factor has been given correctly, so the challenge cannot be completed again.
+//
+// Fails (and returns a non-nil error) if:
+// - The challenge does not exist for the user, or has already been consumed (even if two requests race) (ErrMFAChallengeInvalid)
+func (database *DatabaseManager) ConsumeMFAChallenge(ctx context.Context, challenge string, userID string) error {
+	rowsAffected, err := database.queries.DeleteMFAChallenge(ctx, sqlc.DeleteMFAChallengeParams{
+		ChallengeHash: hashOpaqueToken(challenge),
+		Uuid:          userID,
+	})
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrMFAChallengeInvalid
+	}
+	return nil
+}
+
+// Get the stored TOTP secret of a user, returning ErrTOTPNotEnrolled if the user has none.
+func (database *DatabaseManager) getTOTPSecret(ctx context.Context, userID string) (sqlc.TotpSecret, error) {
+	storedSecret, err := database.queries.GetTOTPSecret(ctx, userID)
+	if err == sql.ErrNoRows {
+		return sqlc.TotpSecret{}, ErrTOTPNotEnrolled
+	}
+	return storedSecret, err
+}
+
+// Decrypt a stored TOTP secret with the key from SetEncryptionKey.
+func (database *DatabaseManager) decryptTOTPSecret(storedSecret sqlc.TotpSecret) ([]byte, error) {
+	if database.encryptionKey == nil {
+		return nil, ErrNoEncryptionKey
+	}
+
+	return decryptSecret(database.encryptionKey, storedSecret.EncryptedSecret, storedSecret.Uuid)
+}
@@ -0,0 +1,222 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+	"github.com/hmcalister/AuthSSO/totp"
+)
+
+// Register a user with an enabled TOTP secret, returning the userID and secret.
+// The code used to enable the secret is for the previous time step, so the current code is still unused.
+func registerTOTPUser(t *testing.T, username string) (string, []byte) {
+	ctx := context.Background()
+	databaseManager.RegisterNewUser(ctx, username, "Password123", "")
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, username)
+
+	secret, err := databaseManager.CreateTOTPSecret(ctx, userID, "Password123")
+	if err != nil {
+		t.Fatalf("Error while creating TOTP secret: %v", err)
+	}
+	err = databaseManager.EnableTOTP(ctx, userID, totp.GenerateCode(secret, totp.Step(time.Now())-1))
+	if err != nil {
+		t.Fatalf("Error while enabling TOTP: %v", err)
+	}
+
+	return userID, secret
+}
+
+func TestEnableTOTP(t *testing.T) {
+	ctx := context.Background()
+	databaseManager.RegisterNewUser(ctx, "enableTOTPUser", "Password123", "")
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "enableTOTPUser")
+
+	err := databaseManager.EnableTOTP(ctx, userID, "123456")
+	if err != database.ErrTOTPNotEnrolled {
+		t.Errorf("Enabling TOTP without a secret did not return ErrTOTPNotEnrolled: %v", err)
+	}
+
+	_, err = databaseManager.CreateTOTPSecret(ctx, userID, "WrongPassword")
+	if err != database.ErrIncorrectPassword {
+		t.Errorf("Creating TOTP secret with incorrect password did not return ErrIncorrectPassword: %v", err)
+	}
+
+	secret, _ := databaseManager.CreateTOTPSecret(ctx, userID, "Password123")
+	enabled, _ := databaseManager.IsTOTPEnabled(ctx, userID)
+	if enabled {
+		t.Errorf("TOTP enabled before confirmation with a code")
+	}
+
+	wrongCode := totp.GenerateCode(secret, totp.Step(time.Now())+10)
+	err = databaseManager.EnableTOTP(ctx, userID, wrongCode)
+	if err != database.ErrIncorrectTOTPCode {
+		t.Errorf("Enabling TOTP with incorrect code did not return ErrIncorrectTOTPCode: %v", err)
+	}
+
+	err = databaseManager.EnableTOTP(ctx, userID, totp.GenerateCode(secret, totp.Step(time.Now())))
+	if err != nil {
+		t.Fatalf("Error while enabling TOTP: %v", err)
+	}
+	enabled, _ = databaseManager.IsTOTPEnabled(ctx, userID)
+	if !enabled {
+		t.Errorf("TOTP not enabled after confirmation with a code")
+	}
+
+	_, err = databaseManager.CreateTOTPSecret(ctx, userID, "Password123")
+	if err != database.ErrTOTPAlreadyEnabled {
+		t.Errorf("Creating TOTP secret while enabled did not return ErrTOTPAlreadyEnabled: %v", err)
+	}
+}
+
+func TestValidateTOTPCode(t *testing.T) {
+	ctx := context.Background()
+	userID, secret := registerTOTPUser(t, "validateTOTPUser")
+	code := totp.GenerateCode(secret, totp.Step(time.Now()))
+
+	ok, err := databaseManager.ValidateTOTPCode(ctx, userID, code)
+	if err != nil || !ok {
+		t.Errorf("Valid TOTP code was rejected: %v", err)
+	}
+
+	ok, _ = databaseManager.ValidateTOTPCode(ctx, userID, code)
+	if ok {
+		t.Errorf("Reused TOTP code was accepted")
+	}
+
+	ok, _ = databaseManager.ValidateTOTPCode(ctx, userID, totp.GenerateCode(secret, totp.Step(time.Now())-1))
+	if ok {
+		t.Errorf("TOTP code older than last used code was accepted")
+	}
+}
+
+func TestDisableTOTP(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := registerTOTPUser(t, "disableTOTPUser")
+
+	err := databaseManager.DisableTOTP(ctx, userID, "WrongPassword")
+	if err != database.ErrIncorrectPassword {
+		t.Errorf("Disabling TOTP with incorrect password did not return ErrIncorrectPassword: %v", err)
+	}
+
+	err = databaseManager.DisableTOTP(ctx, userID, "Password123")
+	if err != nil {
+		t.Fatalf("Error while disabling TOTP: %v", err)
+	}
+	enabled, _ := databaseManager.IsTOTPEnabled(ctx, userID)
+	if enabled {
+		t.Errorf("TOTP enabled after disabling")
+	}
+
+	err = databaseManager.DisableTOTP(ctx, userID, "Password123")
+	if err != database.ErrTOTPNotEnrolled {
+		t.Errorf("Disabling TOTP twice did not return ErrTOTPNotEnrolled: %v", err)
+	}
+}
+
+func TestCompleteMFAChallenge(t *testing.T) {
+	ctx := context.Background()
+	userID, secret := registerTOTPUser(t, "mfaChallengeUser")
+
+	challenge, err := databaseManager.CreateMFAChallenge(ctx, userID, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Error while creating MFA challenge: %v", err)
+	}
+
+	_, err = databaseManager.CompleteMFAChallenge(ctx, challenge, totp.GenerateCode(secret, totp.Step(time.Now())+10))
+	if err != database.ErrIncorrectTOTPCode {
+		t.Errorf("Incorrect code did not return ErrIncorrectTOTPCode: %v", err)
+	}
+
+	completedUserID, err := databaseManager.CompleteMFAChallenge(ctx, challenge, totp.GenerateCode(secret, totp.Step(time.Now())))
+	if err != nil {
+		t.Fatalf("Error while completing MFA challenge: %v", err)
+	}
+	if completedUserID != userID {
+		t.Errorf("MFA challenge completed for wrong user: expected %v, got %v", userID, completedUserID)
+	}
+
+	_, err = databaseManager.CompleteMFAChallenge(ctx, challenge, totp.GenerateCode(secret, totp.Step(time.Now())+1))
+	if err != database.ErrMFAChallengeInvalid {
+		t.Errorf("Completed MFA challenge did not return ErrMFAChallengeInvalid: %v", err)
+	}
+}
+
+func TestMFAChallengeAttemptLimit(t *testing.T) {
+	ctx := context.Background()
+	userID, secret := registerTOTPUser(t, "mfaAttemptLimitUser")
+	wrongCode := totp.GenerateCode(secret, totp.Step(time.Now())+10)
+
+	challenge, _ := databaseManager.CreateMFAChallenge(ctx, userID, time.Now().Add(time.Minute))
+	for range 5 {
+		databaseManager.CompleteMFAChallenge(ctx, challenge, wrongCode)
+	}
+
+	_, err := databaseManager.CompleteMFAChallenge(ctx, challenge, totp.GenerateCode(secret, totp.Step(time.Now())))
+	if err != database.ErrMFAChallengeInvalid {
+		t.Errorf("MFA challenge with no attempts left did not return ErrMFAChallengeInvalid: %v", err)
+	}
+}
+
+func TestExpiredMFAChallenge(t *testing.T) {
+	ctx := context.Background()
+	userID, secret := registerTOTPUser(t, "mfaExpiredUser")
+
+	challenge, _ := databaseManager.CreateMFAChallenge(ctx, userID, time.Now().Add(-time.Minute))
+	_, err := databaseManager.CompleteMFAChallenge(ctx, challenge, totp.GenerateCode(secret, totp.Step(time.Now())))
+	if err != database.ErrMFAChallengeExpired {
+		t.Errorf("Expired MFA challenge did not return ErrMFAChallengeExpired: %v", err)
+	}
+}
+
+func TestGetMFAChallengeUserID(t *testing.T) {
+	ctx := context.Background()
+	userID, secret := registerTOTPUser(t, "mfaUserIDUser")
+	wrongCode := totp.GenerateCode(secret, totp.Step(time.Now())+10)
+
+	challenge, _ := databaseManager.CreateMFAChallenge(ctx, userID, time.Now().Add(time.Minute))
+	for range 5 {
+		challengeUserID, err := databaseManager.GetMFAChallengeUserID(ctx, challenge)
+		if err != nil || challengeUserID != userID {
+			t.Fatalf("MFA challenge user is %v, expected %v: %v", challengeUserID, userID, err)
+		}
+		databaseManager.CompleteMFAChallenge(ctx, challenge, wrongCode)
+	}
+
+	_, err := databaseManager.GetMFAChallengeUserID(ctx, challenge)
+	if err != database.ErrMFAChallengeInvalid {
+		t.Errorf("MFA challenge with no attempts left did not return ErrMFAChallengeInvalid: %v", err)
+	}
+
+	expiredChallenge, _ := databaseManager.CreateMFAChallenge(ctx, userID, time.Now().Add(-time.Minute))
+	_, err = databaseManager.GetMFAChallengeUserID(ctx, expiredChallenge)
+	if err != database.ErrMFAChallengeExpired {
+		t.Errorf("Expired MFA challenge did not return ErrMFAChallengeExpired: %v", err)
+	}
+}
+
+func TestConsumeMFAChallenge(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := registerTOTPUser(t, "mfaConsumeUser")
+	otherUserID, _ := databaseManager.GetUserIDByUsername(ctx, "Jane Doe")
+
+	challenge, _ := databaseManager.CreateMFAChallenge(ctx, userID, time.Now().Add(time.Minute))
+	checkedUserID, err := databaseManager.CheckMFAChallenge(ctx, challenge)
+	if err != nil || checkedUserID != userID {
+		t.Fatalf("MFA challenge check returned %v, expected %v: %v", checkedUserID, userID, err)
+	}
+
+	err = databaseManager.ConsumeMFAChallenge(ctx, challenge, otherUserID)
+	if err != database.ErrMFAChallengeInvalid {
+		t.Errorf("Consuming MFA challenge of other user did not return ErrMFAChallengeInvalid: %v", err)
+	}
+	err = databaseManager.ConsumeMFAChallenge(ctx, challenge, userID)
+	if err != nil {
+		t.Errorf("Error while consuming MFA challenge: %v", err)
+	}
+	err = databaseManager.ConsumeMFAChallenge(ctx, challenge, userID)
+	if err != database.ErrMFAChallengeInvalid {
+		t.Errorf("Reused MFA challenge did not return ErrMFAChallengeInvalid: %v", err)
+	}
+}
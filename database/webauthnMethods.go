@@ -0,0 +1,262 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database/sqlc"
+	"github.com/mattn/go-sqlite3"
+)
+
+// A WebAuthn credential (passkey) registered to a user.
+type WebAuthnCredential struct {
+	// The credential ID chosen by the authenticator.
+	ID []byte
+
+	// A name chosen by the user to tell their passkeys apart.
+	Name string
+
+	// The credential as stored by the WebAuthn library (including the public key and signature counter), encoded as JSON.
+	// The database does not need to understand it, so it is stored as given.
+	Credential []byte
+
+	CreatedAt time.Time
+
+	// The zero time if the credential has never been used to log in.
+	LastUsedAt time.Time
+}
+
+// The state of a WebAuthn ceremony (registration or login), kept between its begin and finish requests.
+type WebAuthnSession struct {
+	// The user the ceremony is for. Empty for passwordless logins, where the user is only known once they finish.
+	UserID string
+
+	// Which ceremony was begun, so the state of one ceremony cannot be used to finish another.
+	Ceremony string
+
+	// The session data of the WebAuthn library (including the challenge), encoded as JSON.
+	SessionData []byte
+}
+
+// Register a new WebAuthn credential to a user.
+//
+// Fails (and returns a non-nil error) if:
+// - The credential is already registered, to this or any other user (ErrOnCreateCredentialExists)
+// - The credential cannot be stored in the database
+func (database *DatabaseManager) AddWebAuthnCredential(ctx context.Context, userID string, credential WebAuthnCredential) error {
+	err := database.queries.CreateWebAuthnCredential(ctx, sqlc.CreateWebAuthnCredentialParams{
+		CredentialID: encodeCredentialID(credential.ID),
+		Uuid:         userID,
+		Name:         credential.Name,
+		Credential:   string(credential.Credential),
+		CreatedAt:    time.Now().Unix(),
+	})
+	if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+		return ErrOnCreateCredentialExists
+	}
+	return err
+}
+
+// Gets the WebAuthn credentials registered to a user, oldest first. Returns an empty list if the user has none.
+func (database *DatabaseManager) GetWebAuthnCredentials(ctx context.Context, userID string) ([]WebAuthnCredential, error) {
+	storedCredentials, err := database.queries.GetWebAuthnCredentialsByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]WebAuthnCredential, 0, len(storedCredentials))
+	for _, storedCredential := range storedCredentials {
+		credentialID, err := base64.RawURLEncoding.DecodeString(storedCredential.CredentialID)
+		if err != nil {
+			return nil, err
+		}
+
+		var lastUsedAt time.Time
+		if storedCredential.LastUsedAt != 0 {
+			lastUsedAt = time.Unix(storedCredential.LastUsedAt, 0)
+		}
+		credentials = append(credentials, WebAuthnCredential{
+			ID:         credentialID,
+			Name:       storedCredential.Name,
+			Credential: []byte(storedCredential.Credential),
+			CreatedAt:  time.Unix(storedCredential.CreatedAt, 0),
+			LastUsedAt: lastUsedAt,
+		})
+	}
+
+	return credentials, nil
+}
+
+// Store the updated state of a WebAuthn credential (such as its signature counter) after it was used to log in.
+//
+// Fails (and returns a non-nil error) if:
+// - The credential is not registered to the user (ErrCredentialDoesNotExist)
+func (database *DatabaseManager) UpdateWebAuthnCredential(ctx context.Context, userID string, credentialID []byte, credential []byte) error {
+	rowsAffected, err := database.queries.UpdateWebAuthnCredential(ctx, sqlc.UpdateWebAuthnCredentialParams{
+		Credential:   string(credential),
+		LastUsedAt:   time.Now().Unix(),
+		CredentialID: encodeCredentialID(credentialID),
+		Uuid:         userID,
+	})
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrCredentialDoesNotExist
+	}
+	return nil
+}
+
+// Given a userID and the password of that user, remove a WebAuthn credential of the user.
+// The password is checked in the same transaction as the removal, so the password cannot be changed in between.
+//
+// Fails (and returns a non-nil error) if:
+// - The user does not exist in the database (ErrOnFetchUserDoesNotExist)
+// - The password is incorrect (ErrIncorrectPassword)
+// - The credential is not registered to the user (ErrCredentialDoesNotExist)
+// - The transaction to check the password and remove the credential fails
+func (database *DatabaseManager) DeleteWebAuthnCredential(ctx context.Context, userID string, credentialID []byte, password string) error {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+	// If anything fails, an early return is triggered (before tx.Commit is called) and tx.Rollback is called
+	defer tx.Rollback()
+
+	qtx := database.queries.WithTx(tx)
+	authDatum, err := qtx.GetAuthData(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrOnFetchUserDoesNotExist
+		}
+		return err
+	}
+
+	ok, err := database.checkPassword(authDatum, password)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrIncorrectPassword
+	}
+
+	rowsAffected, err := qtx.DeleteWebAuthnCredential(ctx, sqlc.DeleteWebAuthnCredentialParams{
+		CredentialID: encodeCredentialID(credentialID),
+		Uuid:         userID,
+	})
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrCredentialDoesNotExist
+	}
+	return tx.Commit()
+}
+
+// Store the state of a WebAuthn ceremony until it is finished. The returned (plaintext) token is handed to the client,
+// which gives it back to finish the ceremony. Only the hash is stored in the database.
+//
+// Fails (and returns a non-nil error) if:
+// - The token fails to be generated
+// - The session cannot be stored in the database
+func (database *DatabaseManager) CreateWebAuthnSession(ctx context.Context, session WebAuthnSession, expiresAt time.Time) (string, error) {
+	return createWebAuthnSession(ctx, database.queries, session, expiresAt)
+}
+
+// Given the password of the user of a WebAuthn ceremony, store the state of the ceremony as CreateWebAuthnSession does.
+// The password is checked in the same transaction as the session is stored, so the password cannot be changed in between.
+//
+// Fails (and returns a non-nil error) if:
+// - The user does not exist in the database (ErrOnFetchUserDoesNotExist)
+// - The password is incorrect (ErrIncorrectPassword)
+// - The token fails to be generated
+// - The transaction to check the password and store the session fails
+func (database *DatabaseManager) CreateWebAuthnSessionWithPassword(ctx context.Context, session WebAuthnSession, password string, expiresAt time.Time) (string, error) {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	// If anything fails, an early return is triggered (before tx.Commit is called) and tx.Rollback is called
+	defer tx.Rollback()
+
+	qtx := database.queries.WithTx(tx)
+	authDatum, err := qtx.GetAuthData(ctx, session.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrOnFetchUserDoesNotExist
+		}
+		return "", err
+	}
+
+	ok, err := database.checkPassword(authDatum, password)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrIncorrectPassword
+	}
+
+	sessionToken, err := createWebAuthnSession(ctx, qtx, session, expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+	return sessionToken, nil
+}
+
+// Generate a token for, and store, the state of a WebAuthn ceremony using the given queries (which may be part of a transaction).
+func createWebAuthnSession(ctx context.Context, queries *sqlc.Queries, session WebAuthnSession, expiresAt time.Time) (string, error) {
+	sessionToken, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = queries.CreateWebAuthnSession(ctx, sqlc.CreateWebAuthnSessionParams{
+		SessionHash: hashOpaqueToken(sessionToken),
+		Uuid:        session.UserID,
+		Ceremony:    session.Ceremony,
+		SessionData: string(session.SessionData),
+		ExpiresAt:   expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return sessionToken, nil
+}
+
+// Consume the state of a WebAuthn ceremony, so each ceremony can be finished at most once (even if two requests race).
+//
+// Fails (and returns a non-nil error) if:
+// - The session does not exist, or has already been consumed (ErrWebAuthnSessionInvalid)
+// - The session has expired (ErrWebAuthnSessionExpired)
+func (database *DatabaseManager) ConsumeWebAuthnSession(ctx context.Context, sessionToken string) (WebAuthnSession, error) {
+	storedSession, err := database.queries.ConsumeWebAuthnSession(ctx, hashOpaqueToken(sessionToken))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return WebAuthnSession{}, ErrWebAuthnSessionInvalid
+		}
+		return WebAuthnSession{}, err
+	}
+
+	if time.Now().Unix() >= storedSession.ExpiresAt {
+		return WebAuthnSession{}, ErrWebAuthnSessionExpired
+	}
+
+	return WebAuthnSession{
+		UserID:      storedSession.Uuid,
+		Ceremony:    storedSession.Ceremony,
+		SessionData: []byte(storedSession.SessionData),
+	}, nil
+}
+
+// Encode a (binary) credential ID for storage in the database.
+func encodeCredentialID(credentialID []byte) string {
+	return base64.RawURLEncoding.EncodeToString(credentialID)
+}
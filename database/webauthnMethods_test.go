@@ -0,0 +1,130 @@
+package database_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hmcalister/AuthSSO/database"
+)
+
+func TestWebAuthnCredentials(t *testing.T) {
+	ctx := context.Background()
+	databaseManager.RegisterNewUser(ctx, "webAuthnUser", "Password123", "")
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "webAuthnUser")
+	credential := database.WebAuthnCredential{
+		ID:         []byte{1, 2, 3, 4},
+		Name:       "Laptop",
+		Credential: []byte(`{"signCount": 0}`),
+	}
+
+	err := databaseManager.AddWebAuthnCredential(ctx, userID, credential)
+	if err != nil {
+		t.Fatalf("Error while adding WebAuthn credential: %v", err)
+	}
+	err = databaseManager.AddWebAuthnCredential(ctx, userID, credential)
+	if err != database.ErrOnCreateCredentialExists {
+		t.Errorf("Adding existing WebAuthn credential did not return ErrOnCreateCredentialExists: %v", err)
+	}
+
+	err = databaseManager.UpdateWebAuthnCredential(ctx, userID, credential.ID, []byte(`{"signCount": 1}`))
+	if err != nil {
+		t.Errorf("Error while updating WebAuthn credential: %v", err)
+	}
+	otherUserID, _ := databaseManager.GetUserIDByUsername(ctx, "Jane Doe")
+	err = databaseManager.UpdateWebAuthnCredential(ctx, otherUserID, credential.ID, []byte(`{"signCount": 2}`))
+	if err != database.ErrCredentialDoesNotExist {
+		t.Errorf("Updating WebAuthn credential of other user did not return ErrCredentialDoesNotExist: %v", err)
+	}
+
+	credentials, err := databaseManager.GetWebAuthnCredentials(ctx, userID)
+	if err != nil || len(credentials) != 1 {
+		t.Fatalf("Unexpected WebAuthn credentials %v: %v", credentials, err)
+	}
+	if !bytes.Equal(credentials[0].ID, credential.ID) || credentials[0].Name != "Laptop" || string(credentials[0].Credential) != `{"signCount": 1}` {
+		t.Errorf("Stored WebAuthn credential does not match: %v", credentials[0])
+	}
+	if credentials[0].LastUsedAt.IsZero() {
+		t.Errorf("WebAuthn credential last used time not set after update")
+	}
+
+	err = databaseManager.DeleteWebAuthnCredential(ctx, userID, credential.ID, "WrongPassword")
+	if err != database.ErrIncorrectPassword {
+		t.Errorf("Deleting WebAuthn credential with incorrect password did not return ErrIncorrectPassword: %v", err)
+	}
+	err = databaseManager.DeleteWebAuthnCredential(ctx, userID, credential.ID, "Password123")
+	if err != nil {
+		t.Errorf("Error while deleting WebAuthn credential: %v", err)
+	}
+	credentials, _ = databaseManager.GetWebAuthnCredentials(ctx, userID)
+	if len(credentials) != 0 {
+		t.Errorf("WebAuthn credential remains after deletion")
+	}
+}
+
+func TestWebAuthnSession(t *testing.T) {
+	ctx := context.Background()
+	session := database.WebAuthnSession{
+		UserID:      "",
+		Ceremony:    "login",
+		SessionData: []byte(`{"challenge": "abc"}`),
+	}
+
+	sessionToken, err := databaseManager.CreateWebAuthnSession(ctx, session, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Error while creating WebAuthn session: %v", err)
+	}
+
+	storedSession, err := databaseManager.ConsumeWebAuthnSession(ctx, sessionToken)
+	if err != nil {
+		t.Fatalf("Error while consuming WebAuthn session: %v", err)
+	}
+	if storedSession.Ceremony != session.Ceremony || string(storedSession.SessionData) != string(session.SessionData) {
+		t.Errorf("Stored WebAuthn session does not match: %v", storedSession)
+	}
+
+	_, err = databaseManager.ConsumeWebAuthnSession(ctx, sessionToken)
+	if err != database.ErrWebAuthnSessionInvalid {
+		t.Errorf("Reused WebAuthn session did not return ErrWebAuthnSessionInvalid: %v", err)
+	}
+
+	expiredSessionToken, _ := databaseManager.CreateWebAuthnSession(ctx, session, time.Now().Add(-time.Minute))
+	_, err = databaseManager.ConsumeWebAuthnSession(ctx, expiredSessionToken)
+	if err != database.ErrWebAuthnSessionExpired {
+		t.Errorf("Expired WebAuthn session did not return ErrWebAuthnSessionExpired: %v", err)
+	}
+}
+
+func TestWebAuthnSessionWithPassword(t *testing.T) {
+	ctx := context.Background()
+	userID, _ := databaseManager.GetUserIDByUsername(ctx, "John Smith")
+	session := database.WebAuthnSession{
+		UserID:      userID,
+		Ceremony:    "registration",
+		SessionData: []byte(`{"challenge": "abc"}`),
+	}
+
+	_, err := databaseManager.CreateWebAuthnSessionWithPassword(ctx, session, "WrongPassword", time.Now().Add(time.Minute))
+	if err != database.ErrIncorrectPassword {
+		t.Errorf("WebAuthn session with incorrect password did not return ErrIncorrectPassword: %v", err)
+	}
+
+	sessionToken, err := databaseManager.CreateWebAuthnSessionWithPassword(ctx, session, "Password123", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Error while creating WebAuthn session with password: %v", err)
+	}
+	storedSession, err := databaseManager.ConsumeWebAuthnSession(ctx, sessionToken)
+	if err != nil {
+		t.Fatalf("Error while consuming WebAuthn session: %v", err)
+	}
+	if storedSession.UserID != userID || storedSession.Ceremony != session.Ceremony {
+		t.Errorf("Stored WebAuthn session does not match: %v", storedSession)
+	}
+
+	session.UserID = "notAUser"
+	_, err = databaseManager.CreateWebAuthnSessionWithPassword(ctx, session, "Password123", time.Now().Add(time.Minute))
+	if err != database.ErrOnFetchUserDoesNotExist {
+		t.Errorf("WebAuthn session for unknown user did not return ErrOnFetchUserDoesNotExist: %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// A Mailer that writes each message to a file (or stdout) rather than delivering it, for development and testing.
+type FileMailer struct {
+	// Held while writing a message, so concurrent messages are not interleaved.
+	mutex sync.Mutex
+
+	// The file messages are appended to, or "-" for stdout.
+	filePath string
+}
+
+// Create a new FileMailer appending messages to the file at filePath, which is created if it does not exist.
+// If filePath is "-", messages are written to stdout instead.
+func NewFileMailer(filePath string) *FileMailer {
+	return &FileMailer{
+		filePath: filePath,
+	}
+}
+
+// Write the message to the file of the mailer.
+//
+// Fails (and returns a non-nil error) if:
+// - The headers of the message contain line breaks (ErrInvalidHeader)
+// - The file cannot be opened or written to
+func (mailer *FileMailer) Send(ctx context.Context, message Message) error {
+	err := validateHeaders(message)
+	if err != nil {
+		return err
+	}
+
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	var output io.Writer = os.Stdout
+	if mailer.filePath != "-" {
+		file, err := os.OpenFile(mailer.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		output = file
+	}
+
+	_, err = fmt.Fprintf(output, "Date: %v\nTo: %v\nSubject: %v\n\n%v\n\n", time.Now().Format(time.RFC1123Z), message.To, message.Subject, message.Body)
+	return err
+}
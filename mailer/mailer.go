@@ -0,0 +1,36 @@
+// Package mailer delivers email to users, such as the links sent to reset a password.
+//
+// Mail is sent through the Mailer interface, so the SMTPMailer used in production
+// can be replaced by a FileMailer (writing messages to a file or stdout) to run offline.
+package mailer
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidHeader error = errors.New("message header must not contain line breaks")
+)
+
+// A plain text email message.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Delivers messages to their recipients.
+type Mailer interface {
+	// Send a message, failing if it could not be delivered (or, for SMTP, handed to the mail server).
+	Send(ctx context.Context, message Message) error
+}
+
+// Check the headers of a message cannot be used to inject further headers (or a new body) into the message.
+func validateHeaders(message Message) error {
+	if strings.ContainsAny(message.To, "\r\n") || strings.ContainsAny(message.Subject, "\r\n") {
+		return ErrInvalidHeader
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testMessage = Message{
+	To:      "user@example.com",
+	Subject: "Reset your password",
+	Body:    "Follow this link.\n.\nThanks",
+}
+
+func TestFileMailer(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "mail")
+	mailer := NewFileMailer(filePath)
+
+	err := mailer.Send(context.Background(), testMessage)
+	if err != nil {
+		t.Fatalf("Error while sending message: %v", err)
+	}
+
+	contents, _ := os.ReadFile(filePath)
+	if !strings.Contains(string(contents), "To: user@example.com\n") || !strings.Contains(string(contents), testMessage.Body) {
+		t.Errorf("Message not written to file: %q", contents)
+	}
+
+	err = mailer.Send(context.Background(), Message{To: "user@example.com\nBcc: attacker@example.com"})
+	if err != ErrInvalidHeader {
+		t.Errorf("Message with line break in header did not return ErrInvalidHeader: %v", err)
+	}
+}
+
+// Accept a single SMTP session, returning the message data on the channel once the session ends.
+func serveTestSMTP(t *testing.T, listener net.Listener, received chan<- string) {
+	connection, err := listener.Accept()
+	if err != nil {
+		t.Errorf("Error while accepting SMTP connection: %v", err)
+		close(received)
+		return
+	}
+	defer connection.Close()
+
+	reader := bufio.NewReader(connection)
+	writeLine := func(line string) { connection.Write([]byte(line + "\r\n")) }
+	writeLine("220 localhost ESMTP")
+
+	var data strings.Builder
+	readingData := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		if readingData {
+			if line == ".\r\n" {
+				readingData = false
+				writeLine("250 OK")
+			} else {
+				data.WriteString(line)
+			}
+			continue
+		}
+
+		switch command := strings.ToUpper(strings.Fields(line)[0]); command {
+		case "EHLO", "HELO":
+			writeLine("250 localhost")
+		case "DATA":
+			readingData = true
+			writeLine("354 Start mail input")
+		case "QUIT":
+			writeLine("221 Bye")
+			received <- data.String()
+			return
+		default:
+			writeLine("250 OK")
+		}
+	}
+	received <- data.String()
+}
+
+func TestSMTPMailer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error while listening for SMTP connection: %v", err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go serveTestSMTP(t, listener, received)
+
+	mailer, err := NewSMTPMailer(SMTPConfig{
+		Host: "127.0.0.1",
+		Port: listener.Addr().(*net.TCPAddr).Port,
+		From: "AuthSSO <sso@example.com>",
+	})
+	if err != nil {
+		t.Fatalf("Error while creating SMTP mailer: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = mailer.Send(ctx, testMessage)
+	if err != nil {
+		t.Fatalf("Error while sending message: %v", err)
+	}
+
+	data := <-received
+	if !strings.Contains(data, "To: user@example.com\r\n") || !strings.Contains(data, "Subject: Reset your password\r\n") {
+		t.Errorf("Message headers not sent: %q", data)
+	}
+	// The lone dot in the body must be escaped, so it does not end the message early
+	if !strings.Contains(data, "Follow this link.\r\n..\r\nThanks\r\n") {
+		t.Errorf("Message body not sent: %q", data)
+	}
+}
+
+func TestNewSMTPMailerInvalidFrom(t *testing.T) {
+	_, err := NewSMTPMailer(SMTPConfig{From: "not an address"})
+	if err == nil {
+		t.Errorf("No error while creating SMTP mailer with invalid from address")
+	}
+}
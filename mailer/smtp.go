@@ -0,0 +1,138 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The configuration of an SMTPMailer.
+type SMTPConfig struct {
+	// The host and port of the mail server.
+	Host string
+	Port int
+
+	// The credentials to log in to the mail server with. If Username is empty, messages are sent without logging in.
+	// The credentials are only ever sent over TLS (or to localhost).
+	Username string
+	Password string
+
+	// The address messages are sent from.
+	From string
+}
+
+// A Mailer that delivers messages through a mail server.
+type SMTPMailer struct {
+	config SMTPConfig
+}
+
+// Create a new SMTPMailer delivering messages through the mail server described by config.
+//
+// Fails (and returns a non-nil error) if:
+// - The From address cannot be parsed
+func NewSMTPMailer(config SMTPConfig) (*SMTPMailer, error) {
+	_, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse from address: %w", err)
+	}
+
+	return &SMTPMailer{
+		config: config,
+	}, nil
+}
+
+// Hand the message to the mail server for delivery, using STARTTLS if the server supports it.
+//
+// Fails (and returns a non-nil error) if:
+// - The headers of the message contain line breaks (ErrInvalidHeader)
+// - The recipient address cannot be parsed
+// - The mail server cannot be reached before the context is done, or rejects the message
+func (mailer *SMTPMailer) Send(ctx context.Context, message Message) error {
+	err := validateHeaders(message)
+	if err != nil {
+		return err
+	}
+	recipient, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return fmt.Errorf("could not parse recipient address: %w", err)
+	}
+	sender, _ := mail.ParseAddress(mailer.config.From)
+
+	address := net.JoinHostPort(mailer.config.Host, strconv.Itoa(mailer.config.Port))
+	var dialer net.Dialer
+	connection, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		connection.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(connection, mailer.config.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: mailer.config.Host})
+		if err != nil {
+			return err
+		}
+	}
+	if mailer.config.Username != "" {
+		// PlainAuth refuses to send the credentials without TLS, except to localhost
+		err = client.Auth(smtp.PlainAuth("", mailer.config.Username, mailer.config.Password, mailer.config.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(sender.Address)
+	if err != nil {
+		return err
+	}
+	err = client.Rcpt(recipient.Address)
+	if err != nil {
+		return err
+	}
+	dataWriter, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = dataWriter.Write(formatMessage(mailer.config.From, message, time.Now()))
+	if err != nil {
+		return err
+	}
+	err = dataWriter.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Format a message as an RFC 5322 plain text email, with CRLF line endings.
+func formatMessage(from string, message Message, date time.Time) []byte {
+	var formattedMessage bytes.Buffer
+	fmt.Fprintf(&formattedMessage, "From: %v\r\n", from)
+	fmt.Fprintf(&formattedMessage, "To: %v\r\n", message.To)
+	fmt.Fprintf(&formattedMessage, "Subject: %v\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&formattedMessage, "Date: %v\r\n", date.Format(time.RFC1123Z))
+	formattedMessage.WriteString("MIME-Version: 1.0\r\n")
+	formattedMessage.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	formattedMessage.WriteString("\r\n")
+
+	body := strings.ReplaceAll(message.Body, "\r\n", "\n")
+	formattedMessage.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	formattedMessage.WriteString("\r\n")
+	return formattedMessage.Bytes()
+}
@@ -1,17 +1,23 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"flag"
 	"fmt"
 	"io/fs"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	authenticationmaster "github.com/hmcalister/AuthSSO/authenticationMaster"
 	"github.com/hmcalister/AuthSSO/database"
+	"github.com/hmcalister/AuthSSO/mailer"
+	passwordpolicy "github.com/hmcalister/AuthSSO/passwordPolicy"
 	commonMiddleware "github.com/hmcalister/GoChi-CommonMiddleware"
 	"github.com/phsym/console-slog"
 
@@ -24,7 +30,29 @@ var webpages embed.FS
 var (
 	databaseManager *database.DatabaseManager
 	port            *int
-	secretKey       []byte
+	keyring         *authenticationmaster.Keyring
+	issuer          *string
+	cookieConfig    *authenticationmaster.CookieConfig
+	sessionBackend  authenticationmaster.SessionBackend
+	tokenClaims     []authenticationmaster.TokenClaim
+	passwordMailer  mailer.Mailer
+	trustedProxies  []netip.Prefix
+	passwordPolicy  passwordpolicy.Policy
+
+	registerClientID    *string
+	clientRedirectURIs  *string
+	publicClient        *bool
+	clientAudience      *string
+	clientTokenLifetime *time.Duration
+
+	manageUsername *string
+	addRoles       *string
+	removeRoles    *string
+	addGroups      *string
+	removeGroups   *string
+
+	unlockUsername *string
+	unlockIP       *string
 )
 
 func init() {
@@ -33,7 +61,40 @@ func init() {
 	port = flag.Int("port", 6585, "The port to use for the HTTP server.")
 	debugFlag := flag.Bool("debug", false, "Flag for debug level with console log outputs.")
 	databaseFilePath := flag.String("databaseFilePath", "database.sqlite", "The path to the database file on disk.")
-	secretKeyFile := flag.String("secretKeyFile", "key.secret", "The path to the file containing the secret key for JWTAuth.")
+	secretKeyFile := flag.String("secretKeyFile", "key.secret", "The path to the file containing the secret key for JWTAuth. Ignored if keyringFile is given.")
+	keyringFile := flag.String("keyringFile", "", "The path to a keyring file listing the keys for JWTAuth, allowing the signing key to be rotated.")
+	encryptionKeyFile := flag.String("encryptionKeyFile", "", "The path to the file containing the 32 byte key TOTP secrets are encrypted with. If empty, two-factor authentication is disabled.")
+	pepperFilesList := flag.String("pepperFiles", "", "Space separated version=path pairs of files containing secret peppers (of at least 32 bytes) for password hashes, such as \"2=pepper2.secret 1=pepper1.secret\". The first peppers new hashes. If empty, new hashes are not peppered.")
+	issuer = flag.String("issuer", authenticationmaster.DefaultIssuer, "The issuer of all tokens. OpenID Connect clients require this to be the https URL of this server.")
+	registerClientID = flag.String("registerClient", "", "Register an OpenID Connect client with this client ID, print the client secret, and exit.")
+	clientRedirectURIs = flag.String("clientRedirectURIs", "", "Space separated redirect URIs of the client given by registerClient.")
+	publicClient = flag.Bool("publicClient", false, "Register the client given by registerClient as a public client, with no client secret.")
+	clientAudience = flag.String("clientAudience", "", "The audience of access tokens issued to the client given by registerClient. Defaults to the client ID.")
+	clientTokenLifetime = flag.Duration("clientTokenLifetime", 0, "The lifetime of access tokens issued to the client given by registerClient, e.g. 5m. Defaults to the usual token lifetime.")
+	manageUsername = flag.String("manageUser", "", "Change the roles and groups of the user with this username (given by addRoles, removeRoles, addGroups, and removeGroups), print them, and exit.")
+	addRoles = flag.String("addRoles", "", "Space separated roles to give the user given by manageUser.")
+	removeRoles = flag.String("removeRoles", "", "Space separated roles to take from the user given by manageUser.")
+	addGroups = flag.String("addGroups", "", "Space separated groups to add the user given by manageUser to.")
+	removeGroups = flag.String("removeGroups", "", "Space separated groups to remove the user given by manageUser from.")
+	unlockUsername = flag.String("unlockUser", "", "Unlock logins with this username after too many failed attempts, and exit.")
+	unlockIP = flag.String("unlockIP", "", "Unlock logins from this client IP after too many failed attempts, and exit.")
+	trustedProxiesList := flag.String("trustedProxies", "127.0.0.1 ::1", "Space separated IP addresses or CIDR prefixes of reverse proxies trusted to give the client IP in X-Forwarded-For.")
+	passwordMinLength := flag.Int("passwordMinLength", 8, "The minimum number of characters in new passwords.")
+	passwordMinCharacterClasses := flag.Int("passwordMinCharacterClasses", 0, "The minimum number of character classes (lowercase, uppercase, digit, and symbol) new passwords must use.")
+	passwordRequiredCharacterClassesList := flag.String("passwordRequiredCharacterClasses", "", "Space separated character classes every new password must use, from lowercase, uppercase, digit, and symbol.")
+	passwordMinStrength := flag.Float64("passwordMinStrength", 50, "The minimum estimated strength of new passwords, in bits. Passwords with repetition or sequences (such as \"abc\" or \"qwerty\") are estimated weaker.")
+	tokenClaimsList := flag.String("tokenClaims", "", "Space separated claims describing the user to add to JWT access tokens, from preferred_username, roles, groups, and email.")
+	sessionBackendName := flag.String("sessionBackend", "jwt", "The kind of access token given to users, either jwt or opaque (server-side sessions).")
+	cookieMode := flag.Bool("cookieMode", false, "Flag to give browser logins their tokens in HttpOnly cookies rather than the response body.")
+	cookieDomain := flag.String("cookieDomain", "", "The domain of the token cookies in cookie mode. If empty, cookies are only sent to this host.")
+	cookiePath := flag.String("cookiePath", "/", "The path of the token cookies in cookie mode.")
+	cookieSameSite := flag.String("cookieSameSite", "Lax", "The SameSite attribute of the token cookies in cookie mode, one of Lax, Strict, or None.")
+	mailerName := flag.String("mailer", "", "How password reset and email verification messages are sent, either smtp or file. If empty, both are disabled.")
+	mailerFile := flag.String("mailerFile", "-", "The file the file mailer appends messages to, or - for stdout.")
+	smtpHost := flag.String("smtpHost", "localhost", "The host of the mail server used by the smtp mailer.")
+	smtpPort := flag.Int("smtpPort", 587, "The port of the mail server used by the smtp mailer.")
+	smtpUsername := flag.String("smtpUsername", "", "The username to log in to the mail server with. The password is read from the AUTHSSO_SMTP_PASSWORD environment variable.")
+	smtpFrom := flag.String("smtpFrom", "", "The address messages are sent from by the smtp mailer.")
 	flag.Parse()
 
 	logFileHandle := &lumberjack.Logger{
@@ -64,26 +125,273 @@ func init() {
 		os.Exit(1)
 	}
 
-	secretKey, err = os.ReadFile(*secretKeyFile)
+	if *encryptionKeyFile != "" {
+		encryptionKey, err := os.ReadFile(*encryptionKeyFile)
+		if err != nil {
+			slog.Error("Could not open encryption key file", "FilePath", *encryptionKeyFile, "Error", err)
+			os.Exit(1)
+		}
+		err = databaseManager.SetEncryptionKey(encryptionKey)
+		if err != nil {
+			slog.Error("Could not set encryption key", "FilePath", *encryptionKeyFile, "Error", err)
+			os.Exit(1)
+		}
+	}
+
+	if *pepperFilesList != "" {
+		err = databaseManager.LoadPeppers(*pepperFilesList)
+		if err != nil {
+			slog.Error("Could not load peppers", "PepperFiles", *pepperFilesList, "Error", err)
+			os.Exit(1)
+		}
+	}
+
+	if *keyringFile != "" {
+		keyring, err = authenticationmaster.LoadKeyring(*keyringFile)
+		if err != nil {
+			slog.Error("Could not load keyring for JWTAuth", "FilePath", *keyringFile, "Error", err)
+			os.Exit(1)
+		}
+	} else {
+		secretKey, err := os.ReadFile(*secretKeyFile)
+		if err != nil {
+			slog.Error("Could not open secret file for JWTAuth", "FilePath", *secretKeyFile, "Error", err)
+			os.Exit(1)
+		}
+		keyring = authenticationmaster.NewKeyringFromSecret(secretKey)
+	}
+
+	tokenClaims, err = authenticationmaster.ParseTokenClaims(*tokenClaimsList)
 	if err != nil {
-		slog.Error("Could not open secret file for JWTAuth", "FilePath", *secretKeyFile, "Error", err)
+		slog.Error("Could not parse tokenClaims", "TokenClaims", *tokenClaimsList, "Error", err)
+		os.Exit(1)
+	}
+
+	trustedProxies, err = authenticationmaster.ParseTrustedProxies(*trustedProxiesList)
+	if err != nil {
+		slog.Error("Could not parse trustedProxies", "TrustedProxies", *trustedProxiesList, "Error", err)
+		os.Exit(1)
+	}
+
+	passwordRequiredCharacterClasses, err := passwordpolicy.ParseCharacterClasses(*passwordRequiredCharacterClassesList)
+	if err != nil {
+		slog.Error("Could not parse passwordRequiredCharacterClasses", "PasswordRequiredCharacterClasses", *passwordRequiredCharacterClassesList, "Error", err)
+		os.Exit(1)
+	}
+	passwordPolicy = passwordpolicy.Policy{
+		MinLength:                *passwordMinLength,
+		MinCharacterClasses:      *passwordMinCharacterClasses,
+		RequiredCharacterClasses: passwordRequiredCharacterClasses,
+		BlockUsername:            true,
+		MinStrength:              *passwordMinStrength,
+	}
+
+	switch strings.ToLower(*sessionBackendName) {
+	case "jwt":
+		sessionBackend = authenticationmaster.JWTSessions
+	case "opaque":
+		sessionBackend = authenticationmaster.OpaqueSessions
+	default:
+		slog.Error("Unknown sessionBackend, must be one of jwt or opaque", "SessionBackend", *sessionBackendName)
+		os.Exit(1)
+	}
+
+	if *cookieMode {
+		cookieConfig = &authenticationmaster.CookieConfig{
+			Domain: *cookieDomain,
+			Path:   *cookiePath,
+		}
+		switch strings.ToLower(*cookieSameSite) {
+		case "lax":
+			cookieConfig.SameSite = http.SameSiteLaxMode
+		case "strict":
+			cookieConfig.SameSite = http.SameSiteStrictMode
+		case "none":
+			cookieConfig.SameSite = http.SameSiteNoneMode
+		default:
+			slog.Error("Unknown cookieSameSite, must be one of Lax, Strict, or None", "CookieSameSite", *cookieSameSite)
+			os.Exit(1)
+		}
+	}
+
+	switch strings.ToLower(*mailerName) {
+	case "":
+	case "file":
+		passwordMailer = mailer.NewFileMailer(*mailerFile)
+	case "smtp":
+		passwordMailer, err = mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Host:     *smtpHost,
+			Port:     *smtpPort,
+			Username: *smtpUsername,
+			Password: os.Getenv("AUTHSSO_SMTP_PASSWORD"),
+			From:     *smtpFrom,
+		})
+		if err != nil {
+			slog.Error("Could not create smtp mailer", "Error", err)
+			os.Exit(1)
+		}
+	default:
+		slog.Error("Unknown mailer, must be one of smtp or file", "Mailer", *mailerName)
 		os.Exit(1)
 	}
 }
 
+// Register the OpenID Connect client given by the flags, printing the client secret so it can be given to the client.
+func registerClient() {
+	ctx := context.Background()
+	clientSecret, err := databaseManager.RegisterClient(ctx, database.Client{
+		ClientID:      *registerClientID,
+		RedirectURIs:  strings.Fields(*clientRedirectURIs),
+		Public:        *publicClient,
+		Audience:      *clientAudience,
+		TokenLifetime: *clientTokenLifetime,
+	})
+	if err != nil {
+		slog.Error("Error during registration of client", "ClientID", *registerClientID, "Error", err)
+		fmt.Fprintf(os.Stderr, "Could not register client: %v\n", err)
+		return
+	}
+
+	slog.Info("Client registered", "ClientID", *registerClientID)
+	fmt.Printf("Registered client %v\n", *registerClientID)
+	if !*publicClient {
+		fmt.Printf("Client secret (this will not be shown again): %v\n", clientSecret)
+	}
+}
+
+// Change the roles and groups of the user given by the flags, printing the roles and groups the user has afterwards.
+func manageUser() {
+	ctx := context.Background()
+	userID, err := databaseManager.GetUserIDByUsername(ctx, *manageUsername)
+	if err != nil {
+		slog.Error("Error during retrieval of user", "Username", *manageUsername, "Error", err)
+		fmt.Fprintf(os.Stderr, "Could not find user: %v\n", err)
+		return
+	}
+
+	changes := []struct {
+		names  *string
+		change func(context.Context, string, string) error
+	}{
+		{addRoles, databaseManager.AddUserRole},
+		{removeRoles, databaseManager.RemoveUserRole},
+		{addGroups, databaseManager.AddUserGroup},
+		{removeGroups, databaseManager.RemoveUserGroup},
+	}
+	for _, change := range changes {
+		for _, name := range strings.Fields(*change.names) {
+			err = change.change(ctx, userID, name)
+			if err != nil {
+				slog.Error("Error during change of user roles or groups", "Username", *manageUsername, "Name", name, "Error", err)
+				fmt.Fprintf(os.Stderr, "Could not change role or group %v: %v\n", name, err)
+				return
+			}
+		}
+	}
+
+	roles, err := databaseManager.GetUserRoles(ctx, userID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not get roles: %v\n", err)
+		return
+	}
+	groups, err := databaseManager.GetUserGroups(ctx, userID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not get groups: %v\n", err)
+		return
+	}
+
+	slog.Info("User roles and groups changed", "Username", *manageUsername, "Roles", roles, "Groups", groups)
+	fmt.Printf("User %v\nRoles: %v\nGroups: %v\n", *manageUsername, strings.Join(roles, " "), strings.Join(groups, " "))
+}
+
+// Unlock logins with the username or from the client IP given by the flags, forgetting their failed attempts.
+func unlockLogins() {
+	ctx := context.Background()
+	unlocks := []struct {
+		name        string
+		throttleKey string
+	}{
+		{*unlockUsername, database.UsernameThrottleKey(*unlockUsername)},
+		{*unlockIP, database.IPThrottleKey(*unlockIP)},
+	}
+	for _, unlock := range unlocks {
+		if unlock.name == "" {
+			continue
+		}
+
+		unlocked, err := databaseManager.ResetLoginThrottle(ctx, unlock.throttleKey)
+		if err != nil {
+			slog.Error("Error during unlock of logins", "ThrottleKey", unlock.throttleKey, "Error", err)
+			fmt.Fprintf(os.Stderr, "Could not unlock %v: %v\n", unlock.name, err)
+			return
+		}
+
+		slog.Info("Logins unlocked", "ThrottleKey", unlock.throttleKey, "HadFailures", unlocked)
+		if unlocked {
+			fmt.Printf("Unlocked %v\n", unlock.name)
+		} else {
+			fmt.Printf("%v has no failed logins to unlock\n", unlock.name)
+		}
+	}
+}
+
 func main() {
 	defer databaseManager.CloseDatabase()
 
+	if *registerClientID != "" {
+		registerClient()
+		return
+	}
+	if *manageUsername != "" {
+		manageUser()
+		return
+	}
+	if *unlockUsername != "" || *unlockIP != "" {
+		unlockLogins()
+		return
+	}
+
 	slog.Debug("Start Main Func")
 
 	router := chi.NewRouter()
 	router.Use(commonMiddleware.SlogLogger)
 	router.Use(commonMiddleware.RecoverWithInternalServerError)
 
-	authMaster := authenticationmaster.NewAuthenticationMaster(databaseManager, secretKey)
+	authMaster := authenticationmaster.NewAuthenticationMaster(databaseManager, keyring, *issuer, cookieConfig, sessionBackend, tokenClaims, passwordMailer)
+	authMaster.SetTrustedProxies(trustedProxies)
+	authMaster.SetPasswordPolicy(passwordPolicy)
 	router.Post("/api/register", authMaster.Register)
 	router.Post("/api/login", authMaster.Login)
+	router.Post("/api/login/mfa", authMaster.LoginMFA)
+	router.Post("/api/refresh", authMaster.Refresh)
+	router.Post("/api/logout", authMaster.Logout)
+	router.Post("/api/password", authMaster.ChangePassword)
+	router.Delete("/api/account", authMaster.DeleteAccount)
+	router.Post("/api/password-reset/request", authMaster.RequestPasswordReset)
+	router.Post("/api/password-reset", authMaster.ResetPassword)
+	router.Post("/api/email", authMaster.SetEmail)
+	router.Post("/api/email/verify", authMaster.VerifyEmail)
+	router.Post("/api/mfa/totp/enroll", authMaster.EnrollTOTP)
+	router.Post("/api/mfa/totp/confirm", authMaster.ConfirmTOTP)
+	router.Delete("/api/mfa/totp", authMaster.DisableTOTP)
+	router.Post("/api/mfa/recovery-codes", authMaster.RegenerateRecoveryCodes)
+	router.Post("/api/webauthn/register/begin", authMaster.BeginWebAuthnRegistration)
+	router.Post("/api/webauthn/register/finish", authMaster.FinishWebAuthnRegistration)
+	router.Post("/api/webauthn/login/begin", authMaster.BeginWebAuthnLogin)
+	router.Post("/api/webauthn/login/finish", authMaster.FinishWebAuthnLogin)
+	router.Get("/api/webauthn/credentials", authMaster.ListWebAuthnCredentials)
+	router.Delete("/api/webauthn/credentials", authMaster.DeleteWebAuthnCredential)
 	router.Get("/api/authenticate", authMaster.AuthenticateRequest)
+	router.HandleFunc("/api/forward-auth", authMaster.ForwardAuth)
+	router.Get("/api/forward-auth/return", authMaster.ForwardAuthReturn)
+	router.Get("/.well-known/jwks.json", authMaster.JWKS)
+	router.Get("/authorize", authMaster.Authorize)
+	router.Post("/api/authorize", authMaster.Authorize)
+	router.Post("/token", authMaster.Token)
+	router.Get("/userinfo", authMaster.UserInfo)
+	router.Post("/userinfo", authMaster.UserInfo)
+	router.Get("/.well-known/openid-configuration", authMaster.OpenIDConfiguration)
+	router.Post("/oauth/introspect", authMaster.Introspect)
 
 	content, _ := fs.Sub(webpages, "web")
 	fs := http.FS(content)
@@ -0,0 +1,256 @@
+// Package passwordpolicy checks new passwords against a configurable policy: a minimum length, character classes,
+// not containing the username, and a minimum estimated strength.
+//
+// A password is checked against every rule at once, so a user can be told everything wrong with their password together.
+package passwordpolicy
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// Usernames shorter than this are not blocked from appearing in passwords, as they would block too many passwords.
+	usernameMinLen int = 3
+
+	// Substrings at least this long which already appeared earlier in the password count as repeats.
+	repeatedSubstringMinLen int = 3
+
+	// Bits added by a character that is predictable from the characters before it, such as a repeat or a sequence.
+	predictableCharacterBits float64 = 1
+)
+
+// A class of characters a password may use.
+type CharacterClass string
+
+const (
+	Lowercase CharacterClass = "lowercase"
+	Uppercase CharacterClass = "uppercase"
+	Digit     CharacterClass = "digit"
+	Symbol    CharacterClass = "symbol"
+)
+
+var characterClasses = []CharacterClass{Lowercase, Uppercase, Digit, Symbol}
+
+// The number of characters in each class, as used to estimate strength. Letters outside ASCII are counted as ASCII letters,
+// and every other character as one of the 33 printable ASCII symbols (including space), so strength is underestimated rather than overestimated.
+var characterClassSizes = map[CharacterClass]int{
+	Lowercase: 26,
+	Uppercase: 26,
+	Digit:     10,
+	Symbol:    33,
+}
+
+// The phrases used in messages for each character class, such as "Password must contain an uppercase letter."
+var characterClassDescriptions = map[CharacterClass]string{
+	Lowercase: "a lowercase letter",
+	Uppercase: "an uppercase letter",
+	Digit:     "a digit",
+	Symbol:    "a symbol",
+}
+
+// Rows of a keyboard, along which runs of adjacent keys (such as "qwerty" or "asdf") are predictable.
+var keyboardRows = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./"}
+
+// A rule of the policy that a password may fail.
+type Rule string
+
+const (
+	MinLengthRule              Rule = "min_length"
+	MinCharacterClassesRule    Rule = "min_character_classes"
+	RequiredCharacterClassRule Rule = "required_character_class"
+	ContainsUsernameRule       Rule = "contains_username"
+	MinStrengthRule            Rule = "min_strength"
+)
+
+// A rule that a password failed, with a message explaining the rule to the user.
+// CharacterClass is only set for RequiredCharacterClassRule, giving the class the password is missing.
+type Failure struct {
+	Rule           Rule           `json:"rule"`
+	CharacterClass CharacterClass `json:"character_class,omitempty"`
+	Message        string         `json:"message"`
+}
+
+// The rules new passwords must meet. The zero Policy accepts every password.
+type Policy struct {
+	// Minimum number of characters (not bytes) in a password.
+	MinLength int
+
+	// Minimum number of different character classes (lowercase, uppercase, digits, and symbols) a password must use.
+	MinCharacterClasses int
+
+	// Character classes every password must use.
+	RequiredCharacterClasses []CharacterClass
+
+	// Whether passwords containing the username (ignoring case) are failed. Usernames shorter than 3 characters are allowed.
+	BlockUsername bool
+
+	// Minimum strength of a password, in bits, as estimated by EstimateStrength.
+	MinStrength float64
+}
+
+// Parse a space separated list of character classes, such as "lowercase uppercase digit symbol".
+//
+// Fails (and returns a non-nil error) if any character class is not supported.
+func ParseCharacterClasses(characterClassesList string) ([]CharacterClass, error) {
+	var parsedClasses []CharacterClass
+	for _, className := range strings.Fields(characterClassesList) {
+		characterClass := CharacterClass(strings.ToLower(className))
+		if !slices.Contains(characterClasses, characterClass) {
+			return nil, fmt.Errorf("unsupported character class %q, must be one of %v", className, characterClasses)
+		}
+		parsedClasses = append(parsedClasses, characterClass)
+	}
+
+	return parsedClasses, nil
+}
+
+// Check a new password of a user against the policy. Returns every rule the password fails, or nil if it meets the policy.
+func (policy Policy) Check(password string, username string) []Failure {
+	var failures []Failure
+
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		failures = append(failures, Failure{
+			Rule:    MinLengthRule,
+			Message: fmt.Sprintf("Password must be at least %v characters long.", policy.MinLength),
+		})
+	}
+
+	usedClasses := usedCharacterClasses(password)
+	if len(usedClasses) < policy.MinCharacterClasses {
+		failures = append(failures, Failure{
+			Rule:    MinCharacterClassesRule,
+			Message: fmt.Sprintf("Password must use at least %v of lowercase letters, uppercase letters, digits, and symbols.", policy.MinCharacterClasses),
+		})
+	}
+	for _, requiredClass := range policy.RequiredCharacterClasses {
+		if !slices.Contains(usedClasses, requiredClass) {
+			failures = append(failures, Failure{
+				Rule:           RequiredCharacterClassRule,
+				CharacterClass: requiredClass,
+				Message:        fmt.Sprintf("Password must contain %v.", characterClassDescriptions[requiredClass]),
+			})
+		}
+	}
+
+	if policy.BlockUsername && utf8.RuneCountInString(username) >= usernameMinLen &&
+		strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		failures = append(failures, Failure{
+			Rule:    ContainsUsernameRule,
+			Message: "Password must not contain the username.",
+		})
+	}
+
+	if policy.MinStrength > 0 && EstimateStrength(password) < policy.MinStrength {
+		failures = append(failures, Failure{
+			Rule:    MinStrengthRule,
+			Message: "Password is too easy to guess. Use a longer password, with less repetition and fewer sequences (such as \"abc\" or \"qwerty\").",
+		})
+	}
+
+	return failures
+}
+
+// Estimate the strength of a password, in bits of entropy.
+//
+// Each character adds log2 of the size of the pool of characters the password draws from (the total size of the character classes it uses),
+// as if every character were chosen at random. Characters that are predictable from those before them add only 1 bit:
+// repeats of the previous character ("aaa"), runs in alphabetical, numerical, or keyboard order ("abc", "321", "qwerty"),
+// and substrings of at least 3 characters that already appeared earlier in the password ("abcabc").
+//
+// This is only an estimate, and overestimates the strength of passwords made of dictionary words.
+func EstimateStrength(password string) float64 {
+	characters := []rune(password)
+	if len(characters) == 0 {
+		return 0
+	}
+
+	poolSize := 0
+	for _, characterClass := range usedCharacterClasses(password) {
+		poolSize += characterClassSizes[characterClass]
+	}
+	characterBits := math.Log2(float64(poolSize))
+
+	strength := characterBits
+	for i := 1; i < len(characters); i++ {
+		if repeatLen := repeatedSubstringLen(characters, i); repeatLen > 0 {
+			strength += float64(repeatLen) * predictableCharacterBits
+			i += repeatLen - 1
+			continue
+		}
+		if isPredictable(characters[i-1], characters[i]) {
+			strength += predictableCharacterBits
+			continue
+		}
+		strength += characterBits
+	}
+
+	return strength
+}
+
+// The length of the longest substring starting at index i that already appeared before index i,
+// or 0 if it is shorter than repeatedSubstringMinLen.
+func repeatedSubstringLen(characters []rune, i int) int {
+	previous := string(characters[:i])
+	repeatLen := 0
+	for end := i + repeatedSubstringMinLen; end <= len(characters); end++ {
+		if !strings.Contains(previous, string(characters[i:end])) {
+			break
+		}
+		repeatLen = end - i
+	}
+
+	return repeatLen
+}
+
+// Whether a character is predictable from the character before it, as a repeat of it or the next in a sequence.
+func isPredictable(previous rune, character rune) bool {
+	previous, character = unicode.ToLower(previous), unicode.ToLower(character)
+	if character == previous || character == previous+1 || character == previous-1 {
+		return true
+	}
+
+	for _, keyboardRow := range keyboardRows {
+		previousIndex := strings.IndexRune(keyboardRow, previous)
+		characterIndex := strings.IndexRune(keyboardRow, character)
+		if previousIndex >= 0 && characterIndex >= 0 && (characterIndex == previousIndex+1 || characterIndex == previousIndex-1) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The character classes used by a password, in the order of characterClasses.
+func usedCharacterClasses(password string) []CharacterClass {
+	used := make(map[CharacterClass]bool)
+	for _, character := range password {
+		used[characterClassOf(character)] = true
+	}
+
+	var usedClasses []CharacterClass
+	for _, characterClass := range characterClasses {
+		if used[characterClass] {
+			usedClasses = append(usedClasses, characterClass)
+		}
+	}
+
+	return usedClasses
+}
+
+func characterClassOf(character rune) CharacterClass {
+	switch {
+	case unicode.IsLower(character):
+		return Lowercase
+	case unicode.IsUpper(character):
+		return Uppercase
+	case unicode.IsDigit(character):
+		return Digit
+	default:
+		return Symbol
+	}
+}
@@ -0,0 +1,144 @@
+package passwordpolicy
+
+import (
+	"slices"
+	"testing"
+)
+
+// The rules in a list of failures, in order.
+func failedRules(failures []Failure) []Rule {
+	var rules []Rule
+	for _, failure := range failures {
+		rules = append(rules, failure.Rule)
+	}
+	return rules
+}
+
+func TestZeroPolicy(t *testing.T) {
+	var policy Policy
+	for _, password := range []string{"", "a", "John Smith", "aaaaaaaa"} {
+		if failures := policy.Check(password, "John Smith"); failures != nil {
+			t.Errorf("Zero policy failed password %q: %v", password, failures)
+		}
+	}
+}
+
+func TestMinLength(t *testing.T) {
+	policy := Policy{MinLength: 8}
+
+	if rules := failedRules(policy.Check("short", "")); !slices.Equal(rules, []Rule{MinLengthRule}) {
+		t.Errorf("Short password did not fail only min_length: %v", rules)
+	}
+	if failures := policy.Check("longenough", ""); failures != nil {
+		t.Errorf("Password of sufficient length failed: %v", failures)
+	}
+	// Length is counted in characters, not bytes
+	if rules := failedRules(policy.Check("ééééééé", "")); !slices.Equal(rules, []Rule{MinLengthRule}) {
+		t.Errorf("Password of 7 multi-byte characters did not fail min_length: %v", rules)
+	}
+}
+
+func TestCharacterClasses(t *testing.T) {
+	policy := Policy{MinCharacterClasses: 3}
+	if rules := failedRules(policy.Check("onlylower123", "")); !slices.Equal(rules, []Rule{MinCharacterClassesRule}) {
+		t.Errorf("Password with 2 character classes did not fail min_character_classes: %v", rules)
+	}
+	if failures := policy.Check("Lower&Upper", ""); failures != nil {
+		t.Errorf("Password with 3 character classes failed: %v", failures)
+	}
+
+	policy = Policy{RequiredCharacterClasses: []CharacterClass{Uppercase, Digit, Symbol}}
+	failures := policy.Check("lowercase1", "")
+	if len(failures) != 2 {
+		t.Fatalf("Password missing 2 required character classes did not fail twice: %v", failures)
+	}
+	for i, missingClass := range []CharacterClass{Uppercase, Symbol} {
+		if failures[i].Rule != RequiredCharacterClassRule || failures[i].CharacterClass != missingClass {
+			t.Errorf("Failure %v is not required_character_class for %v: %v", i, missingClass, failures[i])
+		}
+	}
+}
+
+func TestParseCharacterClasses(t *testing.T) {
+	parsedClasses, err := ParseCharacterClasses("lowercase  Uppercase digit symbol")
+	if err != nil {
+		t.Fatalf("Error while parsing character classes: %v", err)
+	}
+	if !slices.Equal(parsedClasses, []CharacterClass{Lowercase, Uppercase, Digit, Symbol}) {
+		t.Errorf("Parsed character classes are incorrect: %v", parsedClasses)
+	}
+
+	if _, err := ParseCharacterClasses("lowercase emoji"); err == nil {
+		t.Error("Parsing unsupported character class did not return an error")
+	}
+}
+
+func TestBlockUsername(t *testing.T) {
+	policy := Policy{BlockUsername: true}
+
+	if rules := failedRules(policy.Check("my-JOHNSMITH-password", "JohnSmith")); !slices.Equal(rules, []Rule{ContainsUsernameRule}) {
+		t.Errorf("Password containing username (in a different case) did not fail contains_username: %v", rules)
+	}
+	if failures := policy.Check("unrelated password", "JohnSmith"); failures != nil {
+		t.Errorf("Password not containing username failed: %v", failures)
+	}
+	if failures := policy.Check("a password", "a"); failures != nil {
+		t.Errorf("Password containing a short username failed: %v", failures)
+	}
+}
+
+func TestEstimateStrength(t *testing.T) {
+	if strength := EstimateStrength(""); strength != 0 {
+		t.Errorf("Empty password has non-zero strength %v", strength)
+	}
+
+	// Each random lowercase letter adds log2(26) bits
+	if strength := EstimateStrength("xkqm"); strength < 18.7 || strength > 18.9 {
+		t.Errorf("Strength of 4 random lowercase letters is not 4*log2(26): %v", strength)
+	}
+
+	weakerStronger := [][2]string{
+		{"aaaaaaaaaaaa", "xkqmwzbtpfvh"},
+		{"abcdefghijkl", "xkqmwzbtpfvh"},
+		{"987654321098", "739105826473"},
+		{"qwertyuiopas", "xkqmwzbtpfvh"},
+		{"xkqmxkqmxkqm", "xkqmwzbtpfvh"},
+		{"xkqmwzbtpfvh", "xkqmwzBt#fv4"},
+	}
+	for _, passwords := range weakerStronger {
+		if EstimateStrength(passwords[0]) >= EstimateStrength(passwords[1]) {
+			t.Errorf("Password %q is not estimated weaker than %q", passwords[0], passwords[1])
+		}
+	}
+}
+
+func TestMinStrength(t *testing.T) {
+	policy := Policy{MinStrength: 50}
+
+	for _, password := range []string{"password", "Password123", "qwertyuiop1234567890"} {
+		if rules := failedRules(policy.Check(password, "")); !slices.Equal(rules, []Rule{MinStrengthRule}) {
+			t.Errorf("Weak password %q did not fail min_strength: %v", password, rules)
+		}
+	}
+	for _, password := range []string{"correct horse battery staple", "Tr0ub4dor&3"} {
+		if failures := policy.Check(password, ""); failures != nil {
+			t.Errorf("Strong password %q failed: %v", password, failures)
+		}
+	}
+}
+
+func TestCheckReportsEveryFailure(t *testing.T) {
+	policy := Policy{
+		MinLength:                12,
+		MinCharacterClasses:      3,
+		RequiredCharacterClasses: []CharacterClass{Symbol},
+		BlockUsername:            true,
+		MinStrength:              50,
+	}
+
+	rules := failedRules(policy.Check("janedoe1", "JaneDoe"))
+	expectedRules := []Rule{MinLengthRule, MinCharacterClassesRule, RequiredCharacterClassRule, ContainsUsernameRule, MinStrengthRule}
+	if !slices.Equal(rules, expectedRules) {
+		t.Errorf("Password failing every rule did not report every failure: %v", rules)
+	}
+}
@@ -0,0 +1,97 @@
+package relyingparty
+
+import (
+	"context"
+	"slices"
+
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+type contextKey struct{}
+
+// The identity of the user an access token was issued to.
+//
+// Username, Roles, Groups, and Email are only set if AuthSSO is configured to add them to tokens (the -tokenClaims flag).
+// ClientID and Scope are only set for tokens issued to an OpenID Connect client.
+type Identity struct {
+	UserID   string
+	Username string
+	Roles    []string
+	Groups   []string
+	ClientID string
+	Scope    string
+
+	// The email address of the user (empty if the user has none), and whether the user has proven they receive mail there.
+	Email         string
+	EmailVerified bool
+}
+
+// Check if the user has the given role.
+func (identity Identity) HasRole(role string) bool {
+	return slices.Contains(identity.Roles, role)
+}
+
+// Check if the user is in the given group.
+func (identity Identity) InGroup(group string) bool {
+	return slices.Contains(identity.Groups, group)
+}
+
+// Get the identity of the user from a request context, as set by Verifier.Middleware.
+// Returns false if the context has no identity.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(contextKey{}).(Identity)
+	return identity, ok
+}
+
+// Add an identity to a context.
+func contextWithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+func identityFromToken(token jwt.Token) Identity {
+	return Identity{
+		UserID:   token.Subject(),
+		Username: stringClaim(token, "preferred_username"),
+		Roles:    stringListClaim(token, "roles"),
+		Groups:   stringListClaim(token, "groups"),
+		ClientID: stringClaim(token, "client_id"),
+		Scope:    stringClaim(token, "scope"),
+
+		Email:         stringClaim(token, "email"),
+		EmailVerified: boolClaim(token, "email_verified"),
+	}
+}
+
+func stringClaim(token jwt.Token, claimName string) string {
+	claim, ok := token.Get(claimName)
+	if !ok {
+		return ""
+	}
+	claimString, _ := claim.(string)
+	return claimString
+}
+
+func boolClaim(token jwt.Token, claimName string) bool {
+	claim, ok := token.Get(claimName)
+	if !ok {
+		return false
+	}
+	claimBool, _ := claim.(bool)
+	return claimBool
+}
+
+func stringListClaim(token jwt.Token, claimName string) []string {
+	claim, ok := token.Get(claimName)
+	if !ok {
+		return nil
+	}
+	claimList, _ := claim.([]interface{})
+
+	stringList := make([]string, 0, len(claimList))
+	for _, item := range claimList {
+		if itemString, ok := item.(string); ok {
+			stringList = append(stringList, itemString)
+		}
+	}
+	return stringList
+}
@@ -0,0 +1,62 @@
+package relyingparty
+
+import (
+	"net/http"
+	"strings"
+)
+
+// The name of the cookie AuthSSO sets the access token in, in cookie session mode.
+const accessTokenCookieName = "jwt"
+
+// Middleware (for net/http or chi) that verifies the access token of each request, from the Authorization header or
+// (for services sharing the cookie domain of AuthSSO) the access token cookie.
+//
+// Requests with a valid token are passed on with the Identity of the user in their context, see IdentityFromContext.
+// Requests without a valid token are rejected with 401.
+func (verifier *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString := tokenFromRequest(r)
+		if tokenString == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Token unauthorized.", http.StatusUnauthorized)
+			return
+		}
+
+		identity, err := verifier.Verify(tokenString)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Token unauthorized.", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(contextWithIdentity(r.Context(), identity)))
+	})
+}
+
+// Middleware (for use after Verifier.Middleware) that rejects requests from users without the given role with 403.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := IdentityFromContext(r.Context())
+			if !ok || !identity.HasRole(role) {
+				http.Error(w, "Forbidden.", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func tokenFromRequest(r *http.Request) string {
+	authorizationHeader := r.Header.Get("Authorization")
+	if len(authorizationHeader) > 7 && strings.EqualFold(authorizationHeader[:7], "Bearer ") {
+		return authorizationHeader[7:]
+	}
+
+	cookie, err := r.Cookie(accessTokenCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
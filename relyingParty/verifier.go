@@ -0,0 +1,160 @@
+// Package relyingparty verifies access tokens issued by AuthSSO in other services (relying parties), without contacting AuthSSO.
+//
+// Tokens are verified against the public keys AuthSSO publishes at /.well-known/jwks.json (for asymmetric signing keys),
+// or against the shared secret key (for HS256 signing keys). Verified requests carry the Identity of the user in their context.
+//
+// Verification is offline, so tokens revoked by logging out are still accepted until they expire,
+// and opaque sessions cannot be verified at all. Services that need either should use /oauth/introspect instead.
+package relyingparty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+const (
+	// The `typ` header of AuthSSO access tokens, which distinguishes them from ID tokens signed by the same keys.
+	accessTokenType string = "at+jwt"
+
+	// How often the key set is fetched again when none is configured.
+	// AuthSSO allows the key set to be cached for five minutes.
+	defaultJWKSRefreshInterval time.Duration = 5 * time.Minute
+)
+
+var (
+	ErrNoKeyConfigured       error = errors.New("one of JWKSURL or SharedKey must be configured")
+	ErrNoIssuerConfigured    error = errors.New("issuer must be configured")
+	ErrNotAccessToken        error = errors.New("token is not an access token")
+	ErrTokenVerificationFail error = errors.New("token verification failed")
+
+	errTokenIssuedToClient error = errors.New("token is issued to a client, and no audience is configured")
+)
+
+// The configuration of a Verifier.
+type Config struct {
+	// The issuer of tokens, as set by the -issuer flag of AuthSSO. Tokens from any other issuer are rejected.
+	Issuer string
+
+	// If not empty, tokens must be for this audience (the `aud` claim), as set by the -clientAudience flag of AuthSSO.
+	// This prevents tokens issued for other services being accepted.
+	//
+	// If empty, only tokens issued directly to users (by logging in to AuthSSO) are accepted, as for /api/authenticate
+	// without an audience. Tokens issued to any OpenID Connect client are rejected, so they cannot be replayed against this service.
+	Audience string
+
+	// The URL of the key set of AuthSSO, such as https://sso.example/.well-known/jwks.json.
+	// The key set is cached, and fetched again every JWKSRefreshInterval.
+	JWKSURL string
+
+	// How often the key set is fetched again. Defaults to five minutes.
+	JWKSRefreshInterval time.Duration
+
+	// The shared secret key of AuthSSO, for verifying tokens signed with HS256. Only used if JWKSURL is empty.
+	SharedKey []byte
+}
+
+// Verifies AuthSSO access tokens offline.
+type Verifier struct {
+	issuer    string
+	audience  string
+	keyOption jwt.ParseOption
+}
+
+// Create a new Verifier with the given configuration.
+//
+// If a JWKSURL is configured, the key set is fetched immediately, and then refreshed in the background until ctx is cancelled.
+//
+// Fails (and returns a non-nil error) if:
+// - No issuer is configured (ErrNoIssuerConfigured)
+// - Neither a JWKSURL nor a SharedKey is configured (ErrNoKeyConfigured)
+// - The key set cannot be fetched
+func NewVerifier(ctx context.Context, config Config) (*Verifier, error) {
+	if config.Issuer == "" {
+		return nil, ErrNoIssuerConfigured
+	}
+
+	verifier := &Verifier{
+		issuer:   config.Issuer,
+		audience: config.Audience,
+	}
+
+	switch {
+	case config.JWKSURL != "":
+		refreshInterval := config.JWKSRefreshInterval
+		if refreshInterval == 0 {
+			refreshInterval = defaultJWKSRefreshInterval
+		}
+
+		// The cache only checks for due refreshes once per refresh window, so the window must be no longer than the interval
+		keySetCache := jwk.NewCache(ctx, jwk.WithRefreshWindow(refreshInterval))
+		err := keySetCache.Register(config.JWKSURL, jwk.WithRefreshInterval(refreshInterval))
+		if err != nil {
+			return nil, err
+		}
+		_, err = keySetCache.Refresh(ctx, config.JWKSURL)
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch key set: %w", err)
+		}
+		verifier.keyOption = jwt.WithKeySet(jwk.NewCachedSet(keySetCache, config.JWKSURL))
+	case len(config.SharedKey) > 0:
+		verifier.keyOption = jwt.WithKey(jwa.HS256, config.SharedKey)
+	default:
+		return nil, ErrNoKeyConfigured
+	}
+
+	return verifier, nil
+}
+
+// Verify an access token, checking the signature, expiry, issuer, subject, and audience.
+// Without a configured audience, tokens issued to an OpenID Connect client are rejected (see Config.Audience).
+// Returns the identity of the user the token was issued to.
+//
+// Fails (and returns a non-nil error) if:
+// - The token is not an access token, such as an ID token (ErrNotAccessToken)
+// - The token fails any check (ErrTokenVerificationFail, wrapping the reason)
+func (verifier *Verifier) Verify(tokenString string) (Identity, error) {
+	message, err := jws.ParseString(tokenString)
+	if err != nil || len(message.Signatures()) != 1 {
+		return Identity{}, ErrTokenVerificationFail
+	}
+	if message.Signatures()[0].ProtectedHeaders().Type() != accessTokenType {
+		return Identity{}, ErrNotAccessToken
+	}
+
+	parseOptions := []jwt.ParseOption{
+		verifier.keyOption,
+		jwt.WithValidate(true),
+		jwt.WithIssuer(verifier.issuer),
+		jwt.WithRequiredClaim(jwt.SubjectKey),
+	}
+	if verifier.audience != "" {
+		parseOptions = append(parseOptions, jwt.WithAudience(verifier.audience))
+	}
+
+	token, err := jwt.ParseString(tokenString, parseOptions...)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", ErrTokenVerificationFail, err)
+	}
+	if verifier.audience == "" && !verifier.isFirstPartyToken(token) {
+		return Identity{}, fmt.Errorf("%w: %w", ErrTokenVerificationFail, errTokenIssuedToClient)
+	}
+
+	return identityFromToken(token), nil
+}
+
+// Whether a token was issued directly to the user (by logging in), rather than to an OpenID Connect client.
+// Tokens issued to a client name that client (the `client_id` claim), and are for the audience of that client.
+func (verifier *Verifier) isFirstPartyToken(token jwt.Token) bool {
+	if stringClaim(token, "client_id") != "" {
+		return false
+	}
+	audience := token.Audience()
+	return len(audience) == 0 || (len(audience) == 1 && audience[0] == verifier.issuer)
+}
@@ -0,0 +1,218 @@
+package relyingparty
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+const testIssuer = "https://sso.example"
+
+// Sign a token as AuthSSO would, with the given `typ` header and claims (on top of a valid issuer, subject, and expiry).
+// Claims given as nil are removed.
+func signTestToken(t *testing.T, algorithm jwa.SignatureAlgorithm, key interface{}, tokenType string, claims map[string]interface{}) string {
+	token, _ := jwt.NewBuilder().
+		Issuer(testIssuer).
+		Subject("userID").
+		IssuedAt(time.Now()).
+		Expiration(time.Now().Add(time.Minute)).
+		Build()
+	for claimName, claim := range claims {
+		if claim == nil {
+			token.Remove(claimName)
+			continue
+		}
+		token.Set(claimName, claim)
+	}
+
+	headers := jws.NewHeaders()
+	headers.Set(jws.TypeKey, tokenType)
+	headers.Set(jws.KeyIDKey, "testKey")
+	signedToken, err := jwt.Sign(token, jwt.WithKey(algorithm, key, jws.WithProtectedHeaders(headers)))
+	if err != nil {
+		t.Fatalf("Error while signing test token: %v", err)
+	}
+	return string(signedToken)
+}
+
+func TestVerifyWithJWKS(t *testing.T) {
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	publicKey, _ := jwk.FromRaw(&privateKey.PublicKey)
+	publicKey.Set(jwk.KeyIDKey, "testKey")
+	publicKey.Set(jwk.AlgorithmKey, jwa.ES256)
+	publicKeySet := jwk.NewSet()
+	publicKeySet.AddKey(publicKey)
+
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(publicKeySet)
+	}))
+	defer jwksServer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	verifier, err := NewVerifier(ctx, Config{
+		Issuer:   testIssuer,
+		Audience: "testAudience",
+		JWKSURL:  jwksServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("Error while creating verifier: %v", err)
+	}
+
+	identity, err := verifier.Verify(signTestToken(t, jwa.ES256, privateKey, accessTokenType, map[string]interface{}{
+		"aud":                []string{"testAudience"},
+		"preferred_username": "John Smith",
+		"roles":              []string{"admin"},
+		"email":              "john@example.com",
+		"email_verified":     true,
+	}))
+	if err != nil {
+		t.Fatalf("Valid token rejected: %v", err)
+	}
+	if identity.UserID != "userID" || identity.Username != "John Smith" || !slices.Equal(identity.Roles, []string{"admin"}) || !identity.EmailVerified {
+		t.Errorf("Identity does not match token: %+v", identity)
+	}
+
+	_, err = verifier.Verify(signTestToken(t, jwa.ES256, privateKey, accessTokenType, map[string]interface{}{
+		"aud": []string{"otherAudience"},
+	}))
+	if !errors.Is(err, ErrTokenVerificationFail) {
+		t.Errorf("Token for other audience did not return ErrTokenVerificationFail: %v", err)
+	}
+
+	_, err = verifier.Verify(signTestToken(t, jwa.ES256, privateKey, "JWT", map[string]interface{}{
+		"aud": []string{"testAudience"},
+	}))
+	if err != ErrNotAccessToken {
+		t.Errorf("ID token did not return ErrNotAccessToken: %v", err)
+	}
+
+	otherPrivateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, err = verifier.Verify(signTestToken(t, jwa.ES256, otherPrivateKey, accessTokenType, map[string]interface{}{
+		"aud": []string{"testAudience"},
+	}))
+	if !errors.Is(err, ErrTokenVerificationFail) {
+		t.Errorf("Token signed by unknown key did not return ErrTokenVerificationFail: %v", err)
+	}
+}
+
+func TestVerifyWithSharedKey(t *testing.T) {
+	sharedKey := []byte("testSharedKey")
+	verifier, err := NewVerifier(context.Background(), Config{
+		Issuer:    testIssuer,
+		SharedKey: sharedKey,
+	})
+	if err != nil {
+		t.Fatalf("Error while creating verifier: %v", err)
+	}
+
+	_, err = verifier.Verify(signTestToken(t, jwa.HS256, sharedKey, accessTokenType, nil))
+	if err != nil {
+		t.Errorf("Valid token rejected: %v", err)
+	}
+
+	_, err = verifier.Verify(signTestToken(t, jwa.HS256, sharedKey, accessTokenType, map[string]interface{}{
+		"iss": "otherIssuer",
+	}))
+	if !errors.Is(err, ErrTokenVerificationFail) {
+		t.Errorf("Token from other issuer did not return ErrTokenVerificationFail: %v", err)
+	}
+
+	_, err = verifier.Verify(signTestToken(t, jwa.HS256, sharedKey, accessTokenType, map[string]interface{}{
+		"exp": time.Now().Add(-time.Minute),
+	}))
+	if !errors.Is(err, ErrTokenVerificationFail) {
+		t.Errorf("Expired token did not return ErrTokenVerificationFail: %v", err)
+	}
+
+	_, err = verifier.Verify(signTestToken(t, jwa.HS256, sharedKey, accessTokenType, map[string]interface{}{
+		"sub": nil,
+	}))
+	if !errors.Is(err, ErrTokenVerificationFail) {
+		t.Errorf("Token without subject did not return ErrTokenVerificationFail: %v", err)
+	}
+}
+
+func TestVerifyWithoutAudience(t *testing.T) {
+	sharedKey := []byte("testSharedKey")
+	verifier, _ := NewVerifier(context.Background(), Config{
+		Issuer:    testIssuer,
+		SharedKey: sharedKey,
+	})
+
+	// Without an audience configured, only tokens issued directly to users are accepted
+	_, err := verifier.Verify(signTestToken(t, jwa.HS256, sharedKey, accessTokenType, map[string]interface{}{
+		"aud": []string{testIssuer},
+	}))
+	if err != nil {
+		t.Errorf("Token for the issuer rejected: %v", err)
+	}
+
+	_, err = verifier.Verify(signTestToken(t, jwa.HS256, sharedKey, accessTokenType, map[string]interface{}{
+		"aud": []string{"otherAudience"},
+	}))
+	if !errors.Is(err, ErrTokenVerificationFail) {
+		t.Errorf("Token for other audience did not return ErrTokenVerificationFail: %v", err)
+	}
+
+	_, err = verifier.Verify(signTestToken(t, jwa.HS256, sharedKey, accessTokenType, map[string]interface{}{
+		"client_id": "otherClient",
+	}))
+	if !errors.Is(err, ErrTokenVerificationFail) {
+		t.Errorf("Token issued to client did not return ErrTokenVerificationFail: %v", err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	sharedKey := []byte("testSharedKey")
+	verifier, _ := NewVerifier(context.Background(), Config{
+		Issuer:    testIssuer,
+		SharedKey: sharedKey,
+	})
+
+	var identity Identity
+	handler := verifier.Middleware(RequireRole("admin")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ = IdentityFromContext(r.Context())
+	})))
+
+	for _, testCase := range []struct {
+		name           string
+		roles          []string
+		expectedStatus int
+	}{
+		{"admin", []string{"admin"}, http.StatusOK},
+		{"not admin", []string{"editor"}, http.StatusForbidden},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Authorization", "Bearer "+signTestToken(t, jwa.HS256, sharedKey, accessTokenType, map[string]interface{}{
+			"roles": testCase.roles,
+		}))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != testCase.expectedStatus {
+			t.Errorf("Request from %v user returned status %v, expected %v", testCase.name, recorder.Code, testCase.expectedStatus)
+		}
+	}
+	if identity.UserID != "userID" {
+		t.Errorf("Identity not added to request context: %+v", identity)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Request without token returned status %v, expected %v", recorder.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,112 @@
+// Package totp implements time-based one-time passwords (RFC 6238), the six digit codes shown by authenticator apps.
+//
+// Codes use the defaults every authenticator app supports: HMAC-SHA1, six digits, and a thirty second period.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+const (
+	// Number of random bytes in a secret, the length of an HMAC-SHA1 key recommended by RFC 4226.
+	SecretLen int = 20
+
+	// Number of digits in a code.
+	Digits int = 6
+
+	// How long each code is valid for.
+	Period time.Duration = 30 * time.Second
+
+	// Codes from this many periods either side of the current period are also accepted,
+	// allowing for drift between the clocks of the server and the authenticator app.
+	Skew int64 = 1
+)
+
+// Secrets are given to users in unpadded base32, as expected by authenticator apps.
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Generate a new random secret.
+//
+// This function can error if a kernel function errors, although this should never happen.
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, SecretLen)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// Encode a secret in base32, so users can enter it into an authenticator app by hand.
+func EncodeSecret(secret []byte) string {
+	return secretEncoding.EncodeToString(secret)
+}
+
+// Create the otpauth:// URI of a secret, which authenticator apps read from a QR code.
+// The issuer and account name are shown in the app to identify the code.
+func KeyURI(issuer string, accountName string, secret []byte) string {
+	query := url.Values{
+		"secret":    {EncodeSecret(secret)},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(Digits)},
+		"period":    {fmt.Sprint(int(Period.Seconds()))},
+	}
+
+	keyURI := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + accountName,
+		RawQuery: query.Encode(),
+	}
+	return keyURI.String()
+}
+
+// The time step (the number of periods since the Unix epoch) at a time.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Generate the code of a secret for a time step (RFC 4226, section 5.3).
+func GenerateCode(secret []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	truncated := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulus := uint32(1)
+	for range Digits {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, truncated%modulus)
+}
+
+// Validate a code against a secret at a time, accepting codes from up to Skew periods either side.
+// Returns the time step the code is for, so callers can reject codes that have already been used.
+func Validate(secret []byte, code string, t time.Time) (int64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	currentStep := Step(t)
+	for step := currentStep - Skew; step <= currentStep+Skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(GenerateCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
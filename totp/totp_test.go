@@ -0,0 +1,76 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// The SHA1 test vectors of RFC 6238 appendix B, truncated to six digits.
+func TestGenerateCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	testVectors := []struct {
+		unixTime int64
+		code     string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, testVector := range testVectors {
+		code := GenerateCode(secret, Step(time.Unix(testVector.unixTime, 0)))
+		if code != testVector.code {
+			t.Errorf("Code at %v is %v, expected %v", testVector.unixTime, code, testVector.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("Error during secret generation %v", err)
+	}
+	now := time.Now()
+
+	step, ok := Validate(secret, GenerateCode(secret, Step(now)), now)
+	if !ok || step != Step(now) {
+		t.Errorf("Current code is not valid")
+	}
+
+	_, ok = Validate(secret, GenerateCode(secret, Step(now)-Skew), now)
+	if !ok {
+		t.Errorf("Code from within skew is not valid")
+	}
+
+	_, ok = Validate(secret, GenerateCode(secret, Step(now)+Skew+1), now)
+	if ok {
+		t.Errorf("Code from beyond skew is valid")
+	}
+
+	_, ok = Validate(secret, "12345", now)
+	if ok {
+		t.Errorf("Code of wrong length is valid")
+	}
+}
+
+func TestKeyURI(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	keyURI, err := url.Parse(KeyURI("AuthSSO", "alice@example.com", secret))
+	if err != nil {
+		t.Fatalf("Error parsing key URI %v", err)
+	}
+
+	if keyURI.Scheme != "otpauth" || keyURI.Host != "totp" || keyURI.Path != "/AuthSSO:alice@example.com" {
+		t.Errorf("Key URI has unexpected label %v", keyURI)
+	}
+	if keyURI.Query().Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" {
+		t.Errorf("Key URI has unexpected secret %v", keyURI.Query().Get("secret"))
+	}
+	if keyURI.Query().Get("issuer") != "AuthSSO" {
+		t.Errorf("Key URI has unexpected issuer %v", keyURI.Query().Get("issuer"))
+	}
+}
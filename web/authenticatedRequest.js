@@ -1,23 +1,121 @@
-async function makeAuthenticatedRequest() {
-    const token = localStorage.getItem('token');
+async function refreshTokens() {
+    // Without a stored refresh token, the server falls back to the refresh token cookie
+    const refreshToken = localStorage.getItem('refreshToken');
 
-    if (!token) {
-        window.location.href = '/login.html';
+    const response = await fetch('/api/refresh', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ refresh_token: refreshToken || undefined })
+    })
+    if (!response.ok) {
+        localStorage.removeItem('token');
+        localStorage.removeItem('refreshToken');
+        return false;
     }
 
-    const headerElement = document.getElementById("header");
-    const infoElement = document.getElementById("info");
+    const tokens = await response.json();
+    if (tokens.access_token) {
+        localStorage.setItem('token', tokens.access_token);
+        localStorage.setItem('refreshToken', tokens.refresh_token);
+    }
+    return true;
+}
 
-    const response = await fetch("/api/authenticate", {
+async function authenticate() {
+    return fetch("/api/authenticate", {
         method: 'GET',
+        headers: authorizationHeaders(),
+    })
+}
+
+async function logout() {
+    await fetch('/api/logout', {
+        method: 'POST',
+        headers: {
+            ...authorizationHeaders(),
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ refresh_token: localStorage.getItem('refreshToken') || undefined })
+    })
+    localStorage.removeItem('token');
+    localStorage.removeItem('refreshToken');
+    window.location.href = '/login.html';
+}
+
+// Register a passkey for the logged in user, so they can log in with it instead of (or as well as) their password.
+async function registerPasskey() {
+    const infoElement = document.getElementById("passkeyMessage");
+
+    // A passkey can log in without the password, so the password is needed to add one
+    const password = window.prompt("Enter your password to add a passkey.");
+    if (!password) {
+        return;
+    }
+
+    let response = await fetch('/api/webauthn/register/begin', {
+        method: 'POST',
         headers: {
-            'Authorization': `Bearer ${token}`,
+            ...authorizationHeaders(),
+            'Content-Type': 'application/json',
         },
+        body: JSON.stringify({ password: password })
     })
+    if (response.ok) {
+        const ceremony = await response.json();
+        let credential;
+        try {
+            credential = await createPasskey(ceremony.options);
+        } catch (error) {
+            infoElement.innerHTML = "Passkey was not created.";
+            return;
+        }
+
+        response = await fetch('/api/webauthn/register/finish', {
+            method: 'POST',
+            headers: {
+                ...authorizationHeaders(),
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ ceremony_token: ceremony.ceremony_token, credential: credential })
+        })
+    }
+    if (!response.ok) {
+        infoElement.innerHTML = await response.text();
+        return;
+    }
+
+    // The first second factor a user enrolls comes with recovery codes, which are only shown once
+    const enrollment = await response.json();
+    infoElement.innerText = enrollment.message;
+    if (enrollment.recovery_codes) {
+        const codesElement = document.createElement("pre");
+        codesElement.innerText = enrollment.recovery_codes.join("\n");
+        infoElement.appendChild(codesElement);
+    }
+}
+
+async function makeAuthenticatedRequest() {
+    const headerElement = document.getElementById("header");
+    const infoElement = document.getElementById("info");
+
+    let response = await authenticate();
+    if (response.status == 401 && await refreshTokens()) {
+        response = await authenticate();
+    }
     if (response.ok) {
         const userInformation = await response.json();
         headerElement.innerHTML = `Welcome, ${userInformation.Username}.`;
-        infoElement.innerHTML = "You have logged in successfully.";
+        infoElement.innerHTML = 'You have logged in successfully. <a href="#" id="logout">Log out</a> or <a href="#" id="registerPasskey">add a passkey</a>.<p id="passkeyMessage"></p>';
+        document.getElementById("logout").addEventListener("click", function (event) {
+            event.preventDefault();
+            logout();
+        });
+        document.getElementById("registerPasskey").addEventListener("click", function (event) {
+            event.preventDefault();
+            registerPasskey();
+        });
     } else {
         headerElement.innerHTML = "You are not logged in.";
         infoElement.innerHTML = '<a href="/login.html" style="border-radius: 0.5em; background-color: var(--pico-primary-background); color: var(--pico-contrast); text-decoration: none; padding: 0.2em;">Log in</a></small>';
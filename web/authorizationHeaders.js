@@ -0,0 +1,9 @@
+// In cookie mode the tokens are held in HttpOnly cookies rather than localStorage,
+// so the Authorization header is only sent when there is a token to send.
+function authorizationHeaders() {
+    const token = localStorage.getItem('token');
+    if (!token) {
+        return {};
+    }
+    return { 'Authorization': `Bearer ${token}` };
+}
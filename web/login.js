@@ -1,3 +1,74 @@
+// If the login page was reached from an OpenID Connect authorization request,
+// the original request parameters are in the query string.
+const authorizationParameters = new URLSearchParams(window.location.search);
+const isAuthorizationRequest = authorizationParameters.has("client_id");
+
+// If the login page was reached from a site protected by forward auth, the URL to return to is in the query string.
+// The server checks the URL is safe to return to, so the user is sent there through the server.
+const returnURL = authorizationParameters.get("return_to");
+
+// Repeat the authorization request now the user is logged in, and follow the redirect back to the client.
+// If the user is not (or no longer) logged in, the login form is left for them to use.
+async function continueAuthorization() {
+    const errorMessageElement = document.getElementById("errorMessage");
+
+    const response = await fetch('/api/authorize', {
+        method: 'POST',
+        headers: {
+            ...authorizationHeaders(),
+            'Content-Type': 'application/x-www-form-urlencoded',
+        },
+        body: authorizationParameters.toString()
+    })
+    if (response.status == 401) {
+        return;
+    }
+    if (response.ok) {
+        const authorization = await response.json();
+        window.location.href = authorization.redirect_uri;
+    } else {
+        errorMessageElement.style.display = "block";
+        errorMessageElement.innerHTML = await response.text();
+    }
+}
+
+// The MFA token from a login by a user with two-factor authentication, exchanged (with a code or passkey) for the tokens.
+let mfaToken = null;
+
+// Whether the code entered in the MFA form is a recovery code, rather than a code from an authenticator app.
+let useRecoveryCode = false;
+
+// Store the tokens from a successful login, then continue to wherever the user was going.
+async function completeLogin(response) {
+    // In cookie mode the tokens are set as HttpOnly cookies and are not in the response
+    const tokens = await response.json();
+    if (tokens.mfa_required) {
+        mfaToken = tokens.mfa_token;
+        const totpAllowed = tokens.mfa_methods.includes("totp");
+        document.getElementById("loginForm").style.display = "none";
+        document.getElementById("mfaForm").style.display = "block";
+        document.getElementById("mfaCode").style.display = totpAllowed ? "block" : "none";
+        document.getElementById("mfaCodeSubmit").style.display = totpAllowed ? "block" : "none";
+        document.getElementById("mfaPasskey").style.display = tokens.mfa_methods.includes("webauthn") ? "block" : "none";
+        document.getElementById("mfaRecoveryCode").style.display = tokens.mfa_methods.includes("recovery_code") ? "inline" : "none";
+        if (totpAllowed) {
+            document.getElementById("Code").focus();
+        }
+        return;
+    }
+    if (tokens.access_token) {
+        localStorage.setItem('token', tokens.access_token);
+        localStorage.setItem('refreshToken', tokens.refresh_token);
+    }
+    if (isAuthorizationRequest) {
+        continueAuthorization();
+    } else if (returnURL) {
+        window.location.href = '/api/forward-auth/return?return_to=' + encodeURIComponent(returnURL);
+    } else {
+        window.location.href = '/authenticated.html';
+    }
+}
+
 async function loginRequest() {
     const username = document.getElementById("Username").value;
     const password = document.getElementById("Password").value;
@@ -16,11 +87,70 @@ async function loginRequest() {
         },
         body: JSON.stringify(loginData)
     })
-    const responseText = await response.text()
     if (response.status == 200) {
-        localStorage.setItem('token', responseText);
-        window.location.href = '/authenticated.html';
+        completeLogin(response);
     } else {
+        const responseText = await response.text()
+        errorMessageElement.style.display = "block";
+        errorMessageElement.innerHTML = responseText;
+    }
+}
+
+async function mfaRequest() {
+    const code = document.getElementById("Code").value;
+    const errorMessageElement = document.getElementById("mfaErrorMessage");
+    errorMessageElement.style.display = "none";
+
+    const response = await fetch('/api/login/mfa', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify(useRecoveryCode ? { mfa_token: mfaToken, recovery_code: code } : { mfa_token: mfaToken, code: code })
+    })
+    if (response.status == 200) {
+        completeLogin(response);
+    } else {
+        const responseText = await response.text()
+        errorMessageElement.style.display = "block";
+        errorMessageElement.innerHTML = responseText;
+    }
+}
+
+// Log in with a passkey, either on its own (without an MFA token) or as the second factor after a password.
+async function passkeyRequest(errorMessageElement) {
+    errorMessageElement.style.display = "none";
+
+    let response = await fetch('/api/webauthn/login/begin', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ mfa_token: mfaToken || undefined })
+    })
+    if (response.status == 200) {
+        const ceremony = await response.json();
+        let credential;
+        try {
+            credential = await getPasskey(ceremony.options);
+        } catch (error) {
+            errorMessageElement.style.display = "block";
+            errorMessageElement.innerHTML = "Passkey was not given.";
+            return;
+        }
+
+        response = await fetch('/api/webauthn/login/finish', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ ceremony_token: ceremony.ceremony_token, mfa_token: mfaToken || undefined, credential: credential })
+        })
+    }
+    if (response.status == 200) {
+        completeLogin(response);
+    } else {
+        const responseText = await response.text()
         errorMessageElement.style.display = "block";
         errorMessageElement.innerHTML = responseText;
     }
@@ -29,4 +159,34 @@ async function loginRequest() {
 document.getElementById("loginForm").addEventListener("submit", function(event) {
     event.preventDefault();
     loginRequest();
-});
\ No newline at end of file
+});
+
+document.getElementById("mfaForm").addEventListener("submit", function(event) {
+    event.preventDefault();
+    mfaRequest();
+});
+
+document.getElementById("passkeyLogin").addEventListener("click", function() {
+    passkeyRequest(document.getElementById("errorMessage"));
+});
+
+document.getElementById("mfaPasskey").addEventListener("click", function() {
+    passkeyRequest(document.getElementById("mfaErrorMessage"));
+});
+
+document.getElementById("mfaRecoveryCode").addEventListener("click", function(event) {
+    event.preventDefault();
+    useRecoveryCode = true;
+    document.getElementById("mfaCode").style.display = "block";
+    document.getElementById("mfaCodeSubmit").style.display = "block";
+    document.getElementById("Code").placeholder = "Enter one of your recovery codes";
+    document.getElementById("Code").inputMode = "text";
+    document.getElementById("Code").focus();
+    this.style.display = "none";
+});
+
+// Users who are already logged in do not need to log in again to authorize a client.
+// In cookie mode the token cannot be seen here, so the request is always tried.
+if (isAuthorizationRequest) {
+    continueAuthorization();
+}
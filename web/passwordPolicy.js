@@ -0,0 +1,11 @@
+// The error message of a failed request that set a new password. Passwords that do not meet the password policy
+// are refused with a JSON list of the rules they fail, which are shown one per line.
+async function passwordErrorMessage(response) {
+    if (!response.headers.get('Content-Type')?.startsWith('application/json')) {
+        return await response.text();
+    }
+
+    const policyResponse = await response.json();
+    const failedRules = policyResponse.failed_rules || [];
+    return [policyResponse.message, ...failedRules.map(failure => failure.message)].join('\n');
+}
@@ -15,7 +15,8 @@ async function registerRequest() {
 
     const registerData = {
         username: document.getElementById("Username").value,
-        password: password
+        password: password,
+        email: document.getElementById("Email").value
     };
 
     const response = await fetch('/api/register', {
@@ -30,9 +31,9 @@ async function registerRequest() {
     if (response.status == 201) {
         window.location.href = '/login.html';
     } else {
-        const errorMessage = await response.text()
+        const errorMessage = await passwordErrorMessage(response)
         errorMessageElement.style.display = "block";
-        errorMessageElement.innerHTML = errorMessage;
+        errorMessageElement.innerText = errorMessage;
     }
 }
 
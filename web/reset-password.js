@@ -0,0 +1,61 @@
+// If the page was reached from a password reset link, the reset token is in the query string.
+const resetToken = new URLSearchParams(window.location.search).get("token");
+
+function showMessage(message) {
+    const errorMessageElement = document.getElementById("errorMessage");
+    errorMessageElement.style.display = "block";
+    errorMessageElement.innerText = message;
+}
+
+async function requestResetRequest() {
+    document.getElementById("errorMessage").style.display = "none";
+
+    const response = await fetch('/api/password-reset/request', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ username: document.getElementById("Username").value })
+    })
+    showMessage(await response.text());
+}
+
+async function resetPasswordRequest() {
+    const password = document.getElementById("Password").value;
+    const confirmPassword = document.getElementById("confirm_password").value;
+    document.getElementById("errorMessage").style.display = "none";
+
+    if (password !== confirmPassword) {
+        showMessage("Passwords do not match.");
+        return;
+    }
+
+    const response = await fetch('/api/password-reset', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ token: resetToken, new_password: password })
+    })
+    if (response.ok) {
+        window.location.href = '/login.html';
+    } else {
+        showMessage(await passwordErrorMessage(response));
+    }
+}
+
+if (resetToken) {
+    document.getElementById("instructions").innerText = "Choose a new password for your account.";
+    document.getElementById("requestResetForm").style.display = "none";
+    document.getElementById("resetPasswordForm").style.display = "block";
+}
+
+document.getElementById("requestResetForm").addEventListener("submit", function (event) {
+    event.preventDefault();
+    requestResetRequest();
+});
+
+document.getElementById("resetPasswordForm").addEventListener("submit", function (event) {
+    event.preventDefault();
+    resetPasswordRequest();
+});
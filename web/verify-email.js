@@ -0,0 +1,35 @@
+// The verification token is in the query string of the link sent to the email address.
+// The token is only submitted when the button is pressed, so links opened by mail scanners do not verify the address.
+const verificationToken = new URLSearchParams(window.location.search).get("token");
+
+function showMessage(message) {
+    const errorMessageElement = document.getElementById("errorMessage");
+    errorMessageElement.style.display = "block";
+    errorMessageElement.innerText = message;
+}
+
+async function verifyEmailRequest() {
+    document.getElementById("errorMessage").style.display = "none";
+
+    const response = await fetch('/api/email/verify', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ token: verificationToken })
+    })
+    showMessage(await response.text());
+    if (response.ok) {
+        document.getElementById("verifyEmailForm").style.display = "none";
+    }
+}
+
+if (!verificationToken) {
+    document.getElementById("instructions").innerText = "Follow the link sent to your email address to verify it.";
+    document.getElementById("verifyEmailForm").style.display = "none";
+}
+
+document.getElementById("verifyEmailForm").addEventListener("submit", function (event) {
+    event.preventDefault();
+    verifyEmailRequest();
+});
@@ -0,0 +1,56 @@
+// Helpers for passkeys. The server gives (and expects back) binary fields as unpadded base64url strings,
+// but the browser WebAuthn API works with ArrayBuffers.
+
+function base64urlToBuffer(base64url) {
+    const base64 = base64url.replace(/-/g, '+').replace(/_/g, '/');
+    const binary = atob(base64.padEnd(base64.length + (4 - base64.length % 4) % 4, '='));
+    return Uint8Array.from(binary, c => c.charCodeAt(0)).buffer;
+}
+
+function bufferToBase64url(buffer) {
+    const binary = String.fromCharCode(...new Uint8Array(buffer));
+    return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+
+// Create a passkey from the options given by /api/webauthn/register/begin, encoded to send to /api/webauthn/register/finish.
+async function createPasskey(options) {
+    const publicKey = options.publicKey;
+    publicKey.challenge = base64urlToBuffer(publicKey.challenge);
+    publicKey.user.id = base64urlToBuffer(publicKey.user.id);
+    for (const credential of publicKey.excludeCredentials || []) {
+        credential.id = base64urlToBuffer(credential.id);
+    }
+
+    const credential = await navigator.credentials.create({ publicKey: publicKey });
+    return {
+        id: credential.id,
+        rawId: bufferToBase64url(credential.rawId),
+        type: credential.type,
+        response: {
+            clientDataJSON: bufferToBase64url(credential.response.clientDataJSON),
+            attestationObject: bufferToBase64url(credential.response.attestationObject),
+        },
+    };
+}
+
+// Sign with a passkey using the options given by /api/webauthn/login/begin, encoded to send to /api/webauthn/login/finish.
+async function getPasskey(options) {
+    const publicKey = options.publicKey;
+    publicKey.challenge = base64urlToBuffer(publicKey.challenge);
+    for (const credential of publicKey.allowCredentials || []) {
+        credential.id = base64urlToBuffer(credential.id);
+    }
+
+    const credential = await navigator.credentials.get({ publicKey: publicKey });
+    return {
+        id: credential.id,
+        rawId: bufferToBase64url(credential.rawId),
+        type: credential.type,
+        response: {
+            clientDataJSON: bufferToBase64url(credential.response.clientDataJSON),
+            authenticatorData: bufferToBase64url(credential.response.authenticatorData),
+            signature: bufferToBase64url(credential.response.signature),
+            userHandle: credential.response.userHandle ? bufferToBase64url(credential.response.userHandle) : undefined,
+        },
+    };
+}